@@ -69,6 +69,7 @@ type GenericCell struct {
 	healthy                     bool
 	totalLeafCellNum            int32                  // total leaf cell number of a cell
 	usedLeafCellNumAtPriorities map[CellPriority]int32 // leaf cell number used by each priority
+	changes                     *cellChanges           // records the status changes of the cell to watch
 }
 
 func (c *GenericCell) GetChain() CellChain {
@@ -119,6 +120,10 @@ func (c *GenericCell) GetUsedLeafCellNumAtPriorities() map[CellPriority]int32 {
 	return c.usedLeafCellNumAtPriorities
 }
 
+func (c *GenericCell) SetChanges(changes *cellChanges) {
+	c.changes = changes
+}
+
 func (c *GenericCell) IncreaseUsedLeafCellNumAtPriority(p CellPriority, delta int32) {
 	c.usedLeafCellNumAtPriorities[p] += delta
 	if c.usedLeafCellNumAtPriorities[p] == 0 {
@@ -136,6 +141,7 @@ type PhysicalCell struct {
 	virtualCell              *VirtualCell       // points to the bound virtual cell
 	split                    bool               // true when the cell has been split
 	pinned                   bool               // true when this is a pinned cell
+	draining                 bool               // true when the cell (or any of its ancestors) is draining
 	// This status only contains the statuses that need to be exposed to external,
 	// and should not be used for internal status management
 	apiStatus *api.PhysicalCellStatus
@@ -185,6 +191,7 @@ func (c *PhysicalCell) SetChildren(children CellList) {
 }
 
 func (c *PhysicalCell) SetPriority(p CellPriority) {
+	c.changes.add(c)
 	c.priority = p
 	c.apiStatus.CellPriority = int32(p)
 	if c.apiStatus.VirtualCell != nil {
@@ -193,9 +200,11 @@ func (c *PhysicalCell) SetPriority(p CellPriority) {
 }
 
 func (c *PhysicalCell) SetState(s CellState) {
+	c.changes.add(c)
 	c.state = s
 	c.apiStatus.CellState = api.CellState(s)
 	if c.virtualCell != nil {
+		c.virtualCell.changes.add(c.virtualCell)
 		c.virtualCell.state = s
 		c.virtualCell.apiStatus.CellState = api.CellState(s)
 		c.apiStatus.VirtualCell.CellState = api.CellState(s)
@@ -264,6 +273,7 @@ func (c *PhysicalCell) GetVirtualCell() *VirtualCell {
 }
 
 func (c *PhysicalCell) SetVirtualCell(cell *VirtualCell) {
+	c.changes.add(c)
 	c.virtualCell = cell
 	if cell == nil {
 		c.apiStatus.VirtualCell = nil
@@ -295,15 +305,28 @@ func (c *PhysicalCell) SetPinned(pinned bool) {
 	c.pinned = pinned
 }
 
+func (c *PhysicalCell) IsDraining() bool {
+	return c.draining
+}
+
+func (c *PhysicalCell) SetDraining(draining bool) {
+	klog.Infof("Cell %v is set to draining: %v", c.address, draining)
+	c.changes.add(c)
+	c.draining = draining
+	c.apiStatus.CellDraining = draining
+}
+
 func (c *PhysicalCell) GetAPIStatus() *api.PhysicalCellStatus {
 	return c.apiStatus
 }
 
 func (c *PhysicalCell) SetHealthiness(h api.CellHealthiness) {
 	klog.Infof("Cell %v is set to %v", c.address, h)
+	c.changes.add(c)
 	c.healthy = h == api.CellHealthy
 	c.apiStatus.CellHealthiness = h
 	if c.virtualCell != nil {
+		c.virtualCell.changes.add(c.virtualCell)
 		c.virtualCell.healthy = c.healthy
 		c.apiStatus.VirtualCell.CellHealthiness = h
 		c.virtualCell.GetAPIStatus().CellHealthiness = h
@@ -371,6 +394,7 @@ func (c *VirtualCell) SetChildren(children CellList) {
 }
 
 func (c *VirtualCell) SetPriority(p CellPriority) {
+	c.changes.add(c)
 	c.priority = p
 	c.apiStatus.CellPriority = int32(p)
 	if c.apiStatus.PhysicalCell != nil {
@@ -399,6 +423,7 @@ func (c *VirtualCell) GetPhysicalCell() *PhysicalCell {
 }
 
 func (c *VirtualCell) SetPhysicalCell(cell *PhysicalCell) {
+	c.changes.add(c)
 	c.physicalCell = cell
 	if cell == nil {
 		c.apiStatus.PhysicalCell = nil
@@ -209,6 +209,10 @@ func getUsablePhysicalCells(
 		if c.GetVirtualCell() != nil {
 			continue
 		}
+		// skip the cell if it is draining (a draining cell is only used by its current groups)
+		if c.IsDraining() {
+			continue
+		}
 		// skip the cell if it is a bad node
 		nodes, _ := c.GetPhysicalPlacement()
 		if len(nodes) == 1 && !c.IsHealthy() {
@@ -212,8 +212,9 @@ func (c *physicalCellConstructor) buildFullTree() *PhysicalCell {
 		panic(fmt.Sprintf("top cell must be node-level or above: %v", cc))
 	}
 	cellInstance := c.buildChildCell(c.buildingSpec, api.CellType(cc), "")
-	// set leaf cell type only for top-level cells (as a chain shares the same leaf cell type)
+	// set leaf cell type and chain only for top-level cells (as a chain shares the same leaf cell type)
 	cellInstance.GetAPIStatus().LeafCellType = ce.leafCellType
+	cellInstance.GetAPIStatus().CellChain = string(cc)
 	return cellInstance
 }
 
@@ -346,8 +347,9 @@ func (c *virtualCellConstructor) buildFullTree(address api.CellAddress) *Virtual
 		panic(fmt.Sprintf("cellType %v in VirtualCells is not found in cell types definition", c.buildingChild))
 	}
 	cellInstance := c.buildChildCell(c.buildingChild, address)
-	// set leaf cell type only for top-level cells (as a chain shares the same leaf cell type)
+	// set leaf cell type and chain only for top-level cells (as a chain shares the same leaf cell type)
 	cellInstance.GetAPIStatus().LeafCellType = ce.leafCellType
+	cellInstance.GetAPIStatus().CellChain = string(c.buildingChain)
 	return cellInstance
 }
 
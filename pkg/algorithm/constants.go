@@ -38,6 +38,19 @@ const (
 	lowestLevel  CellLevel = 1
 	highestLevel CellLevel = math.MaxInt32
 
+	// max number of the most recent scheduling attempts kept for each affinity group
+	maxSchedulingAttemptsPerGroup = 10
+
+	// max number of the most recent watch events kept for the watches to resume from
+	maxWatchEvents = 10000
+
+	// max number of the usage records kept until they are taken
+	maxUsageRecords = 10000
+
+	// the preemptor recorded in the LazyPreemptionStatus of the affinity groups
+	// lazy preempted by admin API
+	adminPreemptor = "admin"
+
 	// internal cell states
 
 	// No affinity group is using, reserving, or has reserved the cell.
@@ -24,7 +24,10 @@ package algorithm
 
 import (
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/microsoft/hivedscheduler/pkg/api"
 	"github.com/microsoft/hivedscheduler/pkg/common"
@@ -54,6 +57,8 @@ type HivedAlgorithm struct {
 
 	// number of free preassigned cells of each VC of each cell type
 	vcFreeCellNum map[api.VirtualClusterName]map[CellChain]map[CellLevel]int32
+	// number of all preassigned cells of each VC of each cell type (only used for metrics)
+	vcCellNum map[api.VirtualClusterName]map[CellChain]map[CellLevel]int32
 	// total number of preassigned free cells in all the VCs of each cell type
 	allVCFreeCellNum map[CellChain]map[CellLevel]int32
 	// Number of cells left in the physical cluster of each cell type
@@ -94,12 +99,57 @@ type HivedAlgorithm struct {
 
 	// bad nodes in the physical cluster
 	badNodes common.Set
+	// policy to decide whether a node is healthy
+	nodeHealthPolicy *internal.NodeHealthPolicy
+	// bad nodes which have turned healthy but are still in the healthy damping period,
+	// mapped to the dampings to mark them as healthy
+	dampingHealthyNodes map[string]healthyDamping
+	// clock to time the healthy damping, which is faked in tests
+	clock clock
+	// bad leaf cell indices in each node (reported by node annotation), which are
+	// considered as bad even if the node itself is healthy
+	badLeafCellIndices map[string]common.Set
+	// draining nodes (labeled by node) and draining cells (set by admin API) in the physical cluster
+	drainingNodes common.Set
+	drainingCells common.Set
+	// draining cells persisted in the node annotation, for each node, which only drain
+	// the part of the cells in the node
+	nodeDrainingCells map[string]common.Set
+	// bad cells (set by admin API) in the physical cluster, which are considered as bad
+	// together with all the cells in them, regardless of their node healthiness
+	badCells common.Set
+	// bad cells persisted in the node annotation, for each node
+	nodeBadCells map[string]common.Set
 	// map each leaf cell type to all chains that contain this type
 	cellChains map[string][]CellChain
 	// map each level in a chain to the specific cell type name
 	cellTypes map[CellChain]map[CellLevel]api.CellType
 	// cluster status exposed to external
 	apiClusterStatus api.ClusterStatus
+	// affinity groups lazy preempted by the pod being scheduled, only valid within Schedule
+	lazyPreemptedGroups []string
+	// scheduling attempt of the pod being scheduled, only valid within Schedule
+	schedulingAttempt *api.SchedulingAttempt
+	// most recent scheduling attempts of each affinity group (only used for explanation)
+	schedulingAttempts map[string][]api.SchedulingAttempt
+	// total numbers of preemptions and lazy preemptions (only used for metrics)
+	preemptionCount     uint64
+	lazyPreemptionCount uint64
+	// cells changed since the last watch events were generated
+	cellChanges *cellChanges
+	// affinity groups changed since the last watch events were generated
+	groupChanges map[string]bool
+	// affinity groups reported by the watch events
+	watchedGroups map[string]watchedGroup
+	// most recent watch events, and the ResourceVersion of the last one
+	watchEvents     []api.WatchEvent
+	resourceVersion uint64
+	// closed and replaced once there are new watch events
+	newWatchEvents chan struct{}
+	// usage changes of the affinity groups since the usage records were last taken,
+	// which are only recorded after they are taken for the first time
+	usageRecords   []api.UsageRecord
+	usageRecording bool
 	// lock
 	algorithmLock sync.RWMutex
 }
@@ -115,15 +165,26 @@ func NewHivedAlgorithm(sConfig *api.Config) *HivedAlgorithm {
 		fullCellList:            fullPcl,
 		freeCellList:            freePcl,
 		vcFreeCellNum:           vcFreeCellNum,
+		vcCellNum:               map[api.VirtualClusterName]map[CellChain]map[CellLevel]int32{},
 		allVCFreeCellNum:        map[CellChain]map[CellLevel]int32{},
 		totalLeftCellNum:        map[CellChain]map[CellLevel]int32{},
 		badFreeCells:            map[CellChain]ChainCellList{},
 		vcDoomedBadCells:        map[api.VirtualClusterName]map[CellChain]ChainCellList{},
 		allVCDoomedBadCellNum:   map[CellChain]map[CellLevel]int32{},
 		badNodes:                common.NewSet(),
+		nodeHealthPolicy:        internal.NewNodeHealthPolicy(sConfig.NodeHealthPolicy),
+		dampingHealthyNodes:     map[string]healthyDamping{},
+		clock:                   realClock{},
+		badLeafCellIndices:      map[string]common.Set{},
+		drainingNodes:           common.NewSet(),
+		drainingCells:           common.NewSet(),
+		nodeDrainingCells:       map[string]common.Set{},
+		nodeBadCells:            map[string]common.Set{},
+		badCells:                common.NewSet(),
 		cellChains:              chains,
 		cellTypes:               cellTypes,
 		affinityGroups:          map[string]*AlgoAffinityGroup{},
+		schedulingAttempts:      map[string][]api.SchedulingAttempt{},
 		apiClusterStatus: api.ClusterStatus{
 			PhysicalCluster: api.PhysicalClusterStatus{},
 			VirtualClusters: map[api.VirtualClusterName]api.VirtualClusterStatus{},
@@ -139,16 +200,23 @@ func NewHivedAlgorithm(sConfig *api.Config) *HivedAlgorithm {
 	}
 	h.initCellNums()
 	h.initAPIClusterStatus()
+	h.initVCCellNum()
 	h.initPinnedCells(pinnedPcl)
 	h.initBadNodes()
+	h.initWatchEvents()
 	return h
 }
 
 func (h *HivedAlgorithm) AddNode(node *core.Node) {
 	h.algorithmLock.Lock()
 	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
 
-	if !internal.IsNodeHealthy(node) {
+	h.setBadLeafCells(node.Name, internal.ExtractNodeBadLeafCellIndices(node))
+	h.setNodeDraining(node.Name, internal.IsNodeDraining(node))
+	h.setNodeDrainingCells(node.Name, internal.ExtractNodeDrainingCells(node))
+	h.setNodeBadCells(node.Name, internal.ExtractNodeBadCells(node))
+	if !h.nodeHealthPolicy.IsNodeHealthy(node) {
 		// adding a bad node
 		h.setBadNode(node.Name)
 	} else {
@@ -160,12 +228,19 @@ func (h *HivedAlgorithm) AddNode(node *core.Node) {
 func (h *HivedAlgorithm) UpdateNode(oldNode, newNode *core.Node) {
 	h.algorithmLock.Lock()
 	defer h.algorithmLock.Unlock()
-
-	if oldHealthy := internal.IsNodeHealthy(oldNode); oldHealthy != internal.IsNodeHealthy(newNode) {
+	defer h.generateWatchEvents()
+
+	h.setBadLeafCells(newNode.Name, internal.ExtractNodeBadLeafCellIndices(newNode))
+	h.setNodeDraining(newNode.Name, internal.IsNodeDraining(newNode))
+	h.setNodeDrainingCells(newNode.Name, internal.ExtractNodeDrainingCells(newNode))
+	h.setNodeBadCells(newNode.Name, internal.ExtractNodeBadCells(newNode))
+	oldHealthy := h.nodeHealthPolicy.IsNodeHealthy(oldNode)
+	if newHealthy := h.nodeHealthPolicy.IsNodeHealthy(newNode); oldHealthy != newHealthy {
 		if oldHealthy {
 			h.setBadNode(newNode.Name)
 		} else {
-			h.setHealthyNode(newNode.Name)
+			// damp the healthy transition to avoid churning the bad cells on a flapping node
+			h.setHealthyNodeWithDamping(newNode.Name)
 		}
 	}
 }
@@ -173,8 +248,233 @@ func (h *HivedAlgorithm) UpdateNode(oldNode, newNode *core.Node) {
 func (h *HivedAlgorithm) DeleteNode(node *core.Node) {
 	h.algorithmLock.Lock()
 	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
 
 	h.setBadNode(node.Name)
+	delete(h.badLeafCellIndices, node.Name)
+	h.drainingNodes.Delete(node.Name)
+	delete(h.nodeDrainingCells, node.Name)
+	delete(h.nodeBadCells, node.Name)
+}
+
+// Stop stops the pending healthy damping timers, so that they will not change
+// the scheduling view anymore after the algorithm is replaced.
+func (h *HivedAlgorithm) Stop() {
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+
+	for nodeName := range h.dampingHealthyNodes {
+		h.stopHealthyDamping(nodeName)
+	}
+}
+
+// Inherit inherits the status of the replaced algorithm which cannot be recovered from the
+// nodes and pods, i.e. the healthy damping of the nodes and the recent scheduling attempts.
+// It should be called after the nodes and pods are recovered.
+func (h *HivedAlgorithm) Inherit(replaced internal.SchedulerAlgorithm) {
+	old, ok := replaced.(*HivedAlgorithm)
+	if !ok {
+		return
+	}
+	old.algorithmLock.RLock()
+	defer old.algorithmLock.RUnlock()
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
+
+	now := h.clock.Now()
+	for nodeName, d := range old.dampingHealthyNodes {
+		// the node has been recovered as healthy, so it is marked as bad again until
+		// the rest of the damping elapses
+		if damping := d.deadline.Sub(now); damping > 0 && !h.badNodes.Contains(nodeName) {
+			h.setBadNode(nodeName)
+			h.dampHealthyNode(nodeName, damping)
+		}
+	}
+	for name, attempts := range old.schedulingAttempts {
+		if _, ok := h.schedulingAttempts[name]; !ok {
+			h.schedulingAttempts[name] = append([]api.SchedulingAttempt{}, attempts...)
+		}
+	}
+}
+
+// SetCellDraining drains or undrains a physical cell (and all the cells in it) by its address,
+// or the node-level cell by the node name.
+func (h *HivedAlgorithm) SetCellDraining(address api.CellAddress, draining bool) {
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
+
+	c := h.findAdminPhysicalCell(address)
+	if draining {
+		h.drainingCells.Add(c.GetAddress())
+	} else {
+		h.drainingCells.Delete(c.GetAddress())
+	}
+	h.updateLeafCellsInCell(c)
+}
+
+// SetCellBad marks a physical cell (and all the cells in it) as bad by its address,
+// or the node-level cell by the node name. Unmarking it lets its healthiness
+// follow the node again.
+func (h *HivedAlgorithm) SetCellBad(address api.CellAddress, bad bool) {
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
+
+	c := h.findAdminPhysicalCell(address)
+	if bad {
+		h.badCells.Add(c.GetAddress())
+	} else {
+		h.badCells.Delete(c.GetAddress())
+	}
+	h.updateLeafCellsInCell(c)
+}
+
+// CancelAffinityGroupPreemption revokes the preemption of a preempting affinity group,
+// so that the victims will not be preempted by it anymore.
+func (h *HivedAlgorithm) CancelAffinityGroupPreemption(name string) {
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
+
+	g := h.affinityGroups[name]
+	if g == nil || g.state != groupPreempting {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"Affinity group %v is not preempting", name)))
+	}
+	var pod *core.Pod
+	for _, p := range g.preemptingPods {
+		pod = p
+		break
+	}
+	if pod == nil {
+		panic(fmt.Errorf("Preempting affinity group %v has no preempting pod", name))
+	}
+	klog.Infof("[%v]: Canceling affinity group %v's preemption by admin", internal.Key(pod), g.name)
+	h.deletePreemptingAffinityGroup(g, pod)
+}
+
+// SetAffinityGroupLazyPreempted lazy preempts an allocated affinity group from its VC,
+// or reverts its lazy preemption if its physical placement can still be mapped back
+// to the VC. The group is left unchanged if it is being preempted or cannot be reverted.
+func (h *HivedAlgorithm) SetAffinityGroupLazyPreempted(name string, lazyPreempted bool) {
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
+
+	g := h.affinityGroups[name]
+	if g == nil || g.state == groupPreempting {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"Affinity group %v is not allocated", name)))
+	}
+	for _, podPlacements := range g.physicalLeafCellPlacement {
+		for _, podPlacement := range podPlacements {
+			for _, leafCell := range podPlacement {
+				if leafCell != nil && leafCell.(*PhysicalCell).GetState() == cellReserving {
+					panic(internal.NewBadRequestError(fmt.Sprintf(
+						"Affinity group %v is being preempted by %v", name,
+						leafCell.(*PhysicalCell).GetReservingOrReservedGroup().name)))
+				}
+			}
+		}
+	}
+	if lazyPreempted {
+		if g.virtualLeafCellPlacement == nil {
+			panic(internal.NewBadRequestError(fmt.Sprintf(
+				"Affinity group %v is opportunistic or already lazy preempted", name)))
+		}
+		h.lazyPreemptAffinityGroup(g, adminPreemptor)
+		h.lazyPreemptionCount++
+	} else {
+		if g.lazyPreemptionStatus == nil {
+			panic(internal.NewBadRequestError(fmt.Sprintf(
+				"Affinity group %v is not lazy preempted", name)))
+		}
+		if message := h.unlazyPreemptAffinityGroup(g); message != "" {
+			panic(internal.NewBadRequestError(fmt.Sprintf(
+				"Lazy preemption of affinity group %v cannot be reverted: %v", name, message)))
+		}
+	}
+}
+
+// findAdminPhysicalCell finds the physical cell referred by the admin API,
+// i.e. by its address or by the node name for the node-level cell.
+func (h *HivedAlgorithm) findAdminPhysicalCell(address api.CellAddress) *PhysicalCell {
+	c := findPhysicalCell(h.fullCellList, address)
+	if c == nil {
+		c = findNodeLevelPhysicalCell(h.fullCellList, string(address))
+	}
+	if c == nil {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"Physical cell or node %v does not exist", address)))
+	}
+	return c
+}
+
+// TrySchedule is the same as Schedule except that the error is returned instead
+// of panic, see internal.TypedErrorSchedulerAlgorithm.
+func (h *HivedAlgorithm) TrySchedule(
+	pod *core.Pod,
+	suggestedNodes []string,
+	phase internal.SchedulingPhase) (result internal.PodScheduleResult, err error) {
+
+	defer internal.RecoverAsError(&err)
+	return h.Schedule(pod, suggestedNodes, phase), nil
+}
+
+// ValidatePod checks the pod scheduling spec against the current cluster, without
+// changing any state, see internal.ValidatingSchedulerAlgorithm.
+func (h *HivedAlgorithm) ValidatePod(pod *core.Pod) {
+	h.algorithmLock.RLock()
+	defer h.algorithmLock.RUnlock()
+
+	s := internal.ExtractPodSchedulingSpec(pod)
+	h.validateSchedulingRequest(schedulingRequest{
+		vc:           s.VirtualCluster,
+		pinnedCellId: s.PinnedCellId,
+		priority:     CellPriority(s.Priority),
+	}, pod)
+	if s.PinnedCellId == "" && s.LeafCellType != "" {
+		if _, ok := h.cellChains[s.LeafCellType]; !ok {
+			panic(internal.NewInvalidSpecError(fmt.Sprintf(
+				"[%v]: Pod requesting leaf cell type %v which the whole cluster does not have",
+				internal.Key(pod), s.LeafCellType)))
+		}
+		if CellPriority(s.Priority) >= minGuaranteedPriority {
+			vcHasType := false
+			for _, chain := range h.cellChains[s.LeafCellType] {
+				if h.vcSchedulers[s.VirtualCluster].getNonPinnedPreassignedCells()[chain] != nil {
+					vcHasType = true
+				}
+			}
+			if !vcHasType {
+				panic(internal.NewInvalidSpecError(fmt.Sprintf(
+					"[%v]: Pod requesting leaf cell type %v which VC %v does not have",
+					internal.Key(pod), s.LeafCellType, s.VirtualCluster)))
+			}
+		}
+	}
+
+	// The pod joining an existing affinity group should be consistent with the
+	// pods already in it.
+	if g := h.affinityGroups[s.AffinityGroup.Name]; g != nil {
+		podNums := map[int32]int32{}
+		for _, m := range s.AffinityGroup.Members {
+			podNums[m.LeafCellNumber] += m.PodNumber
+		}
+		if !reflect.DeepEqual(podNums, g.totalPodNums) {
+			panic(internal.NewInvalidSpecError(fmt.Sprintf(
+				"[%v]: Pod requesting affinity group %v members %v which is inconsistent "+
+					"with the existing members %v", internal.Key(pod), g.name,
+				common.ToJson(podNums), common.ToJson(g.totalPodNums))))
+		}
+		if g.state == groupAllocated && getNewPodIndex(g.allocatedPods[s.LeafCellNumber]) == -1 {
+			panic(internal.NewInvalidSpecError(fmt.Sprintf(
+				"Requesting more pods than the configured number for %v leaf cells (%v pods) in affinity group %v",
+				s.LeafCellNumber, g.totalPodNums[s.LeafCellNumber], s.AffinityGroup.Name)))
+		}
+	}
 }
 
 func (h *HivedAlgorithm) Schedule(
@@ -184,9 +484,21 @@ func (h *HivedAlgorithm) Schedule(
 
 	h.algorithmLock.Lock()
 	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
 
 	klog.Infof("[%v]: Scheduling pod in %v phase...", internal.Key(pod), phase)
+	h.lazyPreemptedGroups = nil
 	s := internal.ExtractPodSchedulingSpec(pod)
+	attempt := &api.SchedulingAttempt{Time: meta.Now(), Pod: internal.Key(pod), Phase: string(phase)}
+	h.schedulingAttempt = attempt
+	defer func() {
+		if r := recover(); r != nil {
+			attempt.Result = api.SchedulingAttemptError
+			attempt.Reason = fmt.Sprint(r)
+			h.recordSchedulingAttempt(s.AffinityGroup.Name, attempt)
+			panic(r)
+		}
+	}()
 	suggestedNodeSet := common.NewSet()
 	for _, n := range suggestedNodes {
 		suggestedNodeSet.Add(n)
@@ -206,6 +518,7 @@ func (h *HivedAlgorithm) Schedule(
 	)
 
 	if g := h.affinityGroups[s.AffinityGroup.Name]; g != nil {
+		attempt.ExistingGroupState = api.AffinityGroupState(g.state)
 		groupPhysicalPlacement, groupVirtualPlacement, preemptionVictims, podIndex =
 			h.schedulePodFromExistingGroup(g, s, suggestedNodeSet, phase, pod)
 	}
@@ -215,7 +528,7 @@ func (h *HivedAlgorithm) Schedule(
 		groupPhysicalPlacement, groupVirtualPlacement, preemptionVictims, waitReason =
 			h.schedulePodFromNewGroup(s, suggestedNodeSet, phase, pod)
 	}
-	return generatePodScheduleResult(
+	result := generatePodScheduleResult(
 		groupPhysicalPlacement,
 		groupVirtualPlacement,
 		preemptionVictims,
@@ -227,6 +540,28 @@ func (h *HivedAlgorithm) Schedule(
 		s.AffinityGroup.Name,
 		suggestedNodeSet,
 		pod)
+	result.LazyPreemptedPods = h.getLazyPreemptedPods()
+	attempt.PreemptionVictims = victimsToKeys(preemptionVictims)
+	if result.PodBindInfo != nil {
+		attempt.Result = api.SchedulingAttemptBind
+	} else if result.PodPreemptInfo != nil {
+		attempt.Result = api.SchedulingAttemptPreempt
+	} else {
+		result.PodWaitInfo.ErrorReason = getWaitErrorReason(attempt)
+		attempt.Result = api.SchedulingAttemptWait
+		attempt.Reason = result.PodWaitInfo.Reason
+	}
+	h.recordSchedulingAttempt(s.AffinityGroup.Name, attempt)
+	return result
+}
+
+// getWaitErrorReason categorizes why the pod has to wait by the last step of its
+// scheduling attempt, since the failed reason of the last step is the wait reason.
+func getWaitErrorReason(attempt *api.SchedulingAttempt) api.ErrorReason {
+	if n := len(attempt.Steps); n > 0 && attempt.Steps[n-1].BadOrNonSuggestedNodes != nil {
+		return api.ErrorReasonBadOrNonSuggestedNodes
+	}
+	return api.ErrorReasonCapacityExhausted
 }
 
 func (h *HivedAlgorithm) AddUnallocatedPod(*core.Pod) {
@@ -235,12 +570,18 @@ func (h *HivedAlgorithm) AddUnallocatedPod(*core.Pod) {
 func (h *HivedAlgorithm) DeleteUnallocatedPod(pod *core.Pod) {
 	h.algorithmLock.Lock()
 	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
 
 	s := internal.ExtractPodSchedulingSpec(pod)
+	if h.affinityGroups[s.AffinityGroup.Name] == nil {
+		// the waiting group may never be scheduled again
+		delete(h.schedulingAttempts, s.AffinityGroup.Name)
+	}
 	if g := h.affinityGroups[s.AffinityGroup.Name]; g != nil && g.state == groupPreempting {
 		if g.preemptingPods[pod.UID] != nil {
 			klog.Infof("[%v]: Deleting preempting pod from affinity group %v...", internal.Key(pod), g.name)
 			delete(g.preemptingPods, pod.UID)
+			h.recordGroupChange(g.name)
 		}
 		if len(g.preemptingPods) == 0 {
 			klog.Infof("[%v]: Canceling affinity group %v's preemption because its pods are all deleted",
@@ -253,6 +594,7 @@ func (h *HivedAlgorithm) DeleteUnallocatedPod(pod *core.Pod) {
 func (h *HivedAlgorithm) AddAllocatedPod(pod *core.Pod) {
 	h.algorithmLock.Lock()
 	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
 
 	s := internal.ExtractPodSchedulingSpec(pod)
 	info := internal.ExtractPodBindInfo(pod)
@@ -273,11 +615,13 @@ func (h *HivedAlgorithm) AddAllocatedPod(pod *core.Pod) {
 		h.createAllocatedAffinityGroup(s, info, pod)
 	}
 	h.affinityGroups[s.AffinityGroup.Name].allocatedPods[s.LeafCellNumber][podIndex] = pod
+	h.recordGroupChange(s.AffinityGroup.Name)
 }
 
 func (h *HivedAlgorithm) DeleteAllocatedPod(pod *core.Pod) {
 	h.algorithmLock.Lock()
 	defer h.algorithmLock.Unlock()
+	defer h.generateWatchEvents()
 
 	s := internal.ExtractPodSchedulingSpec(pod)
 	info := internal.ExtractPodBindInfo(pod)
@@ -294,6 +638,7 @@ func (h *HivedAlgorithm) DeleteAllocatedPod(pod *core.Pod) {
 			return
 		} else {
 			g.allocatedPods[s.LeafCellNumber][podIndex] = nil
+			h.recordGroupChange(g.name)
 		}
 		if allPodsReleased(g.allocatedPods) {
 			h.deleteAllocatedAffinityGroup(g, pod)
@@ -365,7 +710,229 @@ func (h *HivedAlgorithm) GetVirtualClusterStatus(vcn api.VirtualClusterName) api
 	if vcs, ok := h.apiClusterStatus.VirtualClusters[vcn]; ok {
 		return vcs.DeepCopy()
 	}
-	panic(internal.NewBadRequestError(fmt.Sprintf("VC %v not found", vcn)))
+	panic(internal.NewUnknownVirtualClusterError(fmt.Sprintf("VC %v not found", vcn)))
+}
+
+func (h *HivedAlgorithm) GetMetrics() internal.AlgorithmMetrics {
+	h.algorithmLock.RLock()
+	defer h.algorithmLock.RUnlock()
+
+	m := internal.AlgorithmMetrics{
+		AffinityGroups: map[api.AffinityGroupState]int32{
+			api.AffinityGroupState(groupAllocated):      0,
+			api.AffinityGroupState(groupPreempting):     0,
+			api.AffinityGroupState(groupBeingPreempted): 0,
+		},
+		PreemptionCount:     h.preemptionCount,
+		LazyPreemptionCount: h.lazyPreemptionCount,
+	}
+	for vc, vcCellNum := range h.vcCellNum {
+		for chain, chainCellNum := range vcCellNum {
+			for level, levelCellNum := range chainCellNum {
+				free := h.vcFreeCellNum[vc][chain][level]
+				m.VirtualClusterCells = append(m.VirtualClusterCells, internal.VirtualClusterCellMetrics{
+					VirtualCluster: vc,
+					Chain:          string(chain),
+					CellType:       h.cellTypes[chain][level],
+					Free:           free,
+					Used:           levelCellNum - free,
+					DoomedBad:      int32(len(h.vcDoomedBadCells[vc][chain][level])),
+				})
+			}
+		}
+	}
+	sort.SliceStable(m.VirtualClusterCells, func(i, j int) bool {
+		a, b := m.VirtualClusterCells[i], m.VirtualClusterCells[j]
+		if a.VirtualCluster != b.VirtualCluster {
+			return a.VirtualCluster < b.VirtualCluster
+		}
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		return a.CellType < b.CellType
+	})
+	for chain, ccl := range h.fullCellList {
+		usedLeafCellNums := map[CellPriority]int32{}
+		for _, c := range ccl[CellLevel(len(ccl))] {
+			for p, num := range c.GetUsedLeafCellNumAtPriorities() {
+				usedLeafCellNums[p] += num
+			}
+		}
+		for p, num := range usedLeafCellNums {
+			m.UsedLeafCells = append(m.UsedLeafCells, internal.UsedLeafCellMetrics{
+				Chain:    string(chain),
+				Priority: int32(p),
+				Number:   num,
+			})
+		}
+	}
+	sort.SliceStable(m.UsedLeafCells, func(i, j int) bool {
+		a, b := m.UsedLeafCells[i], m.UsedLeafCells[j]
+		if a.Chain != b.Chain {
+			return a.Chain < b.Chain
+		}
+		return a.Priority < b.Priority
+	})
+	for _, g := range h.affinityGroups {
+		m.AffinityGroups[api.AffinityGroupState(g.state)]++
+	}
+	return m
+}
+
+// GetClusterSummary summarizes the leaf cell usages of the physical cluster and
+// each VC by leaf cell type, see internal.SummarizingSchedulerAlgorithm.
+func (h *HivedAlgorithm) GetClusterSummary() api.ClusterSummary {
+	h.algorithmLock.RLock()
+	defer h.algorithmLock.RUnlock()
+
+	leafCellTypes := map[CellChain]string{}
+	for leafCellType, chains := range h.cellChains {
+		for _, chain := range chains {
+			leafCellTypes[chain] = leafCellType
+		}
+	}
+	summary := api.ClusterSummary{
+		PhysicalCluster: map[string]*api.LeafCellSummary{},
+		VirtualClusters: map[api.VirtualClusterName]map[string]*api.LeafCellSummary{},
+	}
+	getSummary := func(vcn api.VirtualClusterName, chain CellChain) *api.LeafCellSummary {
+		summaries := summary.PhysicalCluster
+		if vcn != "" {
+			summaries = summary.VirtualClusters[vcn]
+		}
+		s := summaries[leafCellTypes[chain]]
+		if s == nil {
+			s = &api.LeafCellSummary{UsedByPriority: map[int32]int32{}}
+			summaries[leafCellTypes[chain]] = s
+		}
+		return s
+	}
+	addUsedLeafCells := func(s *api.LeafCellSummary, c Cell) {
+		for p, num := range c.GetUsedLeafCellNumAtPriorities() {
+			s.UsedByPriority[int32(p)] += num
+			if p >= minGuaranteedPriority {
+				s.Used += num
+			}
+		}
+	}
+	addVirtualLeafCell := func(s *api.LeafCellSummary, c *VirtualCell) {
+		if c.GetAPIStatus().CellHealthiness == api.CellBad {
+			s.Bad++
+		}
+		if pc := c.GetPhysicalCell(); pc != nil && pc.IsDraining() {
+			s.Draining++
+		}
+	}
+
+	for chain, ccl := range h.fullCellList {
+		s := getSummary("", chain)
+		for _, c := range ccl[CellLevel(len(ccl))] {
+			s.Total += c.GetTotalLeafCellNum()
+			addUsedLeafCells(s, c)
+		}
+		for _, c := range ccl[lowestLevel] {
+			if c.(*PhysicalCell).GetAPIStatus().CellHealthiness == api.CellBad {
+				s.Bad++
+			}
+			if c.(*PhysicalCell).IsDraining() {
+				s.Draining++
+			}
+		}
+		for level, num := range h.allVCDoomedBadCellNum[chain] {
+			s.DoomedBad += num * ccl[level][0].GetTotalLeafCellNum()
+		}
+		s.Opportunistic = s.UsedByPriority[int32(opportunisticPriority)]
+	}
+	for vcn, vcs := range h.vcSchedulers {
+		summary.VirtualClusters[vcn] = map[string]*api.LeafCellSummary{}
+		for chain, chainCellNum := range h.vcCellNum[vcn] {
+			s := getSummary(vcn, chain)
+			for level, num := range chainCellNum {
+				s.Quota += num * h.fullCellList[chain][level][0].GetTotalLeafCellNum()
+			}
+			for level, cells := range h.vcDoomedBadCells[vcn][chain] {
+				s.DoomedBad += int32(len(cells)) * h.fullCellList[chain][level][0].GetTotalLeafCellNum()
+			}
+		}
+		for chain, ccl := range vcs.getNonPinnedPreassignedCells() {
+			s := getSummary(vcn, chain)
+			for _, cl := range ccl {
+				for _, c := range cl {
+					addUsedLeafCells(s, c)
+				}
+			}
+		}
+		for chain, ccl := range vcs.getNonPinnedFullCellList() {
+			s := getSummary(vcn, chain)
+			for _, c := range ccl[lowestLevel] {
+				addVirtualLeafCell(s, c.(*VirtualCell))
+			}
+		}
+		for _, ccl := range vcs.getPinnedCells() {
+			pinnedCell := ccl[CellLevel(len(ccl))][0]
+			s := getSummary(vcn, pinnedCell.GetChain())
+			s.Quota += pinnedCell.GetTotalLeafCellNum()
+			addUsedLeafCells(s, pinnedCell)
+			for _, c := range ccl[lowestLevel] {
+				addVirtualLeafCell(s, c.(*VirtualCell))
+			}
+		}
+	}
+	// the opportunistic and lazy preempted groups have no virtual placement, so
+	// their usages are only tracked in the physical cells
+	for _, g := range h.affinityGroups {
+		if g.virtualLeafCellPlacement != nil {
+			continue
+		}
+		for _, podPlacements := range g.physicalLeafCellPlacement {
+			for _, podPlacement := range podPlacements {
+				for _, leafCell := range podPlacement {
+					// the cell may have been reserved by a preempting group
+					if leafCell == nil || leafCell.GetPriority() != opportunisticPriority {
+						continue
+					}
+					s := getSummary(g.vc, leafCell.GetChain())
+					s.Opportunistic++
+					s.UsedByPriority[int32(opportunisticPriority)]++
+					if g.lazyPreemptionStatus != nil {
+						s.LazyPreempted++
+					}
+				}
+			}
+		}
+	}
+
+	for vcn := range summary.VirtualClusters {
+		for leafCellType, s := range summary.VirtualClusters[vcn] {
+			s.Free = s.Quota - s.Used - s.DoomedBad
+			pcs := summary.PhysicalCluster[leafCellType]
+			pcs.Quota += s.Quota
+			pcs.LazyPreempted += s.LazyPreempted
+		}
+	}
+	for _, s := range summary.PhysicalCluster {
+		s.Free = s.Total
+		for _, num := range s.UsedByPriority {
+			s.Free -= num
+		}
+	}
+	return summary
+}
+
+func (h *HivedAlgorithm) ExplainAffinityGroup(name string) api.AffinityGroupExplanation {
+	h.algorithmLock.RLock()
+	defer h.algorithmLock.RUnlock()
+
+	if attempts, ok := h.schedulingAttempts[name]; ok {
+		return api.AffinityGroupExplanation{
+			ObjectMeta: api.ObjectMeta{Name: name},
+			Attempts:   append([]api.SchedulingAttempt{}, attempts...),
+		}
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"Affinity group %v has no recent scheduling attempt",
+		name)))
 }
 
 // initCellNums initiates the data structures for tracking cell usages and healthiness,
@@ -414,6 +981,20 @@ func (h *HivedAlgorithm) initCellNums() {
 	}
 }
 
+// initVCCellNum records the initial h.vcFreeCellNum as h.vcCellNum, before any
+// preassigned cell is allocated.
+func (h *HivedAlgorithm) initVCCellNum() {
+	for vc, vcFreeCellNum := range h.vcFreeCellNum {
+		h.vcCellNum[vc] = map[CellChain]map[CellLevel]int32{}
+		for chain, chainFreeCellNum := range vcFreeCellNum {
+			h.vcCellNum[vc][chain] = map[CellLevel]int32{}
+			for level, levelFreeCellNum := range chainFreeCellNum {
+				h.vcCellNum[vc][chain][level] = levelFreeCellNum
+			}
+		}
+	}
+}
+
 // initAPIClusterStatus initiates the status of the physical cluster and the VCs that will be exposed to users.
 func (h *HivedAlgorithm) initAPIClusterStatus() {
 	for _, ccl := range h.fullCellList {
@@ -471,38 +1052,191 @@ func (h *HivedAlgorithm) initBadNodes() {
 
 // setBadNode marks a node and the cells in it as bad.
 func (h *HivedAlgorithm) setBadNode(nodeName string) {
+	h.stopHealthyDamping(nodeName)
 	if h.badNodes.Contains(nodeName) {
 		return
 	}
 	h.badNodes.Add(nodeName)
-	for _, ccl := range h.fullCellList {
-		for _, leafCell := range ccl[1] {
-			pLeafCell := leafCell.(*PhysicalCell)
-			nodes, _ := pLeafCell.GetPhysicalPlacement()
-			if nodes[0] == nodeName {
-				h.setBadCell(pLeafCell)
-			}
-		}
-	}
+	h.updateLeafCellsInNode(nodeName)
 }
 
-// setBadNode marks a node and the cells in it as healthy.
+// setHealthyNode marks a node and the cells in it as healthy, except the bad leaf
+// cells in it.
 func (h *HivedAlgorithm) setHealthyNode(nodeName string) {
+	h.stopHealthyDamping(nodeName)
 	if !h.badNodes.Contains(nodeName) {
 		return
 	}
 	h.badNodes.Delete(nodeName)
+	h.updateLeafCellsInNode(nodeName)
+}
+
+// setHealthyNodeWithDamping marks a bad node as healthy only if it keeps healthy
+// (i.e. setBadNode is not called for it) during the healthy damping period.
+func (h *HivedAlgorithm) setHealthyNodeWithDamping(nodeName string) {
+	damping := h.nodeHealthPolicy.HealthyDamping()
+	if damping <= 0 || !h.badNodes.Contains(nodeName) {
+		h.setHealthyNode(nodeName)
+		return
+	}
+	h.dampHealthyNode(nodeName, damping)
+}
+
+// dampHealthyNode marks a bad node as healthy once the damping elapses, unless the
+// damping is stopped before.
+func (h *HivedAlgorithm) dampHealthyNode(nodeName string, damping time.Duration) {
+	h.stopHealthyDamping(nodeName)
+	klog.Infof("Node %v turned healthy, it will be marked as healthy if it keeps healthy for %v",
+		nodeName, damping)
+	var t timer
+	t = h.clock.AfterFunc(damping, func() {
+		h.algorithmLock.Lock()
+		defer h.algorithmLock.Unlock()
+		defer h.generateWatchEvents()
+
+		// The timer may have been stopped or replaced after it fired.
+		if d, ok := h.dampingHealthyNodes[nodeName]; ok && d.timer == t {
+			h.setHealthyNode(nodeName)
+		}
+	})
+	h.dampingHealthyNodes[nodeName] = healthyDamping{timer: t, deadline: h.clock.Now().Add(damping)}
+}
+
+// stopHealthyDamping stops the healthy damping of a node if it is in the damping period.
+func (h *HivedAlgorithm) stopHealthyDamping(nodeName string) {
+	if d, ok := h.dampingHealthyNodes[nodeName]; ok {
+		d.timer.Stop()
+		delete(h.dampingHealthyNodes, nodeName)
+	}
+}
+
+// setBadLeafCells records the bad leaf cells in a node. If the node itself is healthy,
+// it marks these leaf cells as bad and the other leaf cells in the node as healthy.
+// The leaf cells are not updated if the bad leaf cells are not changed, since the node
+// annotation is replayed on every node update.
+func (h *HivedAlgorithm) setBadLeafCells(nodeName string, badLeafCellIndices []int32) {
+	badIndices := common.NewSet()
+	for _, i := range badLeafCellIndices {
+		badIndices.Add(i)
+	}
+	if reflect.DeepEqual(h.badLeafCellIndices[nodeName], badIndices) {
+		return
+	}
+	h.badLeafCellIndices[nodeName] = badIndices
+	h.updateLeafCellsInNode(nodeName)
+}
+
+// isBadLeafCell checks if a leaf cell is reported as bad, regardless of its node healthiness.
+func (h *HivedAlgorithm) isBadLeafCell(nodeName string, leafCellIndex int32) bool {
+	badIndices, ok := h.badLeafCellIndices[nodeName]
+	return ok && badIndices.Contains(leafCellIndex)
+}
+
+// setNodeDraining drains or undrains a node and the cells in it.
+func (h *HivedAlgorithm) setNodeDraining(nodeName string, draining bool) {
+	if h.drainingNodes.Contains(nodeName) == draining {
+		return
+	}
+	if draining {
+		h.drainingNodes.Add(nodeName)
+	} else {
+		h.drainingNodes.Delete(nodeName)
+	}
+	h.updateLeafCellsInNode(nodeName)
+}
+
+// setNodeDrainingCells records the draining cells persisted in the node annotation,
+// and drains or undrains the part of these cells in the node.
+func (h *HivedAlgorithm) setNodeDrainingCells(nodeName string, addresses []api.CellAddress) {
+	drainingCells := common.NewSet()
+	for _, address := range addresses {
+		drainingCells.Add(address)
+	}
+	if reflect.DeepEqual(h.nodeDrainingCells[nodeName], drainingCells) {
+		return
+	}
+	h.nodeDrainingCells[nodeName] = drainingCells
+	h.updateLeafCellsInNode(nodeName)
+}
+
+// setNodeBadCells records the bad cells persisted in the node annotation,
+// and marks or unmarks the part of these cells in the node as bad.
+func (h *HivedAlgorithm) setNodeBadCells(nodeName string, addresses []api.CellAddress) {
+	badCells := common.NewSet()
+	for _, address := range addresses {
+		badCells.Add(address)
+	}
+	if reflect.DeepEqual(h.nodeBadCells[nodeName], badCells) {
+		return
+	}
+	h.nodeBadCells[nodeName] = badCells
+	h.updateLeafCellsInNode(nodeName)
+}
+
+// updateLeafCellsInNode updates the draining status and healthiness of all the leaf cells in a node.
+func (h *HivedAlgorithm) updateLeafCellsInNode(nodeName string) {
 	for _, ccl := range h.fullCellList {
 		for _, leafCell := range ccl[1] {
 			pLeafCell := leafCell.(*PhysicalCell)
 			nodes, _ := pLeafCell.GetPhysicalPlacement()
 			if nodes[0] == nodeName {
-				h.setHealthyCell(pLeafCell)
+				h.updateLeafCell(pLeafCell)
 			}
 		}
 	}
 }
 
+// updateLeafCellsInCell updates the draining status and healthiness of all the leaf cells in a cell.
+func (h *HivedAlgorithm) updateLeafCellsInCell(c *PhysicalCell) {
+	if c.GetLevel() == lowestLevel {
+		h.updateLeafCell(c)
+		return
+	}
+	for _, child := range c.GetChildren() {
+		h.updateLeafCellsInCell(child.(*PhysicalCell))
+	}
+}
+
+// updateLeafCell updates the draining status and healthiness of a leaf cell:
+// 1. A cell is draining if its node, or itself or any of its ancestors is draining
+//    (the draining status of the ancestors of the leaf cell is updated together).
+// 2. A leaf cell is bad if its node is bad, or it is reported as bad, or itself or any
+//    of its ancestors is marked as bad.
+// A draining cell is not considered as bad, so that it does not impact the VC safety:
+// it keeps its current groups, and is only skipped when placing new groups.
+func (h *HivedAlgorithm) updateLeafCell(c *PhysicalCell) {
+	nodes, leafCellIndices := c.GetPhysicalPlacement()
+	marked := false
+	for ac := Cell(c); ac != nil; ac = ac.GetParent() {
+		pac := ac.(*PhysicalCell)
+		if draining := h.isCellDraining(pac, nodes[0]); pac.IsDraining() != draining {
+			pac.SetDraining(draining)
+		}
+		marked = marked || h.badCells.Contains(ac.GetAddress()) ||
+			h.nodeBadCells[nodes[0]].Contains(ac.GetAddress())
+	}
+	if h.badNodes.Contains(nodes[0]) || h.isBadLeafCell(nodes[0], leafCellIndices[0]) || marked {
+		h.setBadCell(c)
+	} else {
+		h.setHealthyCell(c)
+	}
+}
+
+// isCellDraining checks if a cell (which is in the given node) is draining, i.e., the cell is
+// in a draining node, or itself or any of its ancestors is draining. A cell is not draining
+// if only some of its children are draining.
+func (h *HivedAlgorithm) isCellDraining(c *PhysicalCell, nodeName string) bool {
+	if nodes, _ := c.GetPhysicalPlacement(); len(nodes) == 1 && h.drainingNodes.Contains(nodeName) {
+		return true
+	}
+	for ac := Cell(c); ac != nil; ac = ac.GetParent() {
+		if h.drainingCells.Contains(ac.GetAddress()) || h.nodeDrainingCells[nodeName].Contains(ac.GetAddress()) {
+			return true
+		}
+	}
+	return false
+}
+
 // setBadCell marks a physical cell (and also the virtual cell it is bound to) as bad,
 // and recursively for its parent, guaranteeing that a cell is bad if any of its children is bad.
 // setBadCell always starts from the lowest level, i.e., leaf-level cells.
@@ -687,7 +1421,7 @@ func (h *HivedAlgorithm) schedulePodFromExistingGroup(
 				"healthy and within K8s suggested nodes: %v", internal.Key(pod), g.name, badOrNonSuggestedNodes)
 		}
 		if podIndex = getNewPodIndex(g.allocatedPods[s.LeafCellNumber]); podIndex == -1 {
-			panic(internal.NewBadRequestError(fmt.Sprintf(
+			panic(internal.NewInvalidSpecError(fmt.Sprintf(
 				"Requesting more pods than the configured number for %v leaf cells (%v pods) in affinity group %v",
 				s.LeafCellNumber, g.totalPodNums[s.LeafCellNumber], s.AffinityGroup.Name)))
 		}
@@ -712,6 +1446,7 @@ func (h *HivedAlgorithm) schedulePodFromExistingGroup(
 					"Preemption victims have been cleaned up for the preemptor affinity group %v", g.name)
 			}
 			g.preemptingPods[pod.UID] = pod
+			h.recordGroupChange(g.name)
 		}
 	}
 	return groupPhysicalPlacement, groupVirtualPlacement, preemptionVictims, podIndex
@@ -791,7 +1526,7 @@ func (h *HivedAlgorithm) scheduleNewAffinityGroup(
 		physicalPlacement, virtualPlacement, failedReason = h.handleSchedulingRequest(sr)
 	} else if s.LeafCellType != "" {
 		if _, ok := h.cellChains[s.LeafCellType]; !ok {
-			panic(internal.NewBadRequestError(fmt.Sprintf(
+			panic(internal.NewInvalidSpecError(fmt.Sprintf(
 				"[%v]: Pod requesting leaf cell type %v which the whole cluster does not have",
 				internal.Key(pod), s.LeafCellType)))
 		}
@@ -833,7 +1568,7 @@ func (h *HivedAlgorithm) scheduleAffinityGroupForLeafCellType(
 		}
 	}
 	if typeSpecified && sr.priority >= minGuaranteedPriority && !vcHasType {
-		panic(internal.NewBadRequestError(fmt.Sprintf(
+		panic(internal.NewInvalidSpecError(fmt.Sprintf(
 			"[%v]: Pod requesting leaf cell type %v which VC %v does not have",
 			internal.Key(pod), leafCellType, sr.vc)))
 	}
@@ -866,19 +1601,18 @@ func (h *HivedAlgorithm) scheduleAffinityGroupForAnyLeafCellType(
 
 // validateSchedulingRequest checks the existence of VC and pinned cell, and the legality of priority.
 func (h *HivedAlgorithm) validateSchedulingRequest(sr schedulingRequest, pod *core.Pod) {
-	var message string
 	if h.vcSchedulers[sr.vc] == nil {
-		message = fmt.Sprintf("VC %v does not exists!", sr.vc)
+		panic(internal.NewUnknownVirtualClusterError(fmt.Sprintf(
+			"[%v]: VC %v does not exists!", internal.Key(pod), sr.vc)))
 	} else if sr.pinnedCellId != "" {
 		if h.vcSchedulers[sr.vc].getPinnedCells()[sr.pinnedCellId] == nil {
-			message = fmt.Sprintf("VC %v does not have pinned cell %v", sr.vc, sr.pinnedCellId)
+			panic(internal.NewUnknownPinnedCellError(fmt.Sprintf(
+				"[%v]: VC %v does not have pinned cell %v", internal.Key(pod), sr.vc, sr.pinnedCellId)))
 		} else if sr.priority == opportunisticPriority {
-			message = fmt.Sprintf("opportunistic pod not supported to use pinned cell %v", sr.pinnedCellId)
+			panic(internal.NewInvalidSpecError(fmt.Sprintf(
+				"[%v]: opportunistic pod not supported to use pinned cell %v", internal.Key(pod), sr.pinnedCellId)))
 		}
 	}
-	if message != "" {
-		panic(internal.NewBadRequestError(fmt.Sprintf("[%v]: %v", internal.Key(pod), message)))
-	}
 }
 
 // handleSchedulingRequest feeds a request to a VC scheduler or the opportunistic scheduler depending on its priority.
@@ -894,11 +1628,14 @@ func (h *HivedAlgorithm) handleSchedulingRequest(
 	}
 	klog.Infof("Processing scheduling request: %v, leaf cell numbers %v, priority %v",
 		str, common.ToJson(sr.affinityGroupPodNums), sr.priority)
+	step := h.addSchedulingAttemptStep(sr)
 	if sr.priority >= minGuaranteedPriority {
-		physicalPlacement, virtualPlacement, failedReason = h.scheduleGuaranteedAffinityGroup(sr)
+		physicalPlacement, virtualPlacement, failedReason = h.scheduleGuaranteedAffinityGroup(sr, step)
 	} else {
 		physicalPlacement, failedReason = h.scheduleOpportunisticAffinityGroup(sr)
+		step.PhysicalClusterFailedReason = failedReason
 	}
+	step.Found = physicalPlacement != nil
 	if physicalPlacement == nil {
 		klog.Infof("Cannot find placement in %v: %v", str, failedReason)
 		return nil, nil, failedReason
@@ -910,7 +1647,8 @@ func (h *HivedAlgorithm) handleSchedulingRequest(
 // scheduleGuaranteedAffinityGroup schedules an affinity group in its VC,
 // and then maps the placement in VC to the physical cluster.
 func (h *HivedAlgorithm) scheduleGuaranteedAffinityGroup(
-	sr schedulingRequest) (
+	sr schedulingRequest,
+	step *api.SchedulingAttemptStep) (
 	physicalPlacement groupPhysicalPlacement,
 	virtualPlacement groupVirtualPlacement,
 	failedReason string) {
@@ -918,6 +1656,7 @@ func (h *HivedAlgorithm) scheduleGuaranteedAffinityGroup(
 	// schedule in VC
 	virtualPlacement, failedReason = h.vcSchedulers[sr.vc].schedule(sr)
 	if virtualPlacement == nil {
+		step.VirtualClusterFailedReason = failedReason
 		return nil, nil, failedReason
 	}
 	// map the vc placement to the physical cluster
@@ -925,6 +1664,10 @@ func (h *HivedAlgorithm) scheduleGuaranteedAffinityGroup(
 	leafCellNums := common.Int32MapKeys(sr.affinityGroupPodNums)
 	common.SortInt32(leafCellNums)
 	lazyPreemptedGroups := h.tryLazyPreempt(virtualPlacement, leafCellNums, sr.affinityGroupName)
+	for groupName := range lazyPreemptedGroups {
+		step.LazyPreemptedGroups = append(step.LazyPreemptedGroups, groupName)
+	}
+	sort.Strings(step.LazyPreemptedGroups)
 	preassignedCells, nonPreassignedCells := virtualPlacement.toBindingPaths(leafCellNums, bindings)
 	// make a copy of freeCellNum, may change its values during allocation
 	freeCellNumCopy := map[CellLevel]int32{}
@@ -939,6 +1682,10 @@ func (h *HivedAlgorithm) scheduleGuaranteedAffinityGroup(
 		sr.suggestedNodes,
 		sr.ignoreSuggestedNodes,
 		bindings); ok {
+		for groupName := range lazyPreemptedGroups {
+			h.lazyPreemptedGroups = append(h.lazyPreemptedGroups, groupName)
+			h.lazyPreemptionCount++
+		}
 		return virtualPlacement.toPhysicalPlacement(bindings, leafCellNums), virtualPlacement, ""
 	}
 	for groupName, placement := range lazyPreemptedGroups {
@@ -948,9 +1695,55 @@ func (h *HivedAlgorithm) scheduleGuaranteedAffinityGroup(
 	if sr.ignoreSuggestedNodes {
 		failedNodeType = "bad"
 	}
-	return nil, nil, fmt.Sprintf(
+	failedReason = fmt.Sprintf(
 		"Mapping the virtual placement would need to use at least one %v node "+
 			"(virtual placement : %v)", failedNodeType, virtualPlacement)
+	step.PhysicalClusterFailedReason = failedReason
+	step.BadOrNonSuggestedNodes = h.getBadOrNonSuggestedNodes(sr)
+	return nil, nil, failedReason
+}
+
+// addSchedulingAttemptStep adds a step to the scheduling attempt of the pod being scheduled,
+// for a scheduling request in a chain or pinned cell.
+// A dummy step is returned if no scheduling attempt is in progress.
+func (h *HivedAlgorithm) addSchedulingAttemptStep(sr schedulingRequest) *api.SchedulingAttemptStep {
+	step := api.SchedulingAttemptStep{Chain: string(sr.chain), PinnedCellId: sr.pinnedCellId}
+	if sr.chain != "" {
+		step.LeafCellType = string(h.cellTypes[sr.chain][lowestLevel])
+	}
+	if h.schedulingAttempt == nil {
+		return &step
+	}
+	h.schedulingAttempt.Steps = append(h.schedulingAttempt.Steps, step)
+	return &h.schedulingAttempt.Steps[len(h.schedulingAttempt.Steps)-1]
+}
+
+// getBadOrNonSuggestedNodes returns the bad, draining, or non-suggested nodes in the chain
+// of a scheduling request, which may make mapping a virtual placement to the chain fail.
+func (h *HivedAlgorithm) getBadOrNonSuggestedNodes(sr schedulingRequest) []string {
+	nodes := []string{}
+	ccl := h.fullCellList[sr.chain]
+	for _, c := range ccl[CellLevel(len(ccl))] {
+		cellNodes, _ := c.(*PhysicalCell).GetPhysicalPlacement()
+		for _, n := range cellNodes {
+			if h.badNodes.Contains(n) || h.drainingNodes.Contains(n) || !h.badLeafCellIndices[n].IsEmpty() ||
+				(!sr.ignoreSuggestedNodes && !sr.suggestedNodes.Contains(n)) {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// recordSchedulingAttempt keeps a scheduling attempt among the most recent ones of the affinity group.
+func (h *HivedAlgorithm) recordSchedulingAttempt(groupName string, attempt *api.SchedulingAttempt) {
+	attempts := append(h.schedulingAttempts[groupName], *attempt)
+	if len(attempts) > maxSchedulingAttemptsPerGroup {
+		attempts = append([]api.SchedulingAttempt{}, attempts[len(attempts)-maxSchedulingAttemptsPerGroup:]...)
+	}
+	h.schedulingAttempts[groupName] = attempts
+	h.schedulingAttempt = nil
 }
 
 // tryLazyPreempt tries to lazy preempt the affinity groups found on a placement.
@@ -1045,10 +1838,13 @@ func (h *HivedAlgorithm) createAllocatedAffinityGroup(s *api.PodSchedulingSpec,
 			}
 		}
 	}
+	h.addUsageRecord(api.UsageRecordAllocated, newGroup, pod, "")
 	if shouldLazyPreempt {
 		h.lazyPreemptAffinityGroup(newGroup, newGroup.name)
+		h.lazyPreemptionCount++
 	}
 	h.affinityGroups[s.AffinityGroup.Name] = newGroup
+	h.recordGroupChange(newGroup.name)
 	klog.Infof("[%v]: New allocated affinity group created: %v", internal.Key(pod), s.AffinityGroup.Name)
 }
 
@@ -1077,7 +1873,9 @@ func (h *HivedAlgorithm) deleteAllocatedAffinityGroup(g *AlgoAffinityGroup, pod
 			}
 		}
 	}
+	h.addUsageRecord(api.UsageRecordReleased, g, pod, "")
 	delete(h.affinityGroups, g.name)
+	h.recordGroupChange(g.name)
 	klog.Infof("[%v]: Allocated affinity group deleted: %v", internal.Key(pod), g.name)
 }
 
@@ -1096,6 +1894,7 @@ func (h *HivedAlgorithm) createPreemptingAffinityGroup(
 		s.AffinityGroup, s.VirtualCluster, s.LazyPreemptionEnable, s.Priority, groupPreempting)
 	newGroup.physicalLeafCellPlacement = physicalPlacement
 	newGroup.virtualLeafCellPlacement = virtualPlacement
+	victims := map[*AlgoAffinityGroup]bool{}
 	for leafCellNum := range physicalPlacement {
 		for podIndex := range physicalPlacement[leafCellNum] {
 			for leafCellIndex, leafCell := range physicalPlacement[leafCellNum][podIndex] {
@@ -1104,7 +1903,12 @@ func (h *HivedAlgorithm) createPreemptingAffinityGroup(
 				if pLeafCell.GetState() == cellUsed {
 					usingGroup := pLeafCell.GetUsingGroup()
 					h.releaseLeafCell(pLeafCell, usingGroup.vc)
+					if !victims[usingGroup] {
+						victims[usingGroup] = true
+						h.addUsageRecord(api.UsageRecordPreempted, usingGroup, nil, newGroup.name)
+					}
 					usingGroup.state = groupBeingPreempted
+					h.recordGroupChange(usingGroup.name)
 				}
 				h.allocateLeafCell(pLeafCell, vLeafCell, CellPriority(s.Priority), newGroup.vc)
 				pLeafCell.AddReservingOrReservedGroup(newGroup)
@@ -1120,6 +1924,8 @@ func (h *HivedAlgorithm) createPreemptingAffinityGroup(
 	}
 	newGroup.preemptingPods[pod.UID] = pod
 	h.affinityGroups[s.AffinityGroup.Name] = newGroup
+	h.recordGroupChange(newGroup.name)
+	h.preemptionCount++
 	klog.Infof("[%v]: New preempting affinity group created: %v", internal.Key(pod), newGroup.name)
 }
 
@@ -1152,6 +1958,7 @@ func (h *HivedAlgorithm) deletePreemptingAffinityGroup(g *AlgoAffinityGroup, pod
 		}
 	}
 	delete(h.affinityGroups, g.name)
+	h.recordGroupChange(g.name)
 	klog.Infof("[%v]: Preempting affinity group %v deleted", internal.Key(pod), g.name)
 }
 
@@ -1170,32 +1977,27 @@ func (h *HivedAlgorithm) allocatePreemptingAffinityGroup(g *AlgoAffinityGroup, p
 	}
 	g.state = groupAllocated
 	g.preemptingPods = nil
+	h.recordGroupChange(g.name)
+	h.addUsageRecord(api.UsageRecordAllocated, g, pod, "")
 	klog.Infof("[%v]: Preempting affinity group %v transitioned to allocated", internal.Key(pod), g.name)
 }
 
 // lazyPreemptAffinityGroup removes an affinity group from its VC, clears it virtual placement,
 // and exposes this decision.
+// The caller counts the lazy preemption once it is committed, because it may be reverted
+// (see tryLazyPreempt), while the lazy preemption count should never decrease.
 func (h *HivedAlgorithm) lazyPreemptAffinityGroup(
 	victim *AlgoAffinityGroup,
 	preemptor string) (originalVirtualPlacement groupVirtualPlacement) {
-	for _, podVirtualPlacements := range victim.virtualLeafCellPlacement {
-		for _, podVirtualPlacement := range podVirtualPlacements {
-			for _, leafCell := range podVirtualPlacement {
-				if leafCell != nil {
-					vLeafCell := leafCell.(*VirtualCell)
-					pLeafCell := vLeafCell.GetPhysicalCell()
-					h.releaseLeafCell(pLeafCell, victim.vc)
-					h.allocateLeafCell(pLeafCell, nil, opportunisticPriority, victim.vc)
-				}
-			}
-		}
-	}
+	h.releaseVirtualPlacement(victim.virtualLeafCellPlacement, victim.vc)
 	originalVirtualPlacement = victim.virtualLeafCellPlacement
 	victim.virtualLeafCellPlacement = nil
 	victim.lazyPreemptionStatus = &api.LazyPreemptionStatus{
 		Preemptor:      preemptor,
 		PreemptionTime: meta.Now(),
 	}
+	h.recordGroupChange(victim.name)
+	h.addUsageRecord(api.UsageRecordLazyPreempted, victim, nil, preemptor)
 	klog.Infof("Affinity group %v is lazy preempted from VC by %v", victim.name, preemptor)
 	return originalVirtualPlacement
 }
@@ -1204,12 +2006,30 @@ func (h *HivedAlgorithm) lazyPreemptAffinityGroup(
 func (h *HivedAlgorithm) lazyPreemptCell(c *VirtualCell, preemptor string) {
 	if c.GetLevel() == lowestLevel && c.GetState() == cellUsed {
 		h.lazyPreemptAffinityGroup(c.GetPhysicalCell().GetUsingGroup(), preemptor)
+		h.lazyPreemptionCount++
 	}
 	for _, child := range c.GetChildren() {
 		h.lazyPreemptCell(child.(*VirtualCell), preemptor)
 	}
 }
 
+// getLazyPreemptedPods returns the allocated pods of the affinity groups lazy preempted
+// by the pod being scheduled.
+func (h *HivedAlgorithm) getLazyPreemptedPods() (pods []*core.Pod) {
+	for _, groupName := range h.lazyPreemptedGroups {
+		if g := h.affinityGroups[groupName]; g != nil {
+			for _, podList := range g.allocatedPods {
+				for _, p := range podList {
+					if p != nil {
+						pods = append(pods, p)
+					}
+				}
+			}
+		}
+	}
+	return pods
+}
+
 // revertLazyPreempt reverts the lazy preemption of an affinity group.
 func (h *HivedAlgorithm) revertLazyPreempt(g *AlgoAffinityGroup, virtualPlacement groupVirtualPlacement) {
 	for leafCellNum := range g.physicalLeafCellPlacement {
@@ -1227,9 +2047,165 @@ func (h *HivedAlgorithm) revertLazyPreempt(g *AlgoAffinityGroup, virtualPlacemen
 	}
 	g.virtualLeafCellPlacement = virtualPlacement
 	g.lazyPreemptionStatus = nil
+	h.recordGroupChange(g.name)
+	h.dropUsageRecord(api.UsageRecordLazyPreempted, g.name)
 	klog.Infof("Lazy preemption of affinity group %v is reverted", g.name)
 }
 
+// releaseVirtualPlacement releases the virtual leaf cells in a virtual placement,
+// and lets their physical leaf cells be used opportunistically by the VC.
+func (h *HivedAlgorithm) releaseVirtualPlacement(virtualPlacement groupVirtualPlacement, vcn api.VirtualClusterName) {
+	for _, podVirtualPlacements := range virtualPlacement {
+		for _, podVirtualPlacement := range podVirtualPlacements {
+			for _, leafCell := range podVirtualPlacement {
+				if leafCell != nil {
+					vLeafCell := leafCell.(*VirtualCell)
+					pLeafCell := vLeafCell.GetPhysicalCell()
+					h.releaseLeafCell(pLeafCell, vcn)
+					h.allocateLeafCell(pLeafCell, nil, opportunisticPriority, vcn)
+				}
+			}
+		}
+	}
+}
+
+// unlazyPreemptAffinityGroup maps the physical placement of a lazy preempted affinity group back
+// to its VC, in the same way as an allocated group is recovered, and reverts its lazy preemption.
+// Unlike the recovery, it never preempts other groups or breaks the safety: if any leaf cell
+// cannot be mapped, the cells already mapped are released (i.e. the group keeps lazy preempted),
+// and the reason is returned.
+func (h *HivedAlgorithm) unlazyPreemptAffinityGroup(g *AlgoAffinityGroup) (message string) {
+	var pod *core.Pod
+	for _, podList := range g.allocatedPods {
+		for _, p := range podList {
+			if p != nil {
+				pod = p
+			}
+		}
+	}
+	if pod == nil {
+		return "no allocated pod in the group"
+	}
+	s := internal.ExtractPodSchedulingSpec(pod)
+	info := internal.ExtractPodBindInfo(pod)
+	preassignedCellTypes := map[int32][][]api.CellType{}
+	for _, gms := range info.AffinityGroupBindInfo {
+		leafCellNum := int32(len(gms.PodPlacements[0].PhysicalLeafCellIndices))
+		for _, placement := range gms.PodPlacements {
+			preassignedCellTypes[leafCellNum] = append(preassignedCellTypes[leafCellNum], placement.PreassignedCellTypes)
+		}
+	}
+
+	virtualPlacement := groupVirtualPlacement{}
+	for leafCellNum, podPlacements := range g.physicalLeafCellPlacement {
+		virtualPlacement[leafCellNum] = make([]CellList, len(podPlacements))
+		for podIndex := range podPlacements {
+			virtualPlacement[leafCellNum][podIndex] = make(CellList, len(podPlacements[podIndex]))
+		}
+	}
+	// the leaf cells are mapped and allocated one by one, so that the leaf cells in the same
+	// preassigned cell are mapped to the virtual cells in the same preassigned cell
+	for leafCellNum, podPlacements := range g.physicalLeafCellPlacement {
+		for podIndex, podPlacement := range podPlacements {
+			for leafCellIndex, leafCell := range podPlacement {
+				if leafCell == nil {
+					continue
+				}
+				pLeafCell := leafCell.(*PhysicalCell)
+				var preassignedType api.CellType
+				if types := preassignedCellTypes[leafCellNum]; podIndex < len(types) && leafCellIndex < len(types[podIndex]) {
+					preassignedType = types[podIndex][leafCellIndex]
+				}
+				vLeafCell, message := h.mapLazyPreemptedLeafCell(pLeafCell, preassignedType, s, g)
+				if vLeafCell != nil {
+					h.releaseLeafCell(pLeafCell, g.vc)
+					virtualPlacement[leafCellNum][podIndex][leafCellIndex] = vLeafCell
+					if safetyOk, reason := h.allocateLeafCell(
+						pLeafCell, vLeafCell, CellPriority(g.priority), g.vc); !safetyOk {
+						message = reason
+					}
+				}
+				if message != "" {
+					h.releaseVirtualPlacement(virtualPlacement, g.vc)
+					return message
+				}
+			}
+		}
+	}
+	g.virtualLeafCellPlacement = virtualPlacement
+	g.lazyPreemptionStatus = nil
+	h.recordGroupChange(g.name)
+	h.addUsageRecord(api.UsageRecordLazyPreemptionReverted, g, nil, "")
+	klog.Infof("Lazy preemption of affinity group %v is reverted by admin", g.name)
+	return ""
+}
+
+// mapLazyPreemptedLeafCell finds the virtual leaf cell in the VC for a leaf cell of a lazy preempted
+// affinity group. The virtual leaf cell must be free, and it and its ancestors up to the preassigned
+// cell must be either unbound or bound to the corresponding physical cells, so that mapping it does
+// not impact any other group.
+func (h *HivedAlgorithm) mapLazyPreemptedLeafCell(
+	pLeafCell *PhysicalCell,
+	preassignedType api.CellType,
+	s *api.PodSchedulingSpec,
+	g *AlgoAffinityGroup) (*VirtualCell, string) {
+
+	if preassignedType == "" {
+		return nil, fmt.Sprintf("preassigned cell of leaf cell %v not found in pod bind info", pLeafCell.GetAddress())
+	}
+	var preassignedLevel CellLevel
+	typeFound := false
+	for l, t := range h.cellTypes[pLeafCell.GetChain()] {
+		if t == preassignedType {
+			preassignedLevel = l
+			typeFound = true
+		}
+	}
+	if !typeFound {
+		return nil, fmt.Sprintf("preassigned cell type %v not found in chain %v", preassignedType, pLeafCell.GetChain())
+	}
+	vcs := h.vcSchedulers[g.vc]
+	if vcs == nil {
+		return nil, fmt.Sprintf("VC %v not found", g.vc)
+	}
+	vccl := vcs.getNonPinnedPreassignedCells()[pLeafCell.GetChain()]
+	str := string(pLeafCell.GetChain())
+	if s.PinnedCellId != "" {
+		vccl = vcs.getPinnedCells()[s.PinnedCellId]
+		str = string(s.PinnedCellId)
+	}
+	if vccl == nil {
+		return nil, fmt.Sprintf("VC %v has no cell for %v", g.vc, str)
+	}
+	vLeafCell, message := mapPhysicalCellToVirtual(pLeafCell, vccl, preassignedLevel, CellPriority(g.priority))
+	if vLeafCell == nil {
+		if message == "" {
+			message = fmt.Sprintf("no free virtual cell for leaf cell %v", pLeafCell.GetAddress())
+		}
+		return nil, message
+	}
+	if vLeafCell.GetVirtualCluster() != g.vc || vLeafCell.GetPriority() != freePriority {
+		return nil, fmt.Sprintf("virtual cell %v for leaf cell %v is not free in VC %v",
+			vLeafCell.GetAddress(), pLeafCell.GetAddress(), g.vc)
+	}
+	var pc, vc Cell = pLeafCell, vLeafCell
+	for {
+		if bound := vc.(*VirtualCell).GetPhysicalCell(); bound != nil && bound != pc {
+			return nil, fmt.Sprintf("virtual cell %v is bound to another physical cell %v",
+				vc.GetAddress(), bound.GetAddress())
+		}
+		if vc.GetLevel() == preassignedLevel {
+			break
+		}
+		pc, vc = pc.GetParent(), vc.GetParent()
+	}
+	if vc.(*VirtualCell).GetPhysicalCell() == nil && !inFreeCellList(pc.(*PhysicalCell)) {
+		return nil, fmt.Sprintf("physical cell %v is not free for preassigned cell %v",
+			pc.GetAddress(), vc.GetAddress())
+	}
+	return vLeafCell, ""
+}
+
 // findAllocatedLeafCell finds the physical and virtual leaf cells in the full cell lists for an allocate pod.
 // The boolean return value indicates whether the affinity group should be lazy-preempted.
 // The bool being nil means the group is OT and has no virtual placement.
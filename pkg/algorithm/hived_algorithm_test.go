@@ -25,8 +25,11 @@ package algorithm
 import (
 	"fmt"
 	"net/http"
+	"reflect"
 	"sort"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/microsoft/hivedscheduler/pkg/api"
 	"github.com/microsoft/hivedscheduler/pkg/common"
@@ -602,6 +605,11 @@ var expectedPreemptInfos = map[string]common.Set{
 	"pod35": common.NewSet("pod34"),
 }
 
+var expectedLazyPreemptedPods = map[string]common.Set{
+	"pod16": common.NewSet("pod5", "pod6"),
+	"pod25": common.NewSet("pod24"),
+}
+
 var deletedPreemptorGroups = map[string][]string{
 	"pod33": {"group20", "group22"},
 	"pod34": {"group24"},
@@ -628,6 +636,16 @@ func TestHivedAlgorithm(t *testing.T) {
 	testSuggestedNodes(t, configFilePath)
 	testStatefulPreemption(t, configFilePath)
 	testBadNodes(t, configFilePath)
+	testBadLeafCells(t, configFilePath)
+	testDrainingCells(t, configFilePath)
+	testNodeHealthPolicy(t, configFilePath)
+	testTypedErrors(t, configFilePath)
+	testValidatePod(t, configFilePath)
+	testExplainAffinityGroup(t, configFilePath)
+	testGetClusterSummary(t, configFilePath)
+	testWatch(t, configFilePath)
+	testAdministrating(t, configFilePath)
+	testUsageRecords(t, configFilePath)
 	testSafeRelaxedBuddyAlloc(t, configFilePath)
 	testReconfiguration(t, configFilePath)
 	testInvalidInitialAssignment(t, sConfig)
@@ -705,6 +723,11 @@ func testCasesThatShouldSucceed(t *testing.T, h *HivedAlgorithm) {
 		pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
 		psr = h.Schedule(pod, allNodes, internal.PreemptingPhase)
 		compareSchedulingResult(t, pod, psr)
+		if expected := expectedLazyPreemptedPods[podName]; !expected.IsEmpty() &&
+			(len(psr.LazyPreemptedPods) == 0 || !containsPods(psr.LazyPreemptedPods, expected)) {
+			t.Errorf("[%v]: wrong lazy preempted pods: expected %v, but got %v",
+				internal.Key(pod), expected, psr.LazyPreemptedPods)
+		}
 		if psr.PodBindInfo != nil {
 			allocatedPod := internal.NewBindingPod(pod, psr.PodBindInfo)
 			h.AddAllocatedPod(allocatedPod)
@@ -853,8 +876,13 @@ func testSuggestedNodes(t *testing.T, configFilePath string) {
 	h.AddAllocatedPod(internal.NewBindingPod(pod, psr.PodBindInfo))
 	pod = allPods["pod41"]
 	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	lazyPreemptionCount := h.GetMetrics().LazyPreemptionCount
 	psr = h.Schedule(pod, []string{"0.0.3.2", "0.0.3.3", "0.0.4.3"}, internal.PreemptingPhase)
 	// the pod tries to lazy preempt group27 and group28, but is reverted
+	if count := h.GetMetrics().LazyPreemptionCount; count != lazyPreemptionCount {
+		t.Errorf("Expected the reverted lazy preemptions not to be counted, but the count is changed from %v to %v",
+			lazyPreemptionCount, count)
+	}
 	if g := h.affinityGroups["group27"]; g == nil {
 		t.Errorf("Group %v should be allocated but does not exist",
 			pss[pod.UID].AffinityGroup.Name)
@@ -1015,6 +1043,795 @@ func testBadNodes(t *testing.T, configFilePath string) {
 	}
 }
 
+func testBadLeafCells(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{
+			Name:        "0.0.2.1",
+			Annotations: map[string]string{api.AnnotationKeyNodeBadLeafCellIndices: "3"},
+		},
+		Status: core.NodeStatus{
+			Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+		},
+	}
+	h.AddNode(node)
+	checkLeafCellHealthiness(t, h, "0.0.2.1", map[int32]api.CellHealthiness{
+		3: api.CellBad, 2: api.CellHealthy})
+	if !h.badNodes.IsEmpty() {
+		t.Errorf("Node 0.0.2.1 should not be bad, but bad nodes are %v", h.badNodes)
+	}
+
+	newNode := node.DeepCopy()
+	newNode.Annotations[api.AnnotationKeyNodeBadLeafCellIndices] = "2"
+	h.UpdateNode(node, newNode)
+	checkLeafCellHealthiness(t, h, "0.0.2.1", map[int32]api.CellHealthiness{
+		3: api.CellHealthy, 2: api.CellBad})
+
+	// the bad leaf cells should be kept after the node recovers
+	h.setBadNode("0.0.2.1")
+	checkLeafCellHealthiness(t, h, "0.0.2.1", map[int32]api.CellHealthiness{
+		3: api.CellBad, 2: api.CellBad})
+	h.setHealthyNode("0.0.2.1")
+	checkLeafCellHealthiness(t, h, "0.0.2.1", map[int32]api.CellHealthiness{
+		3: api.CellHealthy, 2: api.CellBad})
+
+	node = newNode
+	newNode = node.DeepCopy()
+	delete(newNode.Annotations, api.AnnotationKeyNodeBadLeafCellIndices)
+	h.UpdateNode(node, newNode)
+	checkLeafCellHealthiness(t, h, "0.0.2.1", map[int32]api.CellHealthiness{
+		3: api.CellHealthy, 2: api.CellHealthy})
+}
+
+func testDrainingCells(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	pod := allPods["pod42"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	psr := h.Schedule(pod, []string{"0.0.2.0"}, internal.PreemptingPhase)
+	bindingPod := internal.NewBindingPod(pod, psr.PodBindInfo)
+	h.AddAllocatedPod(bindingPod)
+
+	// a used draining cell keeps its group and is not bad
+	h.setNodeDraining("0.0.2.0", true)
+	checkLeafCellDraining(t, h, "0.0.2.0", true, api.CellHealthy)
+
+	// a free draining cell takes no new allocations, but it is not exposed as bad
+	var nodeCell *PhysicalCell
+	for _, ccl := range h.fullCellList {
+		for _, c := range ccl[CellLevel(len(ccl))] {
+			for _, n := range c.(*PhysicalCell).GetChildren() {
+				if nodes, _ := n.(*PhysicalCell).GetPhysicalPlacement(); nodes[0] == "0.0.2.1" {
+					nodeCell = n.(*PhysicalCell)
+				}
+			}
+		}
+	}
+	h.SetCellDraining(nodeCell.GetAddress(), true)
+	checkLeafCellDraining(t, h, "0.0.2.1", true, api.CellHealthy)
+	if !nodeCell.IsHealthy() {
+		t.Errorf("Free draining cell %v should not be set as bad", nodeCell.GetAddress())
+	}
+	if parent := nodeCell.GetParent().(*PhysicalCell); parent.IsDraining() {
+		t.Errorf("Cell %v should not be draining when only some of its children are draining", parent.GetAddress())
+	}
+	if s := h.GetClusterSummary().PhysicalCluster["DGX2-V100"]; s.Bad != 0 || s.DoomedBad != 0 || s.Draining != 32 {
+		t.Errorf("Expected 0 bad, 0 doomed bad and 32 draining leaf cells, but got %v", common.ToJson(s))
+	}
+	pod = allPods["pod44"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	psr = h.Schedule(pod, []string{"0.0.2.1"}, internal.PreemptingPhase)
+	if psr.PodBindInfo != nil && psr.PodBindInfo.Node == "0.0.2.1" {
+		t.Errorf("Pod %v should not be scheduled to draining node 0.0.2.1", pod.Name)
+	}
+
+	// a used draining cell is still not bad once it is free
+	h.DeleteAllocatedPod(bindingPod)
+	checkLeafCellDraining(t, h, "0.0.2.0", true, api.CellHealthy)
+	if s := h.GetClusterSummary().PhysicalCluster["DGX2-V100"]; s.Bad != 0 || s.DoomedBad != 0 || s.Draining != 32 {
+		t.Errorf("Expected 0 bad, 0 doomed bad and 32 draining leaf cells, but got %v", common.ToJson(s))
+	}
+
+	h.setNodeDraining("0.0.2.0", false)
+	h.SetCellDraining(nodeCell.GetAddress(), false)
+	checkLeafCellDraining(t, h, "0.0.2.0", false, api.CellHealthy)
+	checkLeafCellDraining(t, h, "0.0.2.1", false, api.CellHealthy)
+	if !nodeCell.IsHealthy() {
+		t.Errorf("Undrained cell %v should be used for scheduling", nodeCell.GetAddress())
+	}
+
+	// the cells drained by the admin API are replayed from the node annotation
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{
+			Name: "0.0.2.1",
+			Annotations: map[string]string{
+				api.AnnotationKeyNodeDrainingCells: string(nodeCell.GetAddress()),
+			},
+		},
+		Status: core.NodeStatus{
+			Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+		},
+	}
+	h.AddNode(node)
+	checkLeafCellDraining(t, h, "0.0.2.1", true, api.CellHealthy)
+	undrainedNode := node.DeepCopy()
+	delete(undrainedNode.Annotations, api.AnnotationKeyNodeDrainingCells)
+	h.UpdateNode(node, undrainedNode)
+	checkLeafCellDraining(t, h, "0.0.2.1", false, api.CellHealthy)
+}
+
+func checkLeafCellDraining(
+	t *testing.T,
+	h *HivedAlgorithm,
+	nodeName string,
+	draining bool,
+	healthiness api.CellHealthiness) {
+
+	for _, ccl := range h.fullCellList {
+		for _, c := range ccl[1] {
+			if nodes, _ := c.(*PhysicalCell).GetPhysicalPlacement(); nodes[0] == nodeName {
+				pc := c.(*PhysicalCell)
+				if pc.GetAPIStatus().CellDraining != draining {
+					t.Errorf("Leaf cell %v draining should be %v, but it is %v",
+						c.GetAddress(), draining, pc.GetAPIStatus().CellDraining)
+				}
+				if pc.GetAPIStatus().CellHealthiness != healthiness {
+					t.Errorf("Leaf cell %v should be %v, but it is %v",
+						c.GetAddress(), healthiness, pc.GetAPIStatus().CellHealthiness)
+				}
+			}
+		}
+	}
+}
+
+func testNodeHealthPolicy(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	sConfig.NodeHealthPolicy.HealthyConditions = append(sConfig.NodeHealthPolicy.HealthyConditions,
+		api.NodeConditionSpec{Type: "GPUHealthy", Status: string(core.ConditionTrue)})
+	sConfig.NodeHealthPolicy.BadTaints = []api.NodeTaintSpec{{Key: "gpu-error"}}
+	sConfig.NodeHealthPolicy.BadLabelSelectors = []string{"gpu-health in (bad,unknown)"}
+	sConfig.NodeHealthPolicy.HealthyDampingSec = common.PtrInt64(1)
+	h := NewHivedAlgorithm(sConfig)
+	fc := newFakeClock()
+	h.clock = fc
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	healthyNode := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: "0.0.2.1", Labels: map[string]string{"gpu-health": "good"}},
+		Status: core.NodeStatus{
+			Conditions: []core.NodeCondition{
+				{Type: core.NodeReady, Status: core.ConditionTrue},
+				{Type: "GPUHealthy", Status: core.ConditionTrue},
+			},
+		},
+	}
+	noGPUHealthyNode := healthyNode.DeepCopy()
+	noGPUHealthyNode.Status.Conditions = noGPUHealthyNode.Status.Conditions[:1]
+	taintedNode := healthyNode.DeepCopy()
+	taintedNode.Spec.Taints = []core.Taint{{Key: "gpu-error", Effect: core.TaintEffectNoSchedule}}
+	badLabelNode := healthyNode.DeepCopy()
+	badLabelNode.Labels["gpu-health"] = "unknown"
+	for _, node := range []*core.Node{noGPUHealthyNode, taintedNode, badLabelNode} {
+		if h.nodeHealthPolicy.IsNodeHealthy(node) {
+			t.Errorf("Node %v should be bad", common.ToJson(node))
+		}
+	}
+	if !h.nodeHealthPolicy.IsNodeHealthy(healthyNode) {
+		t.Errorf("Node %v should be healthy", common.ToJson(healthyNode))
+	}
+
+	// a bad node is declared healthy only after it keeps healthy for the damping period
+	h.UpdateNode(healthyNode, taintedNode)
+	h.UpdateNode(taintedNode, healthyNode)
+	if !h.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should still be bad in the damping period")
+	}
+	h.UpdateNode(healthyNode, badLabelNode)
+	h.UpdateNode(badLabelNode, healthyNode)
+	fc.Step(600 * time.Millisecond)
+	h.UpdateNode(healthyNode, badLabelNode)
+	fc.Step(600 * time.Millisecond)
+	if !h.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should be bad since it does not keep healthy in the damping period")
+	}
+	h.UpdateNode(badLabelNode, healthyNode)
+	fc.Step(600 * time.Millisecond)
+	if !h.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should still be bad in the damping period")
+	}
+	fc.Step(600 * time.Millisecond)
+	if h.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should be healthy after the damping period")
+	}
+	checkLeafCellHealthiness(t, h, "0.0.2.1", map[int32]api.CellHealthiness{
+		0: api.CellHealthy, 15: api.CellHealthy})
+
+	// a healthy node added is declared healthy immediately
+	h.DeleteNode(healthyNode)
+	h.AddNode(healthyNode)
+	if h.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should be healthy once added")
+	}
+
+	// the healthy damping is stopped once the algorithm is stopped, and the rest of it
+	// is inherited by the replacing algorithm
+	h.UpdateNode(healthyNode, taintedNode)
+	h.UpdateNode(taintedNode, healthyNode)
+	fc.Step(600 * time.Millisecond)
+	newH := NewHivedAlgorithm(sConfig)
+	newH.clock = fc
+	setHealthyNodes(newH)
+	newH.AddNode(healthyNode)
+	newH.Inherit(h)
+	h.Stop()
+	if !newH.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should still be bad in the inherited damping period")
+	}
+	fc.Step(600 * time.Millisecond)
+	if !h.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should not be marked as healthy after the algorithm is stopped")
+	}
+	if newH.badNodes.Contains("0.0.2.1") {
+		t.Errorf("Node 0.0.2.1 should be healthy after the inherited damping period")
+	}
+}
+
+// fakeClock is a clock whose time only advances by Step, which fires the due
+// timers synchronously.
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	f        func()
+	stopped  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Now()}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) timer {
+	t := &fakeTimer{deadline: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Step(d time.Duration) {
+	c.now = c.now.Add(d)
+	timers := c.timers
+	c.timers = nil
+	for _, t := range timers {
+		if t.stopped {
+			continue
+		}
+		if t.deadline.After(c.now) {
+			c.timers = append(c.timers, t)
+		} else {
+			t.stopped = true
+			t.f()
+		}
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+func testTypedErrors(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	expectedReasons := map[string]api.ErrorReason{
+		"pod10": api.ErrorReasonInvalidSpec,
+		"pod13": api.ErrorReasonUnknownVirtualCluster,
+		"pod14": api.ErrorReasonUnknownPinnedCell,
+		"pod15": api.ErrorReasonInvalidSpec,
+	}
+	for podName, reason := range expectedReasons {
+		pod := allPods[podName]
+		pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+		_, err := h.TrySchedule(pod, allNodes, internal.PreemptingPhase)
+		if e, ok := err.(*api.WebServerError); !ok || e.Reason != reason {
+			t.Errorf("[%v]: Expected error with reason %v, but got %v", podName, reason, err)
+		}
+	}
+
+	pod := allPods["pod1"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	psr, err := h.TrySchedule(pod, allNodes, internal.PreemptingPhase)
+	if err != nil || psr.PodBindInfo == nil {
+		t.Errorf("[pod1]: Expected to be bound without error, but got %v, %v", common.ToJson(psr), err)
+	}
+
+	if e := internal.AsWebServerError("assert failure"); e.Reason != api.ErrorReasonInternalInvariant ||
+		e.Code != http.StatusInternalServerError {
+		t.Errorf("Expected internal invariant error, but got %v", e)
+	}
+}
+
+func testValidatePod(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	tryValidatePod := func(pod *core.Pod) (err error) {
+		defer internal.RecoverAsError(&err)
+		h.ValidatePod(pod)
+		return nil
+	}
+
+	expectedReasons := map[string]api.ErrorReason{
+		"pod10": api.ErrorReasonInvalidSpec,
+		"pod11": api.ErrorReasonInvalidSpec,
+		"pod13": api.ErrorReasonUnknownVirtualCluster,
+		"pod14": api.ErrorReasonUnknownPinnedCell,
+		"pod15": api.ErrorReasonInvalidSpec,
+	}
+	for podName, reason := range expectedReasons {
+		pod := allPods[podName]
+		pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+		err := tryValidatePod(pod)
+		if e, ok := err.(*api.WebServerError); !ok || e.Reason != reason {
+			t.Errorf("[%v]: Expected error with reason %v, but got %v", podName, reason, err)
+		}
+	}
+
+	pod := allPods["pod1"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	if err := tryValidatePod(pod); err != nil {
+		t.Errorf("[pod1]: Expected to be valid, but got %v", err)
+	}
+	psr := h.Schedule(pod, allNodes, internal.PreemptingPhase)
+	h.AddAllocatedPod(internal.NewBindingPod(pod, psr.PodBindInfo))
+
+	// The pods joining the allocated group1 should be consistent with it.
+	newPod := pod.DeepCopy()
+	newPod.Name, newPod.UID = "pod1-new", "pod1-new"
+	if err := tryValidatePod(newPod); err == nil {
+		t.Errorf("[%v]: Expected to be invalid since group1 is full, but got valid", newPod.Name)
+	}
+	s := pss[pod.UID]
+	s.AffinityGroup = &api.AffinityGroupSpec{
+		Name:    group1.Name,
+		Members: []api.AffinityGroupMemberSpec{{PodNumber: 2, LeafCellNumber: 1}},
+	}
+	newPod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(s)
+	if err := tryValidatePod(newPod); err == nil {
+		t.Errorf("[%v]: Expected to be invalid since group1 members mismatch, but got valid", newPod.Name)
+	}
+}
+
+func testExplainAffinityGroup(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	tryExplain := func(name string) (e api.AffinityGroupExplanation, err error) {
+		defer internal.RecoverAsError(&err)
+		return h.ExplainAffinityGroup(name), nil
+	}
+
+	pod := allPods["pod1"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	psr := h.Schedule(pod, allNodes, internal.PreemptingPhase)
+	h.AddAllocatedPod(internal.NewBindingPod(pod, psr.PodBindInfo))
+	e, err := tryExplain(group1.Name)
+	if err != nil || len(e.Attempts) != 1 || e.Attempts[0].Result != api.SchedulingAttemptBind {
+		t.Errorf("[%v]: Expected one Bind attempt, but got %v, %v", group1.Name, common.ToJson(e), err)
+	} else if steps := e.Attempts[0].Steps; len(steps) == 0 || !steps[len(steps)-1].Found {
+		t.Errorf("[%v]: Expected the last step to be found, but got %v", group1.Name, common.ToJson(steps))
+	}
+
+	// The group larger than its VC keeps waiting, and only the recent attempts are kept.
+	waitingPod := pod.DeepCopy()
+	waitingPod.Name, waitingPod.UID = "pod-explain", "pod-explain"
+	s := pss[pod.UID]
+	s.AffinityGroup = &api.AffinityGroupSpec{
+		Name:    "group-explain",
+		Members: []api.AffinityGroupMemberSpec{{PodNumber: 64, LeafCellNumber: 16}},
+	}
+	s.LeafCellNumber = 16
+	waitingPod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(s)
+	for i := 0; i < maxSchedulingAttemptsPerGroup+1; i++ {
+		h.Schedule(waitingPod, allNodes, internal.PreemptingPhase)
+	}
+	e, err = tryExplain("group-explain")
+	if err != nil || len(e.Attempts) != maxSchedulingAttemptsPerGroup {
+		t.Errorf("[group-explain]: Expected %v attempts, but got %v, %v",
+			maxSchedulingAttemptsPerGroup, common.ToJson(e), err)
+	} else if a := e.Attempts[0]; a.Result != api.SchedulingAttemptWait || a.Reason == "" ||
+		len(a.Steps) == 0 || a.Steps[0].VirtualClusterFailedReason == "" {
+		t.Errorf("[group-explain]: Expected to wait for VC resource, but got %v", common.ToJson(a))
+	}
+
+	h.DeleteUnallocatedPod(waitingPod)
+	if _, err = tryExplain("group-explain"); err == nil {
+		t.Errorf("[group-explain]: Expected the attempts to be dropped with the waiting pod")
+	}
+}
+
+func testGetClusterSummary(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	for _, podName := range []string{"pod1", "pod4"} {
+		pod := allPods[podName]
+		pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+		psr := h.Schedule(pod, allNodes, internal.PreemptingPhase)
+		h.AddAllocatedPod(internal.NewBindingPod(pod, psr.PodBindInfo))
+	}
+	summary := h.GetClusterSummary()
+
+	s := summary.VirtualClusters["VC1"]["DGX2-V100"]
+	if s == nil || s.Quota == 0 || s.Used != 1 || s.Opportunistic != 1 ||
+		s.Free != s.Quota-s.Used-s.DoomedBad ||
+		!reflect.DeepEqual(s.UsedByPriority, map[int32]int32{0: 1, -1: 1}) {
+		t.Errorf("[VC1]: Expected one guaranteed and one opportunistic leaf cell used, but got %v",
+			common.ToJson(s))
+	}
+	for leafCellType, pcs := range summary.PhysicalCluster {
+		quota := int32(0)
+		for _, vcs := range summary.VirtualClusters {
+			if s := vcs[leafCellType]; s != nil {
+				quota += s.Quota
+			}
+		}
+		used := int32(0)
+		if leafCellType == "DGX2-V100" {
+			used = 2
+		}
+		if pcs.Quota != quota || pcs.Free != pcs.Total-used || pcs.Bad != 0 {
+			t.Errorf("[%v]: Expected quota %v and %v leaf cells used, but got %v",
+				leafCellType, quota, used, common.ToJson(pcs))
+		}
+	}
+}
+
+func testWatch(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	h.StartWatchFrom(1)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+	h.generateWatchEvents()
+
+	_, rv, newer := h.Watch(0)
+	pod := allPods["pod1"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	psr := h.Schedule(pod, allNodes, internal.PreemptingPhase)
+	bindingPod := internal.NewBindingPod(pod, psr.PodBindInfo)
+	h.AddAllocatedPod(bindingPod)
+	select {
+	case <-newer:
+	default:
+		t.Errorf("Expected the watch to be notified after allocating pod1")
+	}
+	groupName := pss[pod.UID].AffinityGroup.Name
+	events, latest, _ := h.Watch(rv)
+	if latest != rv+uint64(len(events)) {
+		t.Errorf("Expected %v events after %v, but got %v", latest-rv, rv, len(events))
+	}
+	groupAdded, physicalCellUsed := false, false
+	for i, e := range events {
+		if e.ResourceVersion != strconv.FormatUint(rv+uint64(i)+1, 10) {
+			t.Errorf("Expected ResourceVersion %v, but got %v", rv+uint64(i)+1, e.ResourceVersion)
+		}
+		if e.Type == api.WatchEventAdded && e.AffinityGroup.Name == groupName {
+			groupAdded = true
+		}
+		if e.PhysicalCell != nil && e.PhysicalCell.CellState == api.CellState(cellUsed) {
+			physicalCellUsed = true
+		}
+	}
+	if !groupAdded || !physicalCellUsed {
+		t.Errorf("Expected group %v added and physical cells used, but got %v",
+			groupName, common.ToJson(events))
+	}
+
+	h.DeleteAllocatedPod(bindingPod)
+	events, _, _ = h.Watch(latest)
+	if len(events) == 0 || events[len(events)-1].Type != api.WatchEventDeleted ||
+		events[len(events)-1].AffinityGroup.Name != groupName {
+		t.Errorf("Expected group %v deleted at last, but got %v", groupName, common.ToJson(events))
+	}
+
+	for _, expiredRv := range []uint64{1, latest + uint64(len(events)) + 1} {
+		var err error
+		func() {
+			defer internal.RecoverAsError(&err)
+			h.Watch(expiredRv)
+		}()
+		if err == nil {
+			t.Errorf("Expected error when watching from ResourceVersion %v, but got nil", expiredRv)
+		}
+	}
+
+	// the versions continue in the replacing algorithm, and the replaced ones expire
+	_, latest, _ = h.Watch(0)
+	newH := NewHivedAlgorithm(sConfig)
+	newH.StartWatchFrom(latest)
+	setHealthyNodes(newH)
+	if _, newLatest, _ := newH.Watch(0); newLatest <= latest {
+		t.Errorf("Expected the versions to continue after %v, but got %v", latest, newLatest)
+	}
+	var err error
+	func() {
+		defer internal.RecoverAsError(&err)
+		newH.Watch(latest)
+	}()
+	if err == nil {
+		t.Errorf("Expected error when watching from the replaced ResourceVersion %v, but got nil", latest)
+	}
+}
+
+type usageRecordResult struct {
+	recordType api.UsageRecordType
+	groupName  string
+	preemptor  string
+}
+
+func testUsageRecords(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	// the records are resynced when they are taken for the first time
+	if records := h.TakeUsageRecords(false); len(records) != 1 ||
+		records[0].Type != api.UsageRecordResynced || len(records[0].AffinityGroups) != 0 {
+		t.Errorf("Expected an empty Resynced record, but got %v", common.ToJson(records))
+	}
+
+	for _, podName := range casesForStatefulPreemption {
+		pod := allPods[podName]
+		pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+		psr := h.Schedule(pod, allNodes, internal.PreemptingPhase)
+		if psr.PodBindInfo != nil {
+			h.AddAllocatedPod(internal.NewBindingPod(pod, psr.PodBindInfo))
+		}
+		if podName == "pod33" {
+			h.DeleteAllocatedPod(h.affinityGroups[pss["pod28"].AffinityGroup.Name].allocatedPods[16][0])
+		}
+	}
+	h.CancelAffinityGroupPreemption("group26")
+	h.SetAffinityGroupLazyPreempted("group25", true)
+	h.SetAffinityGroupLazyPreempted("group25", false)
+	h.SetAffinityGroupLazyPreempted("group25", true)
+
+	expectedRecords := []usageRecordResult{
+		{api.UsageRecordAllocated, "group19", ""},
+		{api.UsageRecordPreempted, "group19", "group20"},
+		{api.UsageRecordPreempted, "group19", "group22"},
+		{api.UsageRecordPreempted, "group19", "group24"},
+		{api.UsageRecordReleased, "group19", ""},
+		{api.UsageRecordAllocated, "group25", ""},
+		{api.UsageRecordPreempted, "group25", "group26"},
+		{api.UsageRecordLazyPreempted, "group25", adminPreemptor},
+		{api.UsageRecordLazyPreemptionReverted, "group25", ""},
+		{api.UsageRecordLazyPreempted, "group25", adminPreemptor},
+	}
+	records := h.TakeUsageRecords(false)
+	var results []usageRecordResult
+	for _, r := range records {
+		results = append(results, usageRecordResult{r.Type, r.AffinityGroup.Name, r.Preemptor})
+	}
+	if !reflect.DeepEqual(results, expectedRecords) {
+		t.Errorf("Expected records %v, but got %v", expectedRecords, common.ToJson(records))
+	}
+	expectedGroup := api.UsageAffinityGroup{
+		Name:           "group19",
+		VirtualCluster: "VC1",
+		Priority:       1,
+		User:           "test/default",
+		LeafCellType:   "DGX2-V100",
+		LeafCellNumber: 32,
+	}
+	if len(records) > 0 && !reflect.DeepEqual(*records[0].AffinityGroup, expectedGroup) {
+		t.Errorf("Expected group %v, but got %v",
+			common.ToJson(expectedGroup), common.ToJson(records[0].AffinityGroup))
+	}
+	if records = h.TakeUsageRecords(false); len(records) != 0 {
+		t.Errorf("Expected no records since the last taken, but got %v", common.ToJson(records))
+	}
+
+	// the groups using leaf cells are resynced on demand
+	records = h.TakeUsageRecords(true)
+	if len(records) != 1 || records[0].Type != api.UsageRecordResynced || len(records[0].AffinityGroups) != 1 ||
+		records[0].AffinityGroups[0].Name != "group25" || !records[0].AffinityGroups[0].LazyPreempted {
+		t.Errorf("Expected a Resynced record of lazy preempted group25, but got %v", common.ToJson(records))
+	}
+}
+
+func testAdministrating(t *testing.T, configFilePath string) {
+	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
+	h := NewHivedAlgorithm(sConfig)
+	for _, chains := range h.cellChains {
+		sortChains(chains)
+	}
+	setHealthyNodes(h)
+
+	// a node marked as bad takes no new allocations until it is unmarked
+	h.SetCellBad("0.0.2.1", true)
+	checkLeafCellDraining(t, h, "0.0.2.1", false, api.CellBad)
+	h.SetCellDraining("0.0.2.1", true)
+	checkLeafCellDraining(t, h, "0.0.2.1", true, api.CellBad)
+	h.SetCellBad("0.0.2.1", false)
+	checkLeafCellDraining(t, h, "0.0.2.1", true, api.CellHealthy)
+	h.SetCellDraining("0.0.2.1", false)
+	checkLeafCellDraining(t, h, "0.0.2.1", false, api.CellHealthy)
+
+	// the cells marked as bad by the admin API are replayed from the node annotation
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{
+			Name: "0.0.2.1",
+			Annotations: map[string]string{
+				api.AnnotationKeyNodeBadCells: string(h.findAdminPhysicalCell("0.0.2.1").GetAddress()),
+			},
+		},
+		Status: core.NodeStatus{
+			Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+		},
+	}
+	h.AddNode(node)
+	checkLeafCellDraining(t, h, "0.0.2.1", false, api.CellBad)
+	unmarkedNode := node.DeepCopy()
+	delete(unmarkedNode.Annotations, api.AnnotationKeyNodeBadCells)
+	h.UpdateNode(node, unmarkedNode)
+	checkLeafCellDraining(t, h, "0.0.2.1", false, api.CellHealthy)
+
+	// the preemption of pod35 is canceled instead of its pod being deleted
+	var pod *core.Pod
+	for _, podName := range casesForStatefulPreemption {
+		pod = allPods[podName]
+		pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+		psr := h.Schedule(pod, allNodes, internal.PreemptingPhase)
+		if psr.PodBindInfo != nil {
+			h.AddAllocatedPod(internal.NewBindingPod(pod, psr.PodBindInfo))
+		}
+		if podName == "pod33" {
+			h.DeleteAllocatedPod(h.affinityGroups[pss["pod28"].AffinityGroup.Name].allocatedPods[16][0])
+		}
+	}
+	preemptorName := pss[pod.UID].AffinityGroup.Name
+	victimName := pss["pod34"].AffinityGroup.Name
+	h.CancelAffinityGroupPreemption(preemptorName)
+	if h.affinityGroups[preemptorName] != nil {
+		t.Errorf("Group %v is expected to be deleted after its preemption is canceled, but not", preemptorName)
+	}
+	victim := h.affinityGroups[victimName]
+	for _, podPlacements := range victim.physicalLeafCellPlacement {
+		for _, podLeafCells := range podPlacements {
+			for _, leafCell := range podLeafCells {
+				pLeafCell := leafCell.(*PhysicalCell)
+				if pLeafCell.GetState() != cellUsed || int32(pLeafCell.GetPriority()) != victim.priority {
+					t.Errorf("Cell %v should be used by %v, but is %v at priority %v",
+						victimName, pLeafCell.GetAddress(), pLeafCell.GetState(), pLeafCell.GetPriority())
+				}
+			}
+		}
+	}
+
+	// the lazy preemption by admin can be reverted if the VC cells are still free
+	h.SetAffinityGroupLazyPreempted(victimName, true)
+	if victim.virtualLeafCellPlacement != nil || victim.lazyPreemptionStatus == nil ||
+		victim.lazyPreemptionStatus.Preemptor != adminPreemptor {
+		t.Errorf("Group %v is expected to be lazy preempted by admin, but got %v",
+			victimName, common.ToJson(victim.ToAffinityGroup()))
+	}
+	h.SetAffinityGroupLazyPreempted(victimName, false)
+	if victim.virtualLeafCellPlacement == nil || victim.lazyPreemptionStatus != nil {
+		t.Errorf("Group %v is expected to be reverted to VC, but got %v",
+			victimName, common.ToJson(victim.ToAffinityGroup()))
+	}
+	for _, podPlacements := range victim.virtualLeafCellPlacement {
+		for _, podLeafCells := range podPlacements {
+			for _, leafCell := range podLeafCells {
+				vLeafCell := leafCell.(*VirtualCell)
+				if vLeafCell.GetPhysicalCell() == nil || int32(vLeafCell.GetPriority()) != victim.priority {
+					t.Errorf("Virtual cell %v should be used by %v, but is at priority %v",
+						vLeafCell.GetAddress(), victimName, vLeafCell.GetPriority())
+				}
+			}
+		}
+	}
+
+	// the lazy preempted group cannot be changed once it is being preempted by others
+	h.SetAffinityGroupLazyPreempted(victimName, true)
+	pod = allPods["pod32"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	psr := h.Schedule(pod, allNodes, internal.PreemptingPhase)
+	h.AddAllocatedPod(internal.NewBindingPod(pod, psr.PodBindInfo))
+	pod = allPods["pod30"]
+	pod.Annotations[api.AnnotationKeyPodSchedulingSpec] = common.ToYaml(pss[pod.UID])
+	if psr = h.Schedule(pod, allNodes, internal.PreemptingPhase); psr.PodPreemptInfo == nil {
+		t.Errorf("Pod %v is expected to preempt %v, but got %v", pod.Name, victimName, common.ToJson(psr))
+	}
+	for _, f := range []func(){
+		func() { h.SetAffinityGroupLazyPreempted(victimName, false) },
+		func() { h.SetAffinityGroupLazyPreempted(victimName, true) },
+		func() { h.CancelAffinityGroupPreemption(victimName) },
+		func() { h.SetCellBad("0.0.9.9", true) },
+	} {
+		var err error
+		func() {
+			defer internal.RecoverAsError(&err)
+			f()
+		}()
+		if e, ok := err.(*api.WebServerError); !ok || e.Code != http.StatusBadRequest {
+			t.Errorf("Expected bad request error, but got %v", err)
+		}
+	}
+	if victim.virtualLeafCellPlacement != nil || victim.lazyPreemptionStatus == nil {
+		t.Errorf("Group %v is expected to keep lazy preempted, but got %v",
+			victimName, common.ToJson(victim.ToAffinityGroup()))
+	}
+}
+
+func checkLeafCellHealthiness(
+	t *testing.T,
+	h *HivedAlgorithm,
+	nodeName string,
+	expected map[int32]api.CellHealthiness) {
+
+	for _, ccl := range h.fullCellList {
+		for _, c := range ccl[1] {
+			nodes, leafCellIndices := c.(*PhysicalCell).GetPhysicalPlacement()
+			if nodes[0] != nodeName {
+				continue
+			}
+			if e, ok := expected[leafCellIndices[0]]; ok {
+				if healthiness := c.(*PhysicalCell).GetAPIStatus().CellHealthiness; healthiness != e {
+					t.Errorf("Leaf cell %v should be %v, but it is %v", c.GetAddress(), e, healthiness)
+				}
+				if e == api.CellBad && c.GetParent().(*PhysicalCell).IsHealthy() {
+					t.Errorf("Parent of bad leaf cell %v should be bad", c.GetAddress())
+				}
+			}
+		}
+	}
+}
+
 func testSafeRelaxedBuddyAlloc(t *testing.T, configFilePath string) {
 	sConfig := api.NewConfig(api.InitRawConfig(&configFilePath))
 	(*sConfig.VirtualClusters)["VC1"].VirtualCells[0].CellNumber = 4
@@ -131,7 +131,7 @@ type node struct {
 	freeLeafCellNumAtPriority     int32           // free leaf cell number at the priority of the pod to be scheduled (lower priority considered as free)
 	usedLeafCellNumSamePriority   int32           // leaf cell number used by the same priority as that of the pod to be scheduled
 	usedLeafCellNumHigherPriority int32           // leaf cell number used by higher priorities than that of the pod to be scheduled
-	healthy                       bool            // if the node is healthy
+	healthy                       bool            // if the node is healthy and not draining
 	suggested                     bool            // if the node is within suggested nodes
 	nodeAddress                   api.CellAddress // used for logging the node address when bad or not suggested
 }
@@ -168,6 +168,8 @@ func (n *node) updateUsedLeafCellNumForPriority(p CellPriority, crossPriorityPac
 			n.freeLeafCellNumAtPriority -= num
 		}
 	}
+	// the draining leaf cells cannot be used by the pod even if they are free
+	n.freeLeafCellNumAtPriority -= getDrainingLeafCellNum(n.c, p)
 	klog.Infof("updateUsedLeafCellNumForPriority node %v usedLeafCellNumSamePriority: %v usedLeafCellNumHigherPriority %v", 
 		n.c.GetAddress(), n.usedLeafCellNumSamePriority, n.usedLeafCellNumHigherPriority)
 	// 如果crossPriorityPack=true，那么usedLeafCellNumSamePriority实际上是usedLeafCellNum，不管priority是多少
@@ -280,13 +282,13 @@ func nodeHealthyAndInSuggested(
 	switch v := n.c.(type) {
 	case *PhysicalCell:
 		nodeNames, _ := v.GetPhysicalPlacement()
-		return v.IsHealthy(),
+		return v.IsHealthy() && !v.IsDraining(),
 			ignoreSuggestedNodes || suggestedNodes.Contains(nodeNames[0]),
 			n.c.GetAddress()
 	case *VirtualCell:
 		if pn := v.GetPhysicalCell(); pn != nil {
 			nodeNames, _ := pn.GetPhysicalPlacement()
-			return pn.IsHealthy(),
+			return pn.IsHealthy() && !pn.IsDraining(),
 				ignoreSuggestedNodes || suggestedNodes.Contains(nodeNames[0]),
 				pn.GetAddress()
 		}
@@ -544,6 +546,8 @@ func getLeafCellsFromNode(c Cell, p CellPriority, freeLeafCells CellList, preemp
 		for _, cc := range c.GetChildren() {
 			freeLeafCells, preemptibleLeafCells = getLeafCellsFromNode(cc, p, freeLeafCells, preemptibleLeafCells)
 		}
+	} else if isDrainingLeafCell(c) {
+		return freeLeafCells, preemptibleLeafCells
 	} else if c.GetPriority() == freePriority {
 		freeLeafCells = append(freeLeafCells, c)
 	} else if c.GetPriority() < p {
@@ -551,3 +555,28 @@ func getLeafCellsFromNode(c Cell, p CellPriority, freeLeafCells CellList, preemp
 	}
 	return freeLeafCells, preemptibleLeafCells
 }
+
+// getDrainingLeafCellNum returns the number of draining leaf cells in a cell
+// that are free at a priority (i.e., free or used by lower priorities).
+func getDrainingLeafCellNum(c Cell, p CellPriority) (num int32) {
+	if c.GetLevel() > 1 {
+		for _, cc := range c.GetChildren() {
+			num += getDrainingLeafCellNum(cc, p)
+		}
+	} else if c.GetPriority() < p && isDrainingLeafCell(c) {
+		num = 1
+	}
+	return num
+}
+
+// isDrainingLeafCell checks if a leaf cell is draining (or bound to a draining physical cell).
+func isDrainingLeafCell(c Cell) bool {
+	switch v := c.(type) {
+	case *PhysicalCell:
+		return v.IsDraining()
+	case *VirtualCell:
+		pc := v.GetPhysicalCell()
+		return pc != nil && pc.IsDraining()
+	}
+	return false
+}
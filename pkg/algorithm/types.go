@@ -25,6 +25,7 @@ package algorithm
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/microsoft/hivedscheduler/pkg/api"
 	"github.com/microsoft/hivedscheduler/pkg/common"
@@ -225,6 +226,10 @@ func (p groupPhysicalPlacement) nodeToLeafCellIndices() map[string][]int32 {
 	for _, podPlacements := range p {
 		for _, podPlacement := range podPlacements {
 			for _, leafCell := range podPlacement {
+				// the leaf cells of the pods not allocated yet (e.g., during recovery) are nil
+				if leafCell == nil {
+					continue
+				}
 				pLeafCell := leafCell.(*PhysicalCell)
 				nodes, leafCellIndices := pLeafCell.GetPhysicalPlacement()
 				if _, ok := nodeToLeafCellIndices[nodes[0]]; !ok {
@@ -246,6 +251,10 @@ func (p groupVirtualPlacement) preassignedCellToLeafCells() map[api.CellAddress]
 	for _, podPlacements := range p {
 		for _, podPlacement := range podPlacements {
 			for _, leafCell := range podPlacement {
+				// the leaf cells of the pods not allocated yet (e.g., during recovery) are nil
+				if leafCell == nil {
+					continue
+				}
 				vLeafCell := leafCell.(*VirtualCell)
 				address := vLeafCell.GetAddress()
 				preassignedAddress := vLeafCell.GetPreassignedCell().GetAddress()
@@ -345,3 +354,35 @@ type cellBindingPathVertex struct {
 	cell           *VirtualCell
 	childrenToBind []*cellBindingPathVertex
 }
+
+// clock creates the timers, so that the time-based logics (e.g. the healthy
+// damping) can be driven by a fake clock in tests.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine after the duration elapses.
+	AfterFunc(d time.Duration, f func()) timer
+}
+
+// timer is a timer created by a clock, which can be stopped before it fires.
+type timer interface {
+	Stop() bool
+}
+
+// healthyDamping is the damping of a node which has turned healthy, whose timer
+// marks the node as healthy at the deadline.
+type healthyDamping struct {
+	timer    timer
+	deadline time.Time
+}
+
+// realClock is the clock backed by the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) timer {
+	return time.AfterFunc(d, f)
+}
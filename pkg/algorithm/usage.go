@@ -0,0 +1,128 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package algorithm
+
+import (
+	"sort"
+
+	"github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newUsageAffinityGroup returns the part of an affinity group to account its usage.
+// The user is got from the pod if it is not nil, otherwise from any pod of the group.
+func (h *HivedAlgorithm) newUsageAffinityGroup(g *AlgoAffinityGroup, pod *core.Pod) *api.UsageAffinityGroup {
+	ug := &api.UsageAffinityGroup{
+		Name:           g.name,
+		VirtualCluster: g.vc,
+		Priority:       g.priority,
+		LazyPreempted:  g.lazyPreemptionStatus != nil,
+	}
+	for _, podPlacements := range g.physicalLeafCellPlacement {
+		for _, podPlacement := range podPlacements {
+			for _, leafCell := range podPlacement {
+				if leafCell != nil {
+					ug.LeafCellType = string(h.cellTypes[leafCell.GetChain()][leafCell.GetLevel()])
+					ug.LeafCellNumber++
+				}
+			}
+		}
+	}
+	if pod == nil {
+		for _, podList := range g.allocatedPods {
+			for _, p := range podList {
+				if p != nil {
+					pod = p
+				}
+			}
+		}
+	}
+	if pod != nil {
+		ug.User = internal.ExtractPodServiceAccount(pod)
+	}
+	return ug
+}
+
+// addUsageRecord records a usage change of an affinity group. It is a no-op before the
+// usage records are taken for the first time, i.e. when nobody is recording them.
+func (h *HivedAlgorithm) addUsageRecord(
+	t api.UsageRecordType, g *AlgoAffinityGroup, pod *core.Pod, preemptor string) {
+	if !h.usageRecording {
+		return
+	}
+	h.usageRecords = append(h.usageRecords, api.UsageRecord{
+		Time:          meta.Now(),
+		Type:          t,
+		AffinityGroup: h.newUsageAffinityGroup(g, pod),
+		Preemptor:     preemptor,
+	})
+	if len(h.usageRecords) > maxUsageRecords {
+		// the records are not taken for too long, so drop them and resync
+		// when they are taken next time
+		h.usageRecords = nil
+		h.usageRecording = false
+	}
+}
+
+// dropUsageRecord drops the last usage record of an affinity group which has not been taken,
+// if it is of the given type, i.e. the change is reverted before it is exposed.
+func (h *HivedAlgorithm) dropUsageRecord(t api.UsageRecordType, groupName string) {
+	for i := len(h.usageRecords) - 1; i >= 0; i-- {
+		if r := h.usageRecords[i]; r.AffinityGroup != nil && r.AffinityGroup.Name == groupName {
+			if r.Type == t {
+				h.usageRecords = append(h.usageRecords[:i], h.usageRecords[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// TakeUsageRecords returns the usage records since the last call, and starts to record the
+// following ones. If resync, or the records since the last call are not complete (such as
+// it is the first call), a single Resynced record is returned instead.
+func (h *HivedAlgorithm) TakeUsageRecords(resync bool) []api.UsageRecord {
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+
+	records := h.usageRecords
+	if resync || !h.usageRecording {
+		r := api.UsageRecord{Time: meta.Now(), Type: api.UsageRecordResynced}
+		var names []string
+		for name, g := range h.affinityGroups {
+			// the preempting groups do not use their leaf cells until they are allocated
+			if g.state != groupPreempting {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r.AffinityGroups = append(r.AffinityGroups, *h.newUsageAffinityGroup(h.affinityGroups[name], nil))
+		}
+		records = []api.UsageRecord{r}
+	}
+	h.usageRecords = nil
+	h.usageRecording = true
+	return records
+}
@@ -25,6 +25,7 @@ package algorithm
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 
 	"github.com/microsoft/hivedscheduler/pkg/api"
 	"github.com/microsoft/hivedscheduler/pkg/common"
@@ -170,8 +171,8 @@ func generateAffinityGroupBindInfo(
 	return affinityGroupBindInfo, selectedNode, selectedLeafCellIndices, chain
 }
 
-// collectBadOrNonSuggestedNodes collects all the nodes that are not within the suggested nodes
-// in the physical placement of an affinity group.
+// collectBadOrNonSuggestedNodes collects all the nodes that are bad, draining, or not within the
+// suggested nodes in the physical placement of an affinity group.
 func collectBadOrNonSuggestedNodes(
 	placement groupPhysicalPlacement,
 	suggestedNodes common.Set,
@@ -186,7 +187,7 @@ func collectBadOrNonSuggestedNodes(
 					continue
 				}
 				nodes, _ := leafCell.(*PhysicalCell).GetPhysicalPlacement()
-				if !leafCell.(*PhysicalCell).IsHealthy() ||
+				if !leafCell.(*PhysicalCell).IsHealthy() || leafCell.(*PhysicalCell).IsDraining() ||
 					(!ignoreSuggestedNodes && !suggestedNodes.Contains(nodes[0])) {
 					badOrNonSuggestedNodes.Add(nodes[0])
 				}
@@ -245,6 +246,21 @@ func victimsToString(victimPods map[string]common.Set) string {
 	return common.ToJson(s)
 }
 
+// victimsToKeys converts the victim pods to their keys, sorted in each node.
+func victimsToKeys(victimPods map[string]common.Set) map[string][]string {
+	if len(victimPods) == 0 {
+		return nil
+	}
+	keys := map[string][]string{}
+	for node, victims := range victimPods {
+		for v := range victims.Items() {
+			keys[node] = append(keys[node], internal.Key(v.(*core.Pod)))
+		}
+		sort.Strings(keys[node])
+	}
+	return keys
+}
+
 // retrieveMissingPodPlacement finds the placement of a pod from the annotation of other pods in the same group
 // when the pod's placement has been invalid (i.e., not found in the spec).
 func retrieveMissingPodPlacement(g *AlgoAffinityGroup, leafCellNum int32, podIndex int32) (api.PodPlacementInfo, string) {
@@ -414,6 +430,20 @@ func allChildrenSameState(c *PhysicalCell, s CellState) bool {
 	return true
 }
 
+// findPhysicalCell finds a physical cell in the full list by its address.
+func findPhysicalCell(fullCellList map[CellChain]ChainCellList, address api.CellAddress) *PhysicalCell {
+	for _, ccl := range fullCellList {
+		for _, cl := range ccl {
+			for _, c := range cl {
+				if c.GetAddress() == address {
+					return c.(*PhysicalCell)
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // generateOTVirtualCell generates a fake virtual cell in a VC's API status
 // for an opportunistic cell used by the VC.
 func generateOTVirtualCell(pc *api.PhysicalCellStatus) *api.VirtualCellStatus {
@@ -450,3 +480,21 @@ func deleteOTVirtualCell(s api.VirtualClusterStatus, addr api.CellAddress) api.V
 	s[n-1] = nil
 	return s[:n-1]
 }
+
+// findNodeLevelPhysicalCell finds the node-level physical cell of a node.
+func findNodeLevelPhysicalCell(fullCellList map[CellChain]ChainCellList, nodeName string) *PhysicalCell {
+	for _, ccl := range fullCellList {
+		for _, cl := range ccl {
+			for _, c := range cl {
+				pc := c.(*PhysicalCell)
+				if !pc.GetAPIStatus().IsNodeLevel {
+					continue
+				}
+				if nodes, _ := pc.GetPhysicalPlacement(); len(nodes) == 1 && nodes[0] == nodeName {
+					return pc
+				}
+			}
+		}
+	}
+	return nil
+}
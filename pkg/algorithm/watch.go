@@ -0,0 +1,242 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package algorithm
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/microsoft/hivedscheduler/pkg/api"
+)
+
+// cellChanges records the cells whose statuses have been changed since the last
+// watch events were generated, in the order of their first changes.
+type cellChanges struct {
+	physicalCells []*PhysicalCell
+	virtualCells  []*VirtualCell
+	changed       map[Cell]bool
+}
+
+func newCellChanges() *cellChanges {
+	return &cellChanges{changed: map[Cell]bool{}}
+}
+
+// add records a changed cell. It is a no-op on a nil cellChanges, i.e. before
+// the watch is initialized.
+func (cc *cellChanges) add(c Cell) {
+	if cc == nil || cc.changed[c] {
+		return
+	}
+	cc.changed[c] = true
+	switch cell := c.(type) {
+	case *PhysicalCell:
+		cc.physicalCells = append(cc.physicalCells, cell)
+	case *VirtualCell:
+		cc.virtualCells = append(cc.virtualCells, cell)
+	}
+}
+
+func (cc *cellChanges) reset() {
+	cc.physicalCells = nil
+	cc.virtualCells = nil
+	cc.changed = map[Cell]bool{}
+}
+
+// watchedGroup is the part of an affinity group's status whose change will
+// generate a watch event.
+type watchedGroup struct {
+	group                *AlgoAffinityGroup
+	state                AffinityGroupState
+	lazyPreemptionStatus *api.LazyPreemptionStatus
+	allocatedPodNum      int
+	preemptingPodNum     int
+}
+
+func newWatchedGroup(g *AlgoAffinityGroup) watchedGroup {
+	wg := watchedGroup{
+		group:                g,
+		state:                g.state,
+		lazyPreemptionStatus: g.lazyPreemptionStatus,
+		preemptingPodNum:     len(g.preemptingPods),
+	}
+	for _, pods := range g.allocatedPods {
+		for _, p := range pods {
+			if p != nil {
+				wg.allocatedPodNum++
+			}
+		}
+	}
+	return wg
+}
+
+// initWatchEvents starts to record the changes of all the cells and affinity
+// groups, so that the following changes can be watched.
+func (h *HivedAlgorithm) initWatchEvents() {
+	h.cellChanges = newCellChanges()
+	for _, ccl := range h.fullCellList {
+		for _, cl := range ccl {
+			for _, c := range cl {
+				c.(*PhysicalCell).SetChanges(h.cellChanges)
+			}
+		}
+	}
+	for _, vcs := range h.vcSchedulers {
+		for _, ccl := range vcs.getNonPinnedFullCellList() {
+			for _, cl := range ccl {
+				for _, c := range cl {
+					c.(*VirtualCell).SetChanges(h.cellChanges)
+				}
+			}
+		}
+		for _, ccl := range vcs.getPinnedCells() {
+			for _, cl := range ccl {
+				for _, c := range cl {
+					c.(*VirtualCell).SetChanges(h.cellChanges)
+				}
+			}
+		}
+	}
+	h.groupChanges = map[string]bool{}
+	h.watchedGroups = map[string]watchedGroup{}
+	h.newWatchEvents = make(chan struct{})
+}
+
+// recordGroupChange records an affinity group which has been created, deleted,
+// or whose watched status may have been changed. It is a no-op before the watch
+// is initialized.
+func (h *HivedAlgorithm) recordGroupChange(name string) {
+	if h.groupChanges != nil {
+		h.groupChanges[name] = true
+	}
+}
+
+// StartWatchFrom drops the watch events so far, and lets the resourceVersions
+// start after the given one.
+func (h *HivedAlgorithm) StartWatchFrom(resourceVersion uint64) {
+	h.algorithmLock.Lock()
+	defer h.algorithmLock.Unlock()
+
+	h.resourceVersion = resourceVersion + 1
+	h.watchEvents = nil
+}
+
+// generateWatchEvents generates the watch events for the cells and affinity
+// groups changed since the last call, and notifies the watches if any.
+// It should be called with the algorithm lock held for writing.
+func (h *HivedAlgorithm) generateWatchEvents() {
+	oldResourceVersion := h.resourceVersion
+	for _, c := range h.cellChanges.physicalCells {
+		s := *c.GetAPIStatus()
+		s.CellChildren = nil
+		if s.VirtualCell != nil {
+			vcs := *s.VirtualCell
+			s.VirtualCell = &vcs
+		}
+		h.addWatchEvent(api.WatchEvent{Type: api.WatchEventModified, PhysicalCell: &s})
+	}
+	for _, c := range h.cellChanges.virtualCells {
+		s := *c.GetAPIStatus()
+		s.CellChildren = nil
+		if s.PhysicalCell != nil {
+			pcs := *s.PhysicalCell
+			s.PhysicalCell = &pcs
+		}
+		h.addWatchEvent(api.WatchEvent{Type: api.WatchEventModified, VirtualCell: &s})
+	}
+	h.cellChanges.reset()
+
+	var names []string
+	for name := range h.groupChanges {
+		names = append(names, name)
+	}
+	h.groupChanges = map[string]bool{}
+	sort.Strings(names)
+	for _, name := range names {
+		old, existed := h.watchedGroups[name]
+		g := h.affinityGroups[name]
+		if g == nil {
+			if !existed {
+				// the group was created and deleted again since the last call
+				continue
+			}
+			delete(h.watchedGroups, name)
+			ag := old.group.ToAffinityGroup()
+			h.addWatchEvent(api.WatchEvent{Type: api.WatchEventDeleted, AffinityGroup: &ag})
+			continue
+		}
+		wg := newWatchedGroup(g)
+		if existed && wg == old {
+			continue
+		}
+		h.watchedGroups[name] = wg
+		ag := g.ToAffinityGroup()
+		if existed && old.group == g {
+			h.addWatchEvent(api.WatchEvent{Type: api.WatchEventModified, AffinityGroup: &ag})
+		} else {
+			if existed {
+				// the group was deleted and created again since the last call
+				oldAg := old.group.ToAffinityGroup()
+				h.addWatchEvent(api.WatchEvent{Type: api.WatchEventDeleted, AffinityGroup: &oldAg})
+			}
+			h.addWatchEvent(api.WatchEvent{Type: api.WatchEventAdded, AffinityGroup: &ag})
+		}
+	}
+
+	if h.resourceVersion != oldResourceVersion {
+		close(h.newWatchEvents)
+		h.newWatchEvents = make(chan struct{})
+	}
+}
+
+func (h *HivedAlgorithm) addWatchEvent(e api.WatchEvent) {
+	h.resourceVersion++
+	e.ResourceVersion = strconv.FormatUint(h.resourceVersion, 10)
+	h.watchEvents = append(h.watchEvents, e)
+	if len(h.watchEvents) > maxWatchEvents {
+		h.watchEvents = h.watchEvents[len(h.watchEvents)-maxWatchEvents:]
+	}
+}
+
+// Watch returns the watch events after the resourceVersion (0 means from now),
+// the latest resourceVersion, and a channel which will be closed once there are
+// events newer than the latest resourceVersion.
+func (h *HivedAlgorithm) Watch(resourceVersion uint64) (
+	events []api.WatchEvent, latestResourceVersion uint64, newer <-chan struct{}) {
+	h.algorithmLock.RLock()
+	defer h.algorithmLock.RUnlock()
+
+	if resourceVersion == 0 {
+		return nil, h.resourceVersion, h.newWatchEvents
+	}
+	oldestResourceVersion := h.resourceVersion - uint64(len(h.watchEvents))
+	if resourceVersion < oldestResourceVersion || resourceVersion > h.resourceVersion {
+		panic(api.NewWebServerError(http.StatusGone, fmt.Sprintf(
+			"ResourceVersion %v is expired, the oldest ResourceVersion that can be resumed from is %v",
+			resourceVersion, oldestResourceVersion)))
+	}
+	n := h.resourceVersion - resourceVersion
+	events = append(events, h.watchEvents[uint64(len(h.watchEvents))-n:]...)
+	return events, h.resourceVersion, h.newWatchEvents
+}
@@ -64,6 +64,43 @@ type Config struct {
 	// Default to :9096
 	WebServerAddress *string `yaml:"webServerAddress"`
 
+	// If specified, the Scheduler Inspect API, Metrics API and Admin API are
+	// served at this address, separated from the Scheduler Extender API and
+	// Admission API served at WebServerAddress, so that they can be exposed to
+	// different clients.
+	// Default to empty, i.e. all the APIs are served at WebServerAddress.
+	InspectWebServerAddress *string `yaml:"inspectWebServerAddress"`
+
+	// If specified, the WebServer serves HTTPS instead of HTTP at all addresses,
+	// see WebServerTLSSpec.
+	// Default to nil, i.e. HTTP.
+	WebServerTLS *WebServerTLSSpec `yaml:"webServerTLS"`
+
+	// If specified, all the requests to the WebServer must be authenticated,
+	// see WebServerAuthenticationSpec.
+	// Default to nil, i.e. not authenticated.
+	WebServerAuthentication *WebServerAuthenticationSpec `yaml:"webServerAuthentication"`
+
+	// If specified, the requests to the Scheduler Admin API must carry one of the
+	// tokens in the file as "Authorization: Bearer <token>".
+	// Each line of the file is a token, optionally followed by ",<user>", and the
+	// user is recorded in the audit log of the Admin API.
+	// The tokens can also authenticate the requests to the other APIs, see
+	// WebServerAuthenticationSpec.
+	// Default to nil, i.e. the Admin API is only allowed for the AdminUsers and
+	// AdminGroups in WebServerAuthentication, and it is disabled if they are not
+	// specified either.
+	WebServerAdminTokenFile *string `yaml:"webServerAdminTokenFile"`
+
+	// If specified, the usage of the affinity groups is appended to the file as
+	// the JSON lines of UsageRecord, such as their allocations, releases and
+	// preemptions, so that the usage can be accounted later, see UsagePath.
+	// The file is split by day into {UsageLedgerFile}.{start time}.
+	// It is only appended by the leader, see LeaderElection, so its directory
+	// must exist and be a persistent volume shared by all the replicas.
+	// Default to nil, i.e. the usage is not recorded.
+	UsageLedgerFile *string `yaml:"usageLedgerFile"`
+
 	// Specify a threshold for PodBindAttempts, that after it is exceeded, an extra
 	// Pod binding will be executed forcefully.
 	ForcePodBindThreshold *int32 `yaml:"forcePodBindThreshold"`
@@ -76,10 +113,78 @@ type Config struct {
 	// K8S Default Scheduler.
 	WaitingPodSchedulingBlockMilliSec *int64 `yaml:"waitingPodSchedulingBlockMilliSec"`
 
+	// If specified, multiple replicas can be deployed with one leader and the
+	// others as hot standby, see LeaderElectionSpec.
+	// The current role can be inspected from LeaderElectionStatusPath.
+	// Default to nil, i.e. disabled, so only one replica should be deployed.
+	LeaderElection *LeaderElectionSpec `yaml:"leaderElection"`
+
+	// If true, the AffinityGroup custom resources are watched, so that a Pod can
+	// reference an AffinityGroup instead of specifying the whole PodSchedulingSpec,
+	// see AffinityGroupResource.
+	// The CRD should be created before the scheduler starts.
+	// Default to false
+	AffinityGroupResourceEnable *bool `yaml:"affinityGroupResourceEnable"`
+
+	// If true, the VirtualCluster custom resources are watched and applied, so
+	// that the VCs can be changed without restart, see VirtualClusterResource.
+	// The VirtualClusters in the config are still used, unless they are
+	// overridden by the VirtualCluster custom resources of the same name.
+	// The CRD should be created before the scheduler starts.
+	// Default to false
+	VirtualClusterResourceEnable *bool `yaml:"virtualClusterResourceEnable"`
+
+	// If specified, the Pods can only use the VCs and priorities allowed by the
+	// access rules, and the Pods violating them are rejected both at admission
+	// and scheduling, see AccessRuleSpec.
+	// Default to nil, i.e. all Pods can use all VCs and priorities.
+	AccessRules *[]AccessRuleSpec `yaml:"accessRules"`
+
+	// Specify the default PodSchedulingSpec of the Pods in each namespace, which
+	// is injected by the mutating admission webhook, see NamespaceDefaultSpec.
+	// Default to empty, i.e. no Pod is mutated.
+	NamespaceDefaults *map[string]NamespaceDefaultSpec `yaml:"namespaceDefaults"`
+
+	// Specify the kinds of the PodGroup custom resources of other schedulers to be
+	// watched, so that a Pod can reference a PodGroup as the source of its
+	// affinity group, see PodGroupResource.
+	// The Pod which specifies its affinity group still works.
+	// The CRDs should be created before the scheduler starts.
+	// Default to empty, i.e. disabled.
+	PodGroupKinds *[]PodGroupKind `yaml:"podGroupKinds"`
+
+	// If true, the Pod controlled by a Job or StatefulSet, which does not specify
+	// its affinity group, is in the affinity group of all the Pods of its
+	// controller, instead of the affinity group of itself, so that they are gang
+	// scheduled. The affinity group name is the controller UID and its size is
+	// the Job parallelism or StatefulSet replicas when the Pod is scheduled.
+	// Default to false
+	OwnerAffinityGroupEnable *bool `yaml:"ownerAffinityGroupEnable"`
+
 	// Specify the whole physical cluster
-	// TODO: Automatically construct it based on node info from Device Plugins
+	// It can also be automatically constructed based on node info, see
+	// PhysicalClusterDiscovery.
 	PhysicalCluster *PhysicalClusterSpec `yaml:"physicalCluster"`
 
+	// If specified, the physical cluster will be automatically constructed at
+	// startup based on the node labels and node allocatable resources, see
+	// PhysicalClusterDiscoverySpec.
+	// The PhysicalCluster above still can be specified as the explicit overrides:
+	// 1. Its cellTypes override the discovered cellTypes with the same name.
+	//    A node is skipped to be discovered if the overridden cellTypes mismatch
+	//    its leaf cell number or topology.
+	// 2. Its physicalCells take precedence over the discovered physicalCells on
+	//    the nodes they contain.
+	// The final physical cluster can be inspected from PhysicalClusterSpecPath,
+	// so that it can be checked in as the explicit PhysicalCluster later.
+	// Default to nil, i.e. disabled.
+	PhysicalClusterDiscovery *PhysicalClusterDiscoverySpec `yaml:"physicalClusterDiscovery"`
+
+	// Specify how to decide whether a node is healthy, see NodeHealthPolicySpec.
+	// Default to the policy that a node is healthy if it is not unschedulable and
+	// in ready condition.
+	NodeHealthPolicy *NodeHealthPolicySpec `yaml:"nodeHealthPolicy"`
+
 	// Specify all the virtual clusters belongs to the physical cluster
 	VirtualClusters *map[VirtualClusterName]VirtualClusterSpec `yaml:"virtualClusters"`
 }
@@ -97,27 +202,61 @@ func NewConfig(rawConfig *Config) *Config {
 	if c.WebServerAddress == nil {
 		c.WebServerAddress = common.PtrString(":9096")
 	}
+	if c.InspectWebServerAddress == nil {
+		c.InspectWebServerAddress = common.PtrString("")
+	}
 	if c.ForcePodBindThreshold == nil {
 		c.ForcePodBindThreshold = common.PtrInt32(3)
 	}
 	if c.WaitingPodSchedulingBlockMilliSec == nil {
 		c.WaitingPodSchedulingBlockMilliSec = common.PtrInt64(0)
 	}
+	if c.LeaderElection != nil {
+		defaultingLeaderElection(c.LeaderElection)
+	}
+	if c.AffinityGroupResourceEnable == nil {
+		c.AffinityGroupResourceEnable = common.PtrBool(false)
+	}
+	if c.VirtualClusterResourceEnable == nil {
+		c.VirtualClusterResourceEnable = common.PtrBool(false)
+	}
+	if c.PodGroupKinds == nil {
+		c.PodGroupKinds = &[]PodGroupKind{}
+	}
+	if c.OwnerAffinityGroupEnable == nil {
+		c.OwnerAffinityGroupEnable = common.PtrBool(false)
+	}
+	if c.AccessRules != nil {
+		defaultingAccessRules(*c.AccessRules)
+	}
+	if c.NamespaceDefaults == nil {
+		c.NamespaceDefaults = &map[string]NamespaceDefaultSpec{}
+	}
+	defaultingNamespaceDefaults(*c.NamespaceDefaults)
 	if c.PhysicalCluster == nil {
 		c.PhysicalCluster = defaultPhysicalCluster()
 	}
+	if c.PhysicalClusterDiscovery != nil {
+		defaultingPhysicalClusterDiscovery(c.PhysicalClusterDiscovery)
+	}
+	if c.NodeHealthPolicy == nil {
+		c.NodeHealthPolicy = &NodeHealthPolicySpec{}
+	}
+	defaultingNodeHealthPolicy(c.NodeHealthPolicy)
 	if c.VirtualClusters == nil {
 		c.VirtualClusters = defaultVirtualClusters()
 	}
 	// Append default value for empty items in physical cell
-	defaultingPhysicalCells(c.PhysicalCluster)
+	DefaultingPhysicalCells(c.PhysicalCluster)
 	// Validation
 	// TODO: Validate VirtualClusters against PhysicalCluster
 
 	return c
 }
 
-func defaultingPhysicalCells(pc *PhysicalClusterSpec) {
+// DefaultingPhysicalCells appends default value for empty items in the physical
+// cells, so it should only be called once for each PhysicalClusterSpec.
+func DefaultingPhysicalCells(pc *PhysicalClusterSpec) {
 	cts := pc.CellTypes
 	pcs := pc.PhysicalCells
 	for idx, pc := range pcs {
@@ -166,6 +305,84 @@ func inferPhysicalCellSpec(
 	return
 }
 
+func defaultingPhysicalClusterDiscovery(d *PhysicalClusterDiscoverySpec) {
+	if d.LeafCellTypeLabelKey == "" {
+		d.LeafCellTypeLabelKey = LabelKeyLeafCellType
+	}
+	if d.LeafCellResourceName == "" {
+		d.LeafCellResourceName = ResourceNameNvidiaGpu
+	}
+	if d.LeafCellTopologyLabelKey == "" {
+		d.LeafCellTopologyLabelKey = LabelKeyLeafCellTopology
+	}
+	if d.RackLabelKey == "" {
+		d.RackLabelKey = LabelKeyRack
+	}
+}
+
+func defaultingLeaderElection(l *LeaderElectionSpec) {
+	if l.LeaseNamespace == "" {
+		l.LeaseNamespace = "default"
+	}
+	if l.LeaseName == "" {
+		l.LeaseName = ComponentName
+	}
+	if l.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			panic(fmt.Errorf("Failed to get hostname as leaderElection identity: %v", err))
+		}
+		l.Identity = hostname
+	}
+	if l.LeaseDurationSec == nil {
+		l.LeaseDurationSec = common.PtrInt64(15)
+	}
+	if l.RenewDeadlineSec == nil {
+		l.RenewDeadlineSec = common.PtrInt64(10)
+	}
+	if l.RetryPeriodSec == nil {
+		l.RetryPeriodSec = common.PtrInt64(2)
+	}
+}
+
+func defaultingAccessRules(rules []AccessRuleSpec) {
+	for i := range rules {
+		if rules[i].MaxPriority == nil {
+			rules[i].MaxPriority = common.PtrInt32(MaxGuaranteedPriority)
+		}
+	}
+}
+
+func defaultingNamespaceDefaults(defaults map[string]NamespaceDefaultSpec) {
+	for ns, d := range defaults {
+		if d.LeafCellResourceName == "" {
+			d.LeafCellResourceName = ResourceNameNvidiaGpu
+		}
+		defaults[ns] = d
+	}
+}
+
+func defaultingNodeHealthPolicy(p *NodeHealthPolicySpec) {
+	if p.UnschedulableIsBad == nil {
+		p.UnschedulableIsBad = common.PtrBool(true)
+	}
+	if p.HealthyConditions == nil {
+		p.HealthyConditions = []NodeConditionSpec{{
+			Type:   "Ready",
+			Status: "True",
+		}}
+	}
+	if p.BadTaints == nil {
+		p.BadTaints = []NodeTaintSpec{}
+	}
+	if p.BadLabelSelectors == nil {
+		p.BadLabelSelectors = []string{}
+	}
+	if p.HealthyDampingSec == nil {
+		p.HealthyDampingSec = common.PtrInt64(0)
+	}
+}
+
 func defaultKubeConfigFilePath() *string {
 	configPath := EnvValueKubeConfigFilePath
 	_, err := os.Stat(configPath)
@@ -54,6 +54,79 @@ const (
 	// It is in PodBindInfo YAML format.
 	AnnotationKeyPodBindInfo = GroupName + "/pod-bind-info"
 
+	// The Node could contain below annotation to specify its bad leaf cells, so
+	// that only these leaf cells, instead of the whole node, are considered as bad.
+	// It is in comma separated leaf cell indices format, such as "0,3", and it is
+	// generally fed by an external leaf cell health checker.
+	AnnotationKeyNodeBadLeafCellIndices = GroupName + "/node-bad-leaf-cell-indices"
+
+	// The Node could contain below label with value "true" to drain all the cells
+	// in it, i.e. the cells take no new allocations but keep their current
+	// affinity groups, so that the node can be retired gracefully.
+	LabelKeyNodeDraining = GroupName + "/node-draining"
+
+	// The Node contains below annotation to persist the cells drained by the admin
+	// API, so that they are still draining after the scheduler restarts.
+	// It is in comma separated cell addresses format, and it is set on all the
+	// nodes in the drained cells by the scheduler.
+	AnnotationKeyNodeDrainingCells = GroupName + "/node-draining-cells"
+
+	// The Node contains below annotation to persist the cells marked as bad by the
+	// admin API, in the same format as AnnotationKeyNodeDrainingCells.
+	AnnotationKeyNodeBadCells = GroupName + "/node-bad-cells"
+
+	// Used to discover the physical cluster, see PhysicalClusterDiscoverySpec.
+	LabelKeyLeafCellType     = GroupName + "/leaf-cell-type"
+	LabelKeyLeafCellTopology = GroupName + "/leaf-cell-topology"
+	LabelKeyRack             = GroupName + "/rack"
+	ResourceNameNvidiaGpu    = "nvidia.com/gpu"
+
+	// The Pod could reference an AffinityGroup custom resource in its namespace
+	// by below annotation, instead of specifying the whole PodSchedulingSpec.
+	// If the AffinityGroup has multiple members, the Pod should also specify the
+	// leaf cell number of its member by below annotation, such as "4".
+	AnnotationKeyPodAffinityGroup  = GroupName + "/pod-affinity-group"
+	AnnotationKeyPodLeafCellNumber = GroupName + "/pod-leaf-cell-number"
+
+	// Populated by this scheduler, the Pod contains below annotation with its
+	// controller UID, if its affinity group is inferred from its controller, see
+	// OwnerAffinityGroupEnable.
+	AnnotationKeyPodOwnerAffinityGroup = GroupName + "/pod-owner-affinity-group"
+
+	// The Pod could reference a PodGroup custom resource of other schedulers in
+	// its namespace by below label or annotation, see PodGroupResource.
+	LabelKeySchedulerPluginsPodGroup = "scheduling.x-k8s.io/pod-group"
+	AnnotationKeyVolcanoPodGroup     = "scheduling.k8s.io/group-name"
+
+	// Populated by this scheduler, the Pod contains below annotation with its
+	// PodGroup UID, if its affinity group is resolved from the PodGroup.
+	AnnotationKeyPodPodGroupAffinityGroup = GroupName + "/pod-podgroup-affinity-group"
+
+	// The AffinityGroup custom resource, see AffinityGroupResource.
+	AffinityGroupResourceVersion = "v1"
+	AffinityGroupResourcePlural  = "affinitygroups"
+
+	// The VirtualCluster custom resource, see VirtualClusterResource.
+	VirtualClusterResourceVersion = "v1"
+	VirtualClusterResourcePlural  = "virtualclusters"
+
+	// The WebServer error response contains below header to expose the
+	// WebServerError Reason, if any.
+	HeaderKeyErrorReason = "X-Hivedscheduler-Error-Reason"
+
+	// Populated by this scheduler, the Pod contains below condition to expose
+	// its current PodState in the scheduler, with the Reason as the PodState.
+	PodConditionTypePodState = GroupName + "/pod-state"
+
+	// Populated by this scheduler, the Events recorded on Pods and Nodes to
+	// expose the scheduling decisions.
+	EventReasonWaiting       = "HivedWaiting"
+	EventReasonPreempting    = "HivedPreempting"
+	EventReasonPreempted     = "HivedPreempted"
+	EventReasonLazyPreempted = "HivedLazyPreempted"
+	EventReasonForceBinding  = "HivedForceBinding"
+	EventReasonUnauthorized  = "HivedUnauthorized"
+
 	// Priority Range of Guaranteed Pod.
 	MaxGuaranteedPriority = int32(1000)
 	MinGuaranteedPriority = int32(0)
@@ -85,10 +158,64 @@ const (
 	InspectPath = VersionPath + "/inspect"
 	// Inspect current allocated AffinityGroup(s)
 	AffinityGroupsPath = InspectPath + "/affinitygroups/"
+	// Inspect current tracked (not completed) Pod(s) by UID, see PodScheduleStatus,
+	// which can be filtered by the namespace and state query parameters
+	PodsPath = InspectPath + "/pods/"
+	// Explain the recent scheduling attempts of an AffinityGroup, including the
+	// waiting one, by AffinityGroupsPath + name + ExplainPathSuffix
+	ExplainPathSuffix = "/explain"
 	// Inspect current cluster status
 	ClusterStatusPath = InspectPath + "/clusterstatus"
 	// Inspect current physical cluster status
 	PhysicalClusterPath = ClusterStatusPath + "/physicalcluster"
 	// Inspect current virtual cluster(s)' status
 	VirtualClustersPath = ClusterStatusPath + "/virtualclusters/"
+	// Watch the incremental changes of the cluster status, as Server-Sent Events
+	// of WatchEvent, from now or after the resourceVersion (query parameter or
+	// Last-Event-ID header)
+	WatchPath = InspectPath + "/watch"
+	// Inspect current leaf cell usage rollup of the physical cluster and each VC,
+	// see ClusterSummary
+	ClusterSummaryPath = InspectPath + "/summary"
+	// Inspect the physical cluster spec currently used, which may be discovered,
+	// see PhysicalClusterDiscoverySpec
+	PhysicalClusterSpecPath = InspectPath + "/physicalclusterspec"
+	// Inspect the leader election role of current replica, see LeaderElectionSpec
+	LeaderElectionStatusPath = InspectPath + "/leaderelection"
+	// Dry run (POST) a PodSchedulingSpec in the same format as the Pod annotation,
+	// i.e. tell what would happen if a Pod with it were scheduled now, without
+	// changing any state, see DryRunResult
+	DryRunPath = InspectPath + "/dryrun"
+	// Inspect the leaf cell usage aggregated from the usage ledger over the time
+	// range of the start and end query parameters in RFC3339 format, see
+	// UsageReport and UsageLedgerFile
+	UsagePath = InspectPath + "/usage"
+
+	// Scheduler Metrics API: Expose the Prometheus metrics of the scheduler
+	MetricsPath = RootPath + "metrics"
+
+	// Scheduler Admin API: API to change current scheduling status
+	AdminPath = VersionPath + "/admin"
+	// Drain (PUT) or undrain (DELETE) a physical cell by its address, or a node
+	// by its name
+	DrainingCellsPath = AdminPath + "/drainingcells/"
+	// Mark (PUT) or unmark (DELETE) a physical cell as bad by its address, or a
+	// node by its name
+	BadCellsPath = AdminPath + "/badcells/"
+	// Change an AffinityGroup by AdminAffinityGroupsPath + name + suffix:
+	// 1. Cancel (DELETE) the preemption of a preempting AffinityGroup by
+	//    PreemptionPathSuffix.
+	// 2. Lazy preempt (PUT) an allocated AffinityGroup or revert (DELETE) it by
+	//    LazyPreemptionPathSuffix.
+	AdminAffinityGroupsPath  = AdminPath + "/affinitygroups/"
+	PreemptionPathSuffix     = "/preemption"
+	LazyPreemptionPathSuffix = "/lazypreemption"
+
+	// Scheduler Admission API: Admission webhook API with K8S ApiServer
+	AdmissionPath = VersionPath + "/admission"
+	// Validate the Pod at its creation time, see AdmissionReview
+	ValidatePodPath = AdmissionPath + "/validatepod"
+	// Inject the namespace default PodSchedulingSpec into the Pod at its creation
+	// time, see NamespaceDefaultSpec
+	MutatePodPath = AdmissionPath + "/mutatepod"
 )
@@ -26,6 +26,7 @@ import (
 	"fmt"
 
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -58,21 +59,260 @@ type PhysicalCellSpec struct {
 	CellChildren []PhysicalCellSpec `yaml:"cellChildren,omitempty"`
 }
 
+// Discover physical cluster from nodes:
+// 1. A node is discovered only if it has the LeafCellTypeLabelKey label and
+//    positive allocatable LeafCellResourceName resource. The label value is its
+//    leaf cell type and the resource quantity is its leaf cell number.
+// 2. The LeafCellTopologyLabelKey label value of a node, if any, is the comma
+//    separated child cell numbers from the node level down to the leaf level,
+//    such as "2,2,2" for a node with 2 CPU sockets, 2 PCI-e switches per socket
+//    and 2 leaf cells per switch. Their product must equal to the leaf cell
+//    number. Default to the flat topology, i.e. all leaf cells are directly
+//    under the node.
+//    All nodes with the same leaf cell type must have the same topology, and the
+//    nodes that do not match the first one in name order will be skipped.
+// 3. The nodes with the same RackLabelKey label value, if any, are grouped as
+//    one higher level cell, if they have the same leaf cell type. Otherwise, the
+//    node itself is a top level cell.
+// Generated cell type names:
+// 1. Leaf level: {leafCellType}, such as "V100".
+// 2. Below the node level: {leafCellNumber}-{leafCellType}, such as "4-V100".
+// 3. Node level: {leafCellType}-NODE, such as "V100-NODE".
+// 4. Rack level: {nodeNumber}-{leafCellType}-NODE, such as "3-V100-NODE".
+type PhysicalClusterDiscoverySpec struct {
+	// Default to LabelKeyLeafCellType
+	LeafCellTypeLabelKey string `yaml:"leafCellTypeLabelKey"`
+	// Default to ResourceNameNvidiaGpu
+	LeafCellResourceName string `yaml:"leafCellResourceName"`
+	// Default to LabelKeyLeafCellTopology
+	LeafCellTopologyLabelKey string `yaml:"leafCellTopologyLabelKey"`
+	// Default to LabelKeyRack
+	RackLabelKey string `yaml:"rackLabelKey"`
+}
+
+// LeaderElectionSpec enables multiple scheduler replicas with the lease based
+// leader election:
+// 1. All replicas keep their scheduling view synced, i.e. the standby replicas
+//    are hot and can serve as the leader immediately once they gain leadership.
+// 2. Only the leader serves the scheduling requests, i.e. the extender and the
+//    framework plugin, and others only serve the inspect requests.
+// 3. The leader exits once it loses leadership, and it will be restarted as a
+//    standby replica.
+type LeaderElectionSpec struct {
+	// Default to default
+	LeaseNamespace string `yaml:"leaseNamespace"`
+	// Default to ComponentName
+	LeaseName string `yaml:"leaseName"`
+	// Identity of current replica, which should be unique among all replicas.
+	// Default to the hostname
+	Identity string `yaml:"identity"`
+	// Default to 15
+	LeaseDurationSec *int64 `yaml:"leaseDurationSec"`
+	// Default to 10
+	RenewDeadlineSec *int64 `yaml:"renewDeadlineSec"`
+	// Default to 2
+	RetryPeriodSec *int64 `yaml:"retryPeriodSec"`
+}
+
+// NodeHealthPolicySpec decides whether a node is healthy, i.e. its leaf cells
+// can be used to schedule Pods.
+// A node is bad if ANY of the rules below matches it.
+type NodeHealthPolicySpec struct {
+	// Whether an unschedulable (cordoned) node is bad.
+	// Default to true
+	UnschedulableIsBad *bool `yaml:"unschedulableIsBad"`
+	// A node is bad if it misses any one of the conditions with the given status,
+	// i.e. a healthy node must have all of them, such as the Ready condition and a
+	// custom GPUHealthy condition reported by an external health checker.
+	// Note it replaces the default instead of appending to it, so Ready should
+	// also be included if it is still needed.
+	// Default to [{type: Ready, status: "True"}]
+	HealthyConditions []NodeConditionSpec `yaml:"healthyConditions"`
+	// A node is bad if it has any taint matching one of them.
+	// Default to empty
+	BadTaints []NodeTaintSpec `yaml:"badTaints"`
+	// A node is bad if its labels match any of the label selectors, which are in
+	// the same format as kubectl --selector, such as "gpu-health in (bad,unknown)".
+	// Default to empty
+	BadLabelSelectors []string `yaml:"badLabelSelectors"`
+	// A bad node is declared healthy again only after it keeps healthy for this
+	// period, to avoid flapping the bad cells (and the doomed bad cell bindings)
+	// on every node status transition.
+	// Default to 0, i.e. declared healthy immediately
+	HealthyDampingSec *int64 `yaml:"healthyDampingSec"`
+}
+
+type NodeConditionSpec struct {
+	Type   string `yaml:"type"`
+	Status string `yaml:"status"`
+}
+
+type NodeTaintSpec struct {
+	Key string `yaml:"key"`
+	// Empty effect matches all effects.
+	Effect string `yaml:"effect"`
+}
+
+// WebServerTLSSpec specifies the serving certificate of the WebServer.
+// The certificate and key files are reloaded once they are changed, so that the
+// certificate can be rotated without restart.
+type WebServerTLSSpec struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// If specified, the client certificates are verified by the CA bundle, and
+	// a verified one authenticates the client, see WebServerAuthenticationSpec.
+	// Default to empty, i.e. the client certificates are not requested.
+	ClientCAFile string `yaml:"clientCAFile"`
+}
+
+// WebServerAuthenticationSpec specifies how to authenticate the requests to the
+// WebServer.
+// A request is authenticated by the first one of below which succeeds:
+// 1. The client certificate verified by WebServerTLSSpec.ClientCAFile, whose
+//    common name is the user and organizations are the groups.
+// 2. The bearer token in the WebServerAdminTokenFile.
+// 3. The bearer token reviewed by the K8S TokenReview API, if TokenReviewEnable.
+// The request which is not authenticated is rejected with 401, and the one whose
+// user is not allowed is rejected with 403.
+type WebServerAuthenticationSpec struct {
+	// Default to false
+	TokenReviewEnable bool `yaml:"tokenReviewEnable"`
+	// Only the users or the users in the groups are allowed.
+	// Default to empty, i.e. all the authenticated users are allowed.
+	AllowedUsers  []string `yaml:"allowedUsers"`
+	AllowedGroups []string `yaml:"allowedGroups"`
+	// Only the users or the users in the groups are allowed to call the Admin
+	// API, besides the ones authenticated by the WebServerAdminTokenFile.
+	// Default to empty, i.e. only the WebServerAdminTokenFile is allowed.
+	AdminUsers  []string `yaml:"adminUsers"`
+	AdminGroups []string `yaml:"adminGroups"`
+}
+
+// The kind of the PodGroup custom resources of other schedulers, which can be
+// the source of the affinity group, see PodGroupResource.
+type PodGroupKind string
+
+const (
+	// The scheduling.x-k8s.io/v1alpha1 PodGroup of kubernetes-sigs/scheduler-plugins,
+	// which is referenced by the Pod label LabelKeySchedulerPluginsPodGroup.
+	PodGroupKindSchedulerPlugins PodGroupKind = "scheduler-plugins"
+	// The scheduling.volcano.sh/v1beta1 PodGroup of Volcano, which is referenced
+	// by the Pod annotation AnnotationKeyVolcanoPodGroup.
+	PodGroupKindVolcano PodGroupKind = "volcano"
+)
+
+// PodGroupResource is the common part of the PodGroup custom resources of all
+// PodGroupKinds. It is the source of the affinity group of the Pods referencing
+// it, which still specify the other PodSchedulingSpec:
+// 1. The affinity group name is the PodGroup UID.
+// 2. The affinity group has one member with MinMember Pods, each of which has
+//    the LeafCellNumber of the Pod, default to its total nvidia.com/gpu resource
+//    limits, so all the Pods in the PodGroup should request the same number of
+//    leaf cells.
+// And its Phase is updated from the AffinityGroupState in this scheduler.
+type PodGroupResource struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:"metadata,omitempty"`
+	Spec            PodGroupResourceSpec   `json:"spec"`
+	Status          PodGroupResourceStatus `json:"status,omitempty"`
+}
+
+type PodGroupResourceSpec struct {
+	MinMember int32 `json:"minMember"`
+}
+
+type PodGroupResourceStatus struct {
+	Phase string `json:"phase,omitempty"`
+}
+
+// The access rule of VCs and priorities:
+// A Pod can use a VC only if it matches any rule which allows the VC, i.e. its
+// namespace is in Namespaces or its service account is in ServiceAccounts, and
+// its priority is not greater than the MaxPriority of the rule.
+type AccessRuleSpec struct {
+	Namespaces []string `yaml:"namespaces"`
+	// In "namespace/name" format.
+	ServiceAccounts []string             `yaml:"serviceAccounts"`
+	VirtualClusters []VirtualClusterName `yaml:"virtualClusters"`
+	// Default to MaxGuaranteedPriority
+	MaxPriority *int32 `yaml:"maxPriority"`
+}
+
+// The default PodSchedulingSpec of the Pods in a namespace, which is injected by
+// the mutating admission webhook, see MutatePodPath:
+// 1. It is only injected to the Pod which requests the LeafCellResourceName
+//    resource but does not specify its PodSchedulingSpec, and its LeafCellNumber
+//    is the total LeafCellResourceName resource limits of the Pod containers.
+// 2. If the Pod is controlled by a Job or StatefulSet, all the Pods of the
+//    controller are in one affinity group, whose name is the controller UID and
+//    whose size is the Job parallelism or StatefulSet replicas.
+//    Otherwise, the Pod itself is an affinity group.
+// 3. The ResourceNamePodSchedulingEnable resource limit is also injected, so the
+//    Pod is scheduled by this scheduler.
+type NamespaceDefaultSpec struct {
+	VirtualCluster VirtualClusterName `yaml:"virtualCluster"`
+	Priority       int32              `yaml:"priority"`
+	LeafCellType   string             `yaml:"leafCellType"`
+	// Default to ResourceNameNvidiaGpu
+	LeafCellResourceName string `yaml:"leafCellResourceName"`
+}
+
 // Virtual cluster definition
 type VirtualClusterName string
 
 type VirtualClusterSpec struct {
-	VirtualCells []VirtualCellSpec `yaml:"virtualCells"`
-	PinnedCells  []PinnedCellSpec  `yaml:"pinnedCells,omitempty"`
+	VirtualCells []VirtualCellSpec `yaml:"virtualCells" json:"virtualCells"`
+	PinnedCells  []PinnedCellSpec  `yaml:"pinnedCells,omitempty" json:"pinnedCells,omitempty"`
 }
 
 type VirtualCellSpec struct {
-	CellNumber int32    `yaml:"cellNumber"`
-	CellType   CellType `yaml:"cellType"`
+	CellNumber int32    `yaml:"cellNumber" json:"cellNumber"`
+	CellType   CellType `yaml:"cellType" json:"cellType"`
 }
 
 type PinnedCellSpec struct {
-	PinnedCellId PinnedCellId `yaml:"pinnedCellId"`
+	PinnedCellId PinnedCellId `yaml:"pinnedCellId" json:"pinnedCellId"`
+}
+
+// VirtualClusterResource is the VirtualCluster custom resource, which is an
+// alternative to specify the VirtualClusterSpec in the config, so that the VC
+// can be changed without restarting the scheduler.
+// Its name is the VC name, and it overrides the VC of the same name in the config.
+// A change is only applied if all the VCs are still safe, i.e. they can be
+// guaranteed by the physical cluster, otherwise it is rejected with the
+// VirtualClusterConditionApplied False, and the previous VCs are kept.
+type VirtualClusterResource struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:"metadata,omitempty"`
+	Spec            VirtualClusterSpec           `json:"spec"`
+	Status          VirtualClusterResourceStatus `json:"status,omitempty"`
+}
+
+type VirtualClusterResourceStatus struct {
+	// Summary of the VirtualClusterStatus: leaf cell type -> leaf cell numbers
+	LeafCells  map[string]VirtualClusterLeafCellSummary `json:"leafCells,omitempty"`
+	Conditions []VirtualClusterCondition                `json:"conditions,omitempty"`
+}
+
+type VirtualClusterLeafCellSummary struct {
+	Total int32 `json:"total"`
+	Used  int32 `json:"used"`
+	Bad   int32 `json:"bad"`
+}
+
+type VirtualClusterConditionType string
+
+const (
+	// Whether the current Spec is applied.
+	VirtualClusterConditionApplied VirtualClusterConditionType = "Applied"
+)
+
+type VirtualClusterCondition struct {
+	Type VirtualClusterConditionType `json:"type"`
+	// "True" or "False"
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
 }
 
 type PodSchedulingSpec struct {
@@ -93,8 +333,35 @@ type AffinityGroupSpec struct {
 }
 
 type AffinityGroupMemberSpec struct {
-	PodNumber      int32 `yaml:"podNumber"`
-	LeafCellNumber int32 `yaml:"leafCellNumber"`
+	PodNumber      int32 `yaml:"podNumber" json:"podNumber"`
+	LeafCellNumber int32 `yaml:"leafCellNumber" json:"leafCellNumber"`
+}
+
+// AffinityGroupResource is the AffinityGroup custom resource, which is an
+// alternative to specify the same PodSchedulingSpec in each Pod's annotation.
+// A Pod references it by AnnotationKeyPodAffinityGroup, then the Pod's
+// PodSchedulingSpec is resolved from its Spec, and its Status mirrors the
+// AffinityGroupStatus in the scheduler.
+// Its affinity group name in the scheduler is {namespace}/{name}.
+type AffinityGroupResource struct {
+	meta.TypeMeta   `json:",inline"`
+	meta.ObjectMeta `json:"metadata,omitempty"`
+	Spec            AffinityGroupResourceSpec `json:"spec"`
+	Status          AffinityGroupStatus       `json:"status,omitempty"`
+}
+
+// The same as PodSchedulingSpec, except for the leaf cell number of each Pod,
+// which is specified by the Members.
+type AffinityGroupResourceSpec struct {
+	VirtualCluster       VirtualClusterName `json:"virtualCluster"`
+	Priority             int32              `json:"priority"`
+	PinnedCellId         PinnedCellId       `json:"pinnedCellId,omitempty"`
+	LeafCellType         string             `json:"leafCellType,omitempty"`
+	GangReleaseEnable    bool               `json:"gangReleaseEnable,omitempty"`
+	LazyPreemptionEnable bool               `json:"lazyPreemptionEnable,omitempty"`
+	// Default to true
+	IgnoreK8sSuggestedNodes *bool                     `json:"ignoreK8sSuggestedNodes,omitempty"`
+	Members                 []AffinityGroupMemberSpec `json:"members"`
 }
 
 // Used to recover scheduler allocated resource
@@ -122,10 +389,38 @@ type WebServerPaths struct {
 }
 
 type WebServerError struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code int `json:"code"`
+	// Machine-readable reason of the error, empty if it is not categorized.
+	Reason  ErrorReason `json:"reason,omitempty"`
+	Message string      `json:"message"`
 }
 
+type ErrorReason string
+
+const (
+	// The pod scheduling spec is invalid, such as malformed or requesting
+	// resource which is not defined.
+	ErrorReasonInvalidSpec ErrorReason = "InvalidSpec"
+	// The pod requests a VC which is not defined.
+	ErrorReasonUnknownVirtualCluster ErrorReason = "UnknownVirtualCluster"
+	// The pod requests a pinned cell which is not defined in its VC.
+	ErrorReasonUnknownPinnedCell ErrorReason = "UnknownPinnedCell"
+	// The pod requests a VC or priority which it is not allowed to use, see
+	// AccessRuleSpec.
+	ErrorReasonUnauthorized ErrorReason = "Unauthorized"
+	// The pod has to wait since there is no sufficient preemptible or free
+	// resource now. It is not an error of the request itself, so it is only
+	// exposed along with the wait reason, see PodScheduleStatus.
+	ErrorReasonCapacityExhausted ErrorReason = "CapacityExhausted"
+	// The pod has to wait since the resource is sufficient in its VC, but it
+	// would need to use at least one bad, draining or K8S non-suggested node.
+	ErrorReasonBadOrNonSuggestedNodes ErrorReason = "BadOrNonSuggestedNodes"
+	// The pod has to wait for its preemption victims to be deleted.
+	ErrorReasonPreempting ErrorReason = "Preempting"
+	// The internal invariant of the scheduler is broken, i.e. a platform bug.
+	ErrorReasonInternalInvariant ErrorReason = "InternalInvariant"
+)
+
 func NewWebServerError(code int, message string) *WebServerError {
 	return &WebServerError{
 		Code:    code,
@@ -133,17 +428,69 @@ func NewWebServerError(code int, message string) *WebServerError {
 	}
 }
 
+func NewWebServerErrorWithReason(code int, reason ErrorReason, message string) *WebServerError {
+	return &WebServerError{
+		Code:    code,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
 func (err *WebServerError) Error() string {
+	if err.Reason != "" {
+		return fmt.Sprintf("Code: %v, Reason: %v, Message: %v", err.Code, err.Reason, err.Message)
+	}
 	return fmt.Sprintf("Code: %v, Message: %v", err.Code, err.Message)
 }
 
+// AdmissionReview is the request and response of the admission webhook.
+// It mirrors the admission.k8s.io/v1beta1 AdmissionReview wire format, with only
+// the fields used by this scheduler, since the K8S version this scheduler
+// depends on does not vendor it.
+type AdmissionReview struct {
+	meta.TypeMeta `json:",inline"`
+	Request       *AdmissionRequest  `json:"request,omitempty"`
+	Response      *AdmissionResponse `json:"response,omitempty"`
+}
+
+type AdmissionRequest struct {
+	UID       types.UID             `json:"uid"`
+	Kind      meta.GroupVersionKind `json:"kind"`
+	Namespace string                `json:"namespace,omitempty"`
+	Operation string                `json:"operation"`
+	Object    runtime.RawExtension  `json:"object,omitempty"`
+}
+
+type AdmissionResponse struct {
+	UID     types.UID    `json:"uid"`
+	Allowed bool         `json:"allowed"`
+	Result  *meta.Status `json:"status,omitempty"`
+	// The JSONPatch to mutate the object, only used by the mutating webhook.
+	Patch     []byte  `json:"patch,omitempty"`
+	PatchType *string `json:"patchType,omitempty"`
+}
+
+// JsonPatchOperation is one operation of the JSONPatch (RFC 6902).
+type JsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
 // WebServer Exposed Objects: Align with K8S Objects
 type ObjectMeta struct {
 	Name string `json:"name"`
 }
 
+type ListMeta struct {
+	// The token to get the next page of the list, which is empty if there are
+	// no more items.
+	Continue string `json:"continue,omitempty"`
+}
+
 type AffinityGroupList struct {
-	Items []AffinityGroup `json:"items"`
+	ListMeta `json:"metadata"`
+	Items    []AffinityGroup `json:"items"`
 }
 
 type AffinityGroup struct {
@@ -171,6 +518,258 @@ type LazyPreemptionStatus struct {
 	PreemptionTime meta.Time `json:"preemptionTime"`
 }
 
+type PodScheduleStatusList struct {
+	Items []PodScheduleStatus `json:"items"`
+}
+
+// PodScheduleStatus is the scheduling status of a Pod tracked by the scheduler,
+// i.e. a not completed Pod of the scheduler.
+type PodScheduleStatus struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+	// The Pod state in the scheduler, i.e. Waiting, Preempting, Binding or Bound.
+	State string `json:"state"`
+	// The already tried binding attempts, i.e. the times the Pod is scheduled in
+	// the Binding state.
+	BindAttempts int32 `json:"bindAttempts"`
+	// The affinity group in the PodSchedulingSpec of the Pod. It is empty if the
+	// PodSchedulingSpec is invalid.
+	AffinityGroup string `json:"affinityGroup,omitempty"`
+	// The last PodScheduleResult, which is only kept in the Waiting, Preempting
+	// and Binding states.
+	WaitReason string `json:"waitReason,omitempty"`
+	// The typed cause why the Pod has to wait, i.e. CapacityExhausted,
+	// BadOrNonSuggestedNodes or Preempting.
+	WaitErrorReason ErrorReason `json:"waitErrorReason,omitempty"`
+	// The victim Pod keys (i.e. namespace/name) to be preempted for the Pod.
+	PreemptionVictims []string     `json:"preemptionVictims,omitempty"`
+	BindInfo          *PodBindInfo `json:"bindInfo,omitempty"`
+	// The allocated Pod keys whose affinity groups are lazy preempted by the Pod.
+	LazyPreemptedPods []string `json:"lazyPreemptedPods,omitempty"`
+	// The last authorization denial message of the Pod.
+	UnauthorizedMessage string `json:"unauthorizedMessage,omitempty"`
+}
+
+// AffinityGroupExplanation explains why an affinity group is scheduled, waiting
+// or preempting, by the decision traces of its most recent scheduling attempts.
+type AffinityGroupExplanation struct {
+	ObjectMeta `json:"metadata"`
+	// The most recent SchedulingAttempts, from the oldest to the newest.
+	Attempts []SchedulingAttempt `json:"attempts"`
+}
+
+type SchedulingAttemptResult string
+
+const (
+	SchedulingAttemptBind    SchedulingAttemptResult = "Bind"
+	SchedulingAttemptPreempt SchedulingAttemptResult = "Preempt"
+	SchedulingAttemptWait    SchedulingAttemptResult = "Wait"
+	SchedulingAttemptError   SchedulingAttemptResult = "Error"
+)
+
+// SchedulingAttempt is the decision trace of scheduling a Pod of the affinity
+// group once.
+type SchedulingAttempt struct {
+	Time meta.Time `json:"time"`
+	// The Pod key, i.e. namespace/name.
+	Pod   string `json:"pod"`
+	Phase string `json:"phase"`
+	// If the affinity group is already allocated or preempting, the Pod just
+	// follows its placement, so no SchedulingAttemptStep is tried.
+	ExistingGroupState AffinityGroupState `json:"existingGroupState,omitempty"`
+	// The tried cell chains or pinned cell, in the tried order.
+	Steps []SchedulingAttemptStep `json:"steps,omitempty"`
+	// The Pods to be preempted on the found placement: node -> Pod keys.
+	// They are only preempted in the Preempting phase, otherwise the Pod waits.
+	PreemptionVictims map[string][]string     `json:"preemptionVictims,omitempty"`
+	Result            SchedulingAttemptResult `json:"result"`
+	// The wait reason or the error message.
+	Reason string `json:"reason,omitempty"`
+}
+
+// SchedulingAttemptStep is the trace of scheduling the affinity group in one
+// cell chain or pinned cell.
+type SchedulingAttemptStep struct {
+	LeafCellType string       `json:"leafCellType,omitempty"`
+	Chain        string       `json:"chain,omitempty"`
+	PinnedCellId PinnedCellId `json:"pinnedCellId,omitempty"`
+	// Whether the placement is found in this step.
+	Found bool `json:"found"`
+	// Why the placement cannot be found in the VC by the intra-VC scheduler.
+	// It is only for the guaranteed affinity group.
+	VirtualClusterFailedReason string `json:"virtualClusterFailedReason,omitempty"`
+	// Why the placement cannot be found in the physical cluster, i.e. the
+	// opportunistic scheduling failure, or the buddy allocation failure when
+	// mapping the placement found in the VC.
+	PhysicalClusterFailedReason string `json:"physicalClusterFailedReason,omitempty"`
+	// The bad, draining or non-suggested nodes in the cell chain, which may
+	// fail the mapping. It is only set if the mapping fails.
+	BadOrNonSuggestedNodes []string `json:"badOrNonSuggestedNodes,omitempty"`
+	// The affinity groups lazy preempted by the placement found in the VC, which
+	// are reverted if the mapping fails.
+	LazyPreemptedGroups []string `json:"lazyPreemptedGroups,omitempty"`
+}
+
+// ClusterSummary is the leaf cell usage rollup of the physical cluster and each
+// VC, by leaf cell type.
+type ClusterSummary struct {
+	PhysicalCluster map[string]*LeafCellSummary                        `json:"physicalCluster"`
+	VirtualClusters map[VirtualClusterName]map[string]*LeafCellSummary `json:"virtualClusters"`
+}
+
+// LeafCellSummary is the leaf cell numbers of a leaf cell type in the physical
+// cluster or a VC.
+type LeafCellSummary struct {
+	// All the leaf cells. It is only for the physical cluster.
+	Total int32 `json:"total,omitempty"`
+	// The leaf cells in the preassigned cells of the VC, or of all the VCs for
+	// the physical cluster.
+	Quota int32 `json:"quota"`
+	// The leaf cells used by the guaranteed affinity groups.
+	Used int32 `json:"used"`
+	// For a VC, the leaf cells in its Quota which are neither Used nor DoomedBad.
+	// For the physical cluster, the leaf cells which are not used by any affinity
+	// group.
+	Free int32 `json:"free"`
+	// The bad leaf cells. For a VC, they are the ones bound to bad physical
+	// cells, excluding the DoomedBad ones.
+	Bad int32 `json:"bad"`
+	// The draining leaf cells, see PhysicalCellStatus.CellDraining. For a VC,
+	// they are the ones bound to draining physical cells.
+	Draining int32 `json:"draining"`
+	// The free leaf cells in the Quota which are doomed to be bound to bad
+	// physical cells, since the healthy free cells are not enough for all the VCs.
+	DoomedBad int32 `json:"doomedBad"`
+	// The leaf cells used by the lazy preempted affinity groups, which are also
+	// counted in Opportunistic.
+	LazyPreempted int32 `json:"lazyPreempted"`
+	// The leaf cells used by the opportunistic affinity groups.
+	Opportunistic int32 `json:"opportunistic"`
+	// The used leaf cells of each priority, including the OpportunisticPriority.
+	UsedByPriority map[int32]int32 `json:"usedByPriority,omitempty"`
+}
+
+// DryRunResult tells what would happen if a Pod with the PodSchedulingSpec were
+// scheduled now, i.e. whether it would be bound, preempt others or wait.
+type DryRunResult struct {
+	// The decision trace of the scheduling, including the Result, the wait
+	// reason and the PreemptionVictims.
+	Attempt SchedulingAttempt `json:"attempt"`
+	// The affinity group that the Pod would be bound to or preempting for, whose
+	// status has the placement. It is nil if the Pod would wait.
+	AffinityGroup *AffinityGroup `json:"affinityGroup,omitempty"`
+	// The Pods whose affinity groups would be lazy preempted by the scheduling,
+	// i.e. they keep running but are downgraded to opportunistic.
+	LazyPreemptedPods []string `json:"lazyPreemptedPods,omitempty"`
+}
+
+type WatchEventType string
+
+const (
+	WatchEventAdded    WatchEventType = "Added"
+	WatchEventModified WatchEventType = "Modified"
+	WatchEventDeleted  WatchEventType = "Deleted"
+	// It only carries the latest ResourceVersion, and is sent when the watch
+	// starts from now, so that the client can resume from it.
+	WatchEventBookmark WatchEventType = "Bookmark"
+	// The watch fails, such as the ResourceVersion to resume from is too old, so
+	// the client should get the current status and watch from now again.
+	WatchEventError WatchEventType = "Error"
+)
+
+// WatchEvent is an incremental change of the cluster status, see WatchPath.
+type WatchEvent struct {
+	Type WatchEventType `json:"type"`
+	// The opaque version of the cluster status after the change, which increases
+	// with each WatchEvent, and can be used to resume the watch after reconnect.
+	// It is only valid during the lifetime of the scheduler view, i.e. it expires
+	// once the scheduler restarts or the VCs are changed.
+	ResourceVersion string `json:"resourceVersion"`
+	// The current status of the changed object, i.e. one of PhysicalCell,
+	// VirtualCell and AffinityGroup. The cell status does not have CellChildren.
+	// The cells are never Added or Deleted, and they are Modified on state,
+	// priority, healthiness, draining and binding changes.
+	PhysicalCell  *PhysicalCellStatus `json:"physicalCell,omitempty"`
+	VirtualCell   *VirtualCellStatus  `json:"virtualCell,omitempty"`
+	AffinityGroup *AffinityGroup      `json:"affinityGroup,omitempty"`
+	// The error message of the WatchEventError.
+	Message string `json:"message,omitempty"`
+}
+
+type UsageRecordType string
+
+const (
+	// The affinity group is allocated, i.e. it starts to use its leaf cells.
+	UsageRecordAllocated UsageRecordType = "Allocated"
+	// The affinity group is deleted, i.e. it stops using its leaf cells.
+	UsageRecordReleased UsageRecordType = "Released"
+	// The affinity group starts to be preempted by the Preemptor, and it keeps
+	// using its leaf cells until it is Released.
+	UsageRecordPreempted UsageRecordType = "Preempted"
+	// The affinity group is lazy preempted from its VC by the Preemptor, and it
+	// keeps using its leaf cells as opportunistic until it is Released.
+	UsageRecordLazyPreempted UsageRecordType = "LazyPreempted"
+	// The lazy preemption of the affinity group is reverted by the Admin API.
+	UsageRecordLazyPreemptionReverted UsageRecordType = "LazyPreemptionReverted"
+	// It carries all the affinity groups currently using leaf cells, and is
+	// recorded once the recording (re)starts, such as the scheduler restarts,
+	// gains leadership or its VCs are changed, since the changes before may be
+	// missed.
+	UsageRecordResynced UsageRecordType = "Resynced"
+)
+
+// UsageRecord is an entry of the append-only usage ledger, see UsageLedgerFile.
+type UsageRecord struct {
+	Time meta.Time       `json:"time"`
+	Type UsageRecordType `json:"type"`
+	// The affinity group of the record, for the types other than Resynced.
+	AffinityGroup *UsageAffinityGroup `json:"affinityGroup,omitempty"`
+	// The preemptor affinity group of the Preempted and LazyPreempted records.
+	Preemptor string `json:"preemptor,omitempty"`
+	// All the affinity groups currently using leaf cells, for the Resynced record.
+	AffinityGroups []UsageAffinityGroup `json:"affinityGroups,omitempty"`
+}
+
+// UsageAffinityGroup is the part of an affinity group to account its usage.
+type UsageAffinityGroup struct {
+	Name           string             `json:"name"`
+	VirtualCluster VirtualClusterName `json:"virtualCluster"`
+	Priority       int32              `json:"priority"`
+	// The service account {namespace}/{name} of the affinity group's Pods.
+	User string `json:"user"`
+	// The leaf cells currently used by the affinity group.
+	LeafCellType   string `json:"leafCellType"`
+	LeafCellNumber int32  `json:"leafCellNumber"`
+	LazyPreempted  bool   `json:"lazyPreempted,omitempty"`
+}
+
+// UsageReport is the leaf cell usage aggregated from the usage ledger over the
+// time range [StartTime, EndTime], see UsagePath.
+type UsageReport struct {
+	StartTime meta.Time         `json:"startTime"`
+	EndTime   meta.Time         `json:"endTime"`
+	Items     []UsageReportItem `json:"items"`
+}
+
+// UsageReportItem is the leaf cell usage of the affinity groups with the same
+// VC, user, priority and leaf cell type.
+type UsageReportItem struct {
+	VirtualCluster VirtualClusterName `json:"virtualCluster"`
+	User           string             `json:"user"`
+	Priority       int32              `json:"priority"`
+	LeafCellType   string             `json:"leafCellType"`
+	// The leaf cells used multiplied by the hours they are used.
+	LeafCellHours float64 `json:"leafCellHours"`
+	// The part of LeafCellHours after the affinity groups are lazy preempted,
+	// i.e. they are used as opportunistic instead of from the VC.
+	LazyPreemptedLeafCellHours float64 `json:"lazyPreemptedLeafCellHours"`
+	// The numbers of the Allocated, Preempted and LazyPreempted records.
+	Allocations     int32 `json:"allocations"`
+	Preemptions     int32 `json:"preemptions"`
+	LazyPreemptions int32 `json:"lazyPreemptions"`
+}
+
 type (
 	CellState       string
 	CellHealthiness string
@@ -183,6 +782,7 @@ const (
 
 type CellStatus struct {
 	LeafCellType string   `json:"leafCellType,omitempty"`
+	CellChain    string   `json:"cellChain,omitempty"`
 	CellType     CellType `json:"cellType"`
 	IsNodeLevel  bool     `json:"isNodeLevel,omitempty"`
 	// Address of a physical cell consists of its address (or index) in each level
@@ -204,6 +804,11 @@ type PhysicalCellStatus struct {
 	CellChildren []*PhysicalCellStatus `json:"cellChildren,omitempty"`
 	VC           VirtualClusterName    `json:"vc,omitempty"`
 	VirtualCell  *VirtualCellStatus    `json:"virtualCell,omitempty"`
+	// A draining cell takes no new allocations but keeps its current affinity
+	// groups, and it cannot be used for scheduling once it becomes free. But its
+	// CellHealthiness is still Healthy if its hardware is working normally.
+	// A cell is draining if any of its children is draining.
+	CellDraining bool `json:"cellDraining,omitempty"`
 }
 
 type VirtualCellStatus struct {
@@ -223,10 +828,28 @@ type ClusterStatus struct {
 	VirtualClusters map[VirtualClusterName]VirtualClusterStatus `json:"virtualClusters"`
 }
 
+type LeaderElectionRole string
+
+const (
+	// The elected leader, or the only replica if leader election is disabled.
+	LeaderElectionRoleLeader LeaderElectionRole = "Leader"
+	// The hot standby replica, which does not serve scheduling requests.
+	LeaderElectionRoleStandby LeaderElectionRole = "Standby"
+)
+
+type LeaderElectionStatus struct {
+	Role LeaderElectionRole `json:"role"`
+	// Identity of current replica, empty if leader election is disabled.
+	Identity string `json:"identity,omitempty"`
+	// Identity of current leader, empty if it is not observed yet.
+	Leader string `json:"leader,omitempty"`
+}
+
 func (pcs *PhysicalCellStatus) deepCopy() *PhysicalCellStatus {
 	copied := &PhysicalCellStatus{
-		CellStatus: pcs.CellStatus,
-		VC:         pcs.VC,
+		CellStatus:   pcs.CellStatus,
+		VC:           pcs.VC,
+		CellDraining: pcs.CellDraining,
 	}
 	if pcs.CellChildren != nil {
 		copied.CellChildren = make([]*PhysicalCellStatus, len(pcs.CellChildren))
@@ -0,0 +1,261 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	core "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// DiscoverPhysicalCluster constructs the physical cluster from the nodes, and
+// merges it with the explicit physical cluster as the overrides.
+// See PhysicalClusterDiscoverySpec for details.
+// The explicit physical cluster should be already defaulted and it is readonly.
+func DiscoverPhysicalCluster(
+	d *si.PhysicalClusterDiscoverySpec,
+	explicit *si.PhysicalClusterSpec,
+	nodes []*core.Node) *si.PhysicalClusterSpec {
+	explicitNodes := map[string]bool{}
+	for _, pc := range explicit.PhysicalCells {
+		collectPhysicalCellNodes(pc, explicit.CellTypes, explicitNodes)
+	}
+
+	sortedNodes := make([]*core.Node, len(nodes))
+	copy(sortedNodes, nodes)
+	sort.Slice(sortedNodes, func(i, j int) bool {
+		return sortedNodes[i].Name < sortedNodes[j].Name
+	})
+
+	// leaf cell type -> child cell numbers from the node level down to the leaf level
+	topologies := map[si.CellType][]int32{}
+	// rack -> leaf cell type -> node names
+	racks := map[string]map[si.CellType][]string{}
+	discovered := &si.PhysicalClusterSpec{CellTypes: map[si.CellType]si.CellTypeSpec{}}
+	discoveredNodeNum := 0
+	for _, node := range sortedNodes {
+		if explicitNodes[node.Name] {
+			continue
+		}
+		leafCellType, topology := discoverNodeTopology(d, node)
+		if topology == nil {
+			continue
+		}
+		if t, ok := topologies[leafCellType]; !ok {
+			// The explicit cell types override the discovered ones by name, so they
+			// must have the same shape as the node.
+			if merged := mergeNodeTopology(explicit.CellTypes, leafCellType, topology); !reflect.DeepEqual(merged, topology) {
+				klog.Warningf("[%v]: Skipped to discover node: its leaf cell topology %v "+
+					"mismatches %v overridden by the explicit cell types for leaf cell type %v",
+					node.Name, topology, merged, leafCellType)
+				continue
+			}
+			topologies[leafCellType] = topology
+		} else if !reflect.DeepEqual(t, topology) {
+			klog.Warningf("[%v]: Skipped to discover node: its leaf cell topology %v "+
+				"mismatches previously discovered %v for leaf cell type %v",
+				node.Name, topology, t, leafCellType)
+			continue
+		}
+
+		discoveredNodeNum++
+		if rack := node.Labels[d.RackLabelKey]; rack != "" {
+			if racks[rack] == nil {
+				racks[rack] = map[si.CellType][]string{}
+			}
+			racks[rack][leafCellType] = append(racks[rack][leafCellType], node.Name)
+		} else {
+			discovered.PhysicalCells = append(discovered.PhysicalCells, si.PhysicalCellSpec{
+				CellType:    nodeCellType(leafCellType),
+				CellAddress: si.CellAddress(node.Name),
+			})
+		}
+	}
+
+	for leafCellType, topology := range topologies {
+		for cellType, ctSpec := range generateNodeCellTypes(leafCellType, topology) {
+			discovered.CellTypes[cellType] = ctSpec
+		}
+	}
+
+	rackNames := []string{}
+	for rack := range racks {
+		rackNames = append(rackNames, rack)
+	}
+	sort.Strings(rackNames)
+	for _, rack := range rackNames {
+		leafCellTypes := []string{}
+		for leafCellType := range racks[rack] {
+			leafCellTypes = append(leafCellTypes, string(leafCellType))
+		}
+		sort.Strings(leafCellTypes)
+		for _, lct := range leafCellTypes {
+			leafCellType := si.CellType(lct)
+			nodeNames := racks[rack][leafCellType]
+			rackCellType := si.CellType(fmt.Sprintf("%v-%v", len(nodeNames), nodeCellType(leafCellType)))
+			discovered.CellTypes[rackCellType] = si.CellTypeSpec{
+				ChildCellType:   nodeCellType(leafCellType),
+				ChildCellNumber: int32(len(nodeNames)),
+			}
+			rackCell := si.PhysicalCellSpec{
+				CellType:    rackCellType,
+				CellAddress: si.CellAddress(rack),
+			}
+			if len(leafCellTypes) > 1 {
+				// Distinguish the racks which contain multiple leaf cell types
+				rackCell.CellAddress = si.CellAddress(fmt.Sprintf("%v-%v", rack, leafCellType))
+			}
+			for _, nodeName := range nodeNames {
+				rackCell.CellChildren = append(rackCell.CellChildren,
+					si.PhysicalCellSpec{CellAddress: si.CellAddress(nodeName)})
+			}
+			discovered.PhysicalCells = append(discovered.PhysicalCells, rackCell)
+		}
+	}
+
+	// Merge with the explicit overrides
+	for cellType, ctSpec := range explicit.CellTypes {
+		discovered.CellTypes[cellType] = ctSpec
+	}
+	si.DefaultingPhysicalCells(discovered)
+	discovered.PhysicalCells = append(
+		append([]si.PhysicalCellSpec{}, explicit.PhysicalCells...),
+		discovered.PhysicalCells...)
+
+	klog.Infof("Discovered %v nodes for %v leaf cell types, the final physical cluster has %v "+
+		"top level cells", discoveredNodeNum, len(topologies),
+		len(discovered.PhysicalCells))
+	return discovered
+}
+
+// Return nil topology if the node cannot be discovered.
+func discoverNodeTopology(
+	d *si.PhysicalClusterDiscoverySpec,
+	node *core.Node) (leafCellType si.CellType, topology []int32) {
+	leafCellType = si.CellType(node.Labels[d.LeafCellTypeLabelKey])
+	if leafCellType == "" {
+		return "", nil
+	}
+	quantity, ok := node.Status.Allocatable[core.ResourceName(d.LeafCellResourceName)]
+	if !ok || quantity.Value() <= 0 {
+		klog.Warningf("[%v]: Skipped to discover node: it has no allocatable %v",
+			node.Name, d.LeafCellResourceName)
+		return "", nil
+	}
+	leafCellNumber := int32(quantity.Value())
+
+	topologyStr := strings.TrimSpace(node.Labels[d.LeafCellTopologyLabelKey])
+	if topologyStr == "" {
+		return leafCellType, []int32{leafCellNumber}
+	}
+	product := int32(1)
+	for _, s := range strings.Split(topologyStr, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+		if err != nil || n <= 0 {
+			klog.Warningf("[%v]: Skipped to discover node: invalid leaf cell topology %v",
+				node.Name, topologyStr)
+			return "", nil
+		}
+		topology = append(topology, int32(n))
+		product *= int32(n)
+	}
+	if product != leafCellNumber {
+		klog.Warningf("[%v]: Skipped to discover node: leaf cell topology %v mismatches "+
+			"its allocatable %v %v", node.Name, topologyStr, leafCellNumber, d.LeafCellResourceName)
+		return "", nil
+	}
+	return leafCellType, topology
+}
+
+// generateNodeCellTypes generates the cell types from the node level down to the
+// leaf level for a leaf cell type with the topology.
+func generateNodeCellTypes(
+	leafCellType si.CellType,
+	topology []int32) map[si.CellType]si.CellTypeSpec {
+	cellTypes := map[si.CellType]si.CellTypeSpec{}
+	childCellType := leafCellType
+	leafCellNumber := int32(1)
+	for i := len(topology) - 1; i > 0; i-- {
+		leafCellNumber *= topology[i]
+		cellType := si.CellType(fmt.Sprintf("%v-%v", leafCellNumber, leafCellType))
+		cellTypes[cellType] = si.CellTypeSpec{
+			ChildCellType:   childCellType,
+			ChildCellNumber: topology[i],
+		}
+		childCellType = cellType
+	}
+	cellTypes[nodeCellType(leafCellType)] = si.CellTypeSpec{
+		ChildCellType:   childCellType,
+		ChildCellNumber: topology[0],
+		IsNodeLevel:     true,
+	}
+	return cellTypes
+}
+
+// mergeNodeTopology returns the child cell numbers from the node level down to the
+// leaf level, after the cell types generated for a leaf cell type with the topology
+// are overridden by the explicit ones.
+// Return nil if the merged cell types cannot reach the leaf cell type.
+func mergeNodeTopology(
+	explicit map[si.CellType]si.CellTypeSpec,
+	leafCellType si.CellType,
+	topology []int32) []int32 {
+	generated := generateNodeCellTypes(leafCellType, topology)
+	merged := []int32{}
+	for cellType := nodeCellType(leafCellType); cellType != leafCellType; {
+		ctSpec, ok := explicit[cellType]
+		if !ok {
+			ctSpec, ok = generated[cellType]
+		}
+		if !ok || len(merged) == len(topology) {
+			return nil
+		}
+		merged = append(merged, ctSpec.ChildCellNumber)
+		cellType = ctSpec.ChildCellType
+	}
+	return merged
+}
+
+func collectPhysicalCellNodes(
+	pc si.PhysicalCellSpec,
+	cts map[si.CellType]si.CellTypeSpec,
+	nodes map[string]bool) {
+	if ct, ok := cts[pc.CellType]; ok && ct.IsNodeLevel {
+		addressParts := strings.Split(string(pc.CellAddress), "/")
+		nodes[addressParts[len(addressParts)-1]] = true
+		return
+	}
+	for _, child := range pc.CellChildren {
+		collectPhysicalCellNodes(child, cts, nodes)
+	}
+}
+
+func nodeCellType(leafCellType si.CellType) si.CellType {
+	return si.CellType(fmt.Sprintf("%v-NODE", leafCellType))
+}
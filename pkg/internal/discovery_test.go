@@ -0,0 +1,164 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var testDiscoverySpec = &si.PhysicalClusterDiscoverySpec{
+	LeafCellTypeLabelKey:     si.LabelKeyLeafCellType,
+	LeafCellResourceName:     si.ResourceNameNvidiaGpu,
+	LeafCellTopologyLabelKey: si.LabelKeyLeafCellTopology,
+	RackLabelKey:             si.LabelKeyRack,
+}
+
+func newTestDiscoveryNode(name, leafCellType, topology, rack string, leafCellNumber int64) *core.Node {
+	node := &core.Node{
+		ObjectMeta: meta.ObjectMeta{Name: name, Labels: map[string]string{}},
+		Status:     core.NodeStatus{Allocatable: core.ResourceList{}},
+	}
+	if leafCellType != "" {
+		node.Labels[si.LabelKeyLeafCellType] = leafCellType
+	}
+	if topology != "" {
+		node.Labels[si.LabelKeyLeafCellTopology] = topology
+	}
+	if rack != "" {
+		node.Labels[si.LabelKeyRack] = rack
+	}
+	if leafCellNumber > 0 {
+		node.Status.Allocatable[si.ResourceNameNvidiaGpu] = *resource.NewQuantity(leafCellNumber, resource.DecimalSI)
+	}
+	return node
+}
+
+// summarizePhysicalCells returns each top level cell as "{type}@{address}: {nodes}".
+func summarizePhysicalCells(pc *si.PhysicalClusterSpec) []string {
+	summary := []string{}
+	for _, c := range pc.PhysicalCells {
+		nodes := map[string]bool{}
+		collectPhysicalCellNodes(c, pc.CellTypes, nodes)
+		summary = append(summary, fmt.Sprintf("%v@%v: %v", c.CellType, c.CellAddress, len(nodes)))
+	}
+	return summary
+}
+
+func TestDiscoverPhysicalCluster(t *testing.T) {
+	explicit := &si.PhysicalClusterSpec{
+		CellTypes: map[si.CellType]si.CellTypeSpec{
+			"K80-NODE": {ChildCellType: "K80", ChildCellNumber: 8, IsNodeLevel: true},
+		},
+		PhysicalCells: []si.PhysicalCellSpec{{CellType: "K80-NODE", CellAddress: "explicit-node"}},
+	}
+	si.DefaultingPhysicalCells(explicit)
+
+	for _, c := range []struct {
+		name              string
+		explicit          *si.PhysicalClusterSpec
+		nodes             []*core.Node
+		expectedCellTypes map[si.CellType]si.CellTypeSpec
+		expectedCells     []string
+	}{
+		{
+			name: "topology label",
+			nodes: []*core.Node{
+				newTestDiscoveryNode("node1", "V100", "2, 4", "", 8),
+				newTestDiscoveryNode("node2", "K80", "", "", 4),
+			},
+			expectedCellTypes: map[si.CellType]si.CellTypeSpec{
+				"4-V100":    {ChildCellType: "V100", ChildCellNumber: 4},
+				"V100-NODE": {ChildCellType: "4-V100", ChildCellNumber: 2, IsNodeLevel: true},
+				"K80-NODE":  {ChildCellType: "K80", ChildCellNumber: 4, IsNodeLevel: true},
+			},
+			expectedCells: []string{"V100-NODE@node1: 1", "K80-NODE@node2: 1"},
+		},
+		{
+			name: "rack grouping",
+			nodes: []*core.Node{
+				newTestDiscoveryNode("node1", "K80", "", "rack1", 4),
+				newTestDiscoveryNode("node2", "K80", "", "rack1", 4),
+				newTestDiscoveryNode("node3", "K80", "", "rack2", 4),
+				newTestDiscoveryNode("node4", "V100", "", "rack2", 4),
+			},
+			expectedCellTypes: map[si.CellType]si.CellTypeSpec{
+				"K80-NODE":    {ChildCellType: "K80", ChildCellNumber: 4, IsNodeLevel: true},
+				"V100-NODE":   {ChildCellType: "V100", ChildCellNumber: 4, IsNodeLevel: true},
+				"2-K80-NODE":  {ChildCellType: "K80-NODE", ChildCellNumber: 2},
+				"1-K80-NODE":  {ChildCellType: "K80-NODE", ChildCellNumber: 1},
+				"1-V100-NODE": {ChildCellType: "V100-NODE", ChildCellNumber: 1},
+			},
+			expectedCells: []string{
+				"2-K80-NODE@rack1: 2", "1-K80-NODE@rack2-K80: 1", "1-V100-NODE@rack2-V100: 1"},
+		},
+		{
+			name:     "explicit overrides",
+			explicit: explicit,
+			nodes: []*core.Node{
+				newTestDiscoveryNode("explicit-node", "K80", "", "", 4),
+				newTestDiscoveryNode("node1", "K80", "", "", 8),
+				newTestDiscoveryNode("node0-mismatched-explicit", "K80", "", "", 4),
+				newTestDiscoveryNode("node3-mismatched-explicit", "K80", "2,4", "", 8),
+			},
+			expectedCellTypes: map[si.CellType]si.CellTypeSpec{
+				"K80-NODE": {ChildCellType: "K80", ChildCellNumber: 8, IsNodeLevel: true},
+			},
+			expectedCells: []string{"K80-NODE@explicit-node: 1", "K80-NODE@node1: 1"},
+		},
+		{
+			name: "skipped nodes",
+			nodes: []*core.Node{
+				newTestDiscoveryNode("node1", "V100", "2,4", "", 8),
+				newTestDiscoveryNode("no-type", "", "", "", 8),
+				newTestDiscoveryNode("no-resource", "V100", "", "", 0),
+				newTestDiscoveryNode("invalid-topology", "V100", "2,x", "", 8),
+				newTestDiscoveryNode("mismatched-resource", "V100", "2,2", "", 8),
+				newTestDiscoveryNode("node2-mismatched-topology", "V100", "8", "", 8),
+			},
+			expectedCellTypes: map[si.CellType]si.CellTypeSpec{
+				"4-V100":    {ChildCellType: "V100", ChildCellNumber: 4},
+				"V100-NODE": {ChildCellType: "4-V100", ChildCellNumber: 2, IsNodeLevel: true},
+			},
+			expectedCells: []string{"V100-NODE@node1: 1"},
+		},
+	} {
+		e := c.explicit
+		if e == nil {
+			e = &si.PhysicalClusterSpec{CellTypes: map[si.CellType]si.CellTypeSpec{}}
+		}
+		pc := DiscoverPhysicalCluster(testDiscoverySpec, e, c.nodes)
+		if !reflect.DeepEqual(pc.CellTypes, c.expectedCellTypes) {
+			t.Errorf("[%v]: Expected cell types %v, but got %v", c.name, c.expectedCellTypes, pc.CellTypes)
+		}
+		if cells := summarizePhysicalCells(pc); !reflect.DeepEqual(cells, c.expectedCells) {
+			t.Errorf("[%v]: Expected physical cells %v, but got %v", c.name, c.expectedCells, cells)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubeClient "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+const (
+	// The max number of records waiting to be written to ApiServer.
+	eventQueueSize = 1000
+	// The max length of an Event message accepted by ApiServer.
+	eventMessageMaxLength = 1024
+)
+
+// EventRecorder records K8S Events and Pod conditions to expose the scheduling
+// decisions to users, such as by kubectl describe.
+//
+// The records are written asynchronously, so that the scheduling will never be
+// blocked by ApiServer. And the recording is best effort, i.e. a record is just
+// dropped if the queue is full or it is failed to be written.
+type EventRecorder struct {
+	kClient kubeClient.Interface
+	queue   chan func()
+}
+
+func NewEventRecorder(kClient kubeClient.Interface) *EventRecorder {
+	return &EventRecorder{
+		kClient: kClient,
+		queue:   make(chan func(), eventQueueSize),
+	}
+}
+
+func (r *EventRecorder) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case write := <-r.queue:
+			write()
+		}
+	}
+}
+
+// PodEventf records an Event on the Pod.
+func (r *EventRecorder) PodEventf(
+	pod *core.Pod, eventType, reason, messageFmt string, args ...interface{}) {
+	r.eventf(pod.Namespace, &core.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        pod.UID,
+	}, eventType, reason, messageFmt, args...)
+}
+
+// NodeEventf records an Event on the Node.
+func (r *EventRecorder) NodeEventf(
+	nodeName, eventType, reason, messageFmt string, args ...interface{}) {
+	// Same as the kubelet, the Node Events are in the default namespace and
+	// referred by the Node name.
+	r.eventf(meta.NamespaceDefault, &core.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Node",
+		Name:       nodeName,
+		UID:        types.UID(nodeName),
+	}, eventType, reason, messageFmt, args...)
+}
+
+func (r *EventRecorder) eventf(
+	namespace string, ref *core.ObjectReference,
+	eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	if len(message) > eventMessageMaxLength {
+		message = message[:eventMessageMaxLength-3] + "..."
+	}
+	now := meta.NewTime(time.Now())
+	event := &core.Event{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace: namespace,
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+		},
+		InvolvedObject: *ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         core.EventSource{Component: si.ComponentName},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	r.enqueue(fmt.Sprintf("%v %v/%v", ref.Kind, ref.Namespace, ref.Name), func() error {
+		_, err := r.kClient.CoreV1().Events(namespace).Create(event)
+		return err
+	})
+}
+
+// SetPodStateCondition sets the condition si.PodConditionTypePodState of the
+// Pod to expose its current PodState.
+func (r *EventRecorder) SetPodStateCondition(pod *core.Pod, state PodState, message string) {
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []core.PodCondition{{
+				Type:               si.PodConditionTypePodState,
+				Status:             core.ConditionTrue,
+				LastTransitionTime: meta.Now(),
+				Reason:             string(state),
+				Message:            message,
+			}},
+		},
+	})
+	if err != nil {
+		klog.Warningf("[%v]: Failed to marshal PodState condition: %v", Key(pod), err)
+		return
+	}
+
+	r.enqueue(Key(pod), func() error {
+		// The conditions are merged by type, so other conditions are kept.
+		_, err := r.kClient.CoreV1().Pods(pod.Namespace).Patch(
+			pod.Name, types.StrategicMergePatchType, patch, "status")
+		return err
+	})
+}
+
+func (r *EventRecorder) enqueue(key string, write func() error) {
+	select {
+	case r.queue <- func() {
+		if err := write(); err != nil {
+			klog.Warningf("[%v]: Failed to record to ApiServer: %v", key, err)
+		}
+	}:
+	default:
+		klog.Warningf("[%v]: Dropped the record since the EventRecorder queue is full", key)
+	}
+}
@@ -0,0 +1,241 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package internal
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+)
+
+// CellStatusFilter selects the cells to inspect in the cluster status, so that
+// the clients of a large cluster need not get the whole status.
+// Notes:
+// 1. A cell is kept if it matches all the conditions, or any of its children is
+//    kept, so that the kept cells are still organized as trees.
+// 2. The Node condition is matched at the node level, i.e. a cell matches it if
+//    it is or is inside the node level cell of the node, and a virtual cell is
+//    in the node its bound physical cell is in.
+type CellStatusFilter struct {
+	// Empty means no condition
+	VC          si.VirtualClusterName
+	Chain       string
+	Node        string
+	State       si.CellState
+	Healthiness si.CellHealthiness
+	// Max depth of the cell trees, e.g. 1 means only the top level cells.
+	// 0 means unlimited.
+	Depth int
+	// Whether to keep the bound cell of the other side, i.e. the VirtualCell of
+	// a PhysicalCellStatus, and the PhysicalCell of a VirtualCellStatus.
+	CrossLinks bool
+}
+
+// FilterClusterStatus filters the ClusterStatus in place and returns it.
+func (f CellStatusFilter) FilterClusterStatus(cs si.ClusterStatus) si.ClusterStatus {
+	cs.PhysicalCluster = f.FilterPhysicalClusterStatus(cs.PhysicalCluster)
+	cs.VirtualClusters = f.FilterVirtualClustersStatus(cs.VirtualClusters)
+	return cs
+}
+
+// FilterPhysicalClusterStatus filters the PhysicalClusterStatus in place and
+// returns it.
+func (f CellStatusFilter) FilterPhysicalClusterStatus(
+	pcs si.PhysicalClusterStatus) si.PhysicalClusterStatus {
+	filtered := si.PhysicalClusterStatus{}
+	for _, c := range pcs {
+		if (f.Chain == "" || c.CellChain == f.Chain) && f.filterPhysicalCell(c, 1, false) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// FilterVirtualClustersStatus filters the status of the VCs in place and
+// returns it.
+func (f CellStatusFilter) FilterVirtualClustersStatus(
+	allVcs map[si.VirtualClusterName]si.VirtualClusterStatus) map[si.VirtualClusterName]si.VirtualClusterStatus {
+	filtered := map[si.VirtualClusterName]si.VirtualClusterStatus{}
+	for vcn, vcs := range allVcs {
+		if f.VC == "" || vcn == f.VC {
+			filtered[vcn] = f.FilterVirtualClusterStatus(vcs)
+		}
+	}
+	return filtered
+}
+
+// FilterVirtualClusterStatus filters the status of a VC in place and returns it.
+// The VC condition is ignored, since the VC is already selected.
+func (f CellStatusFilter) FilterVirtualClusterStatus(vcs si.VirtualClusterStatus) si.VirtualClusterStatus {
+	filtered := si.VirtualClusterStatus{}
+	for _, c := range vcs {
+		if (f.Chain == "" || c.CellChain == f.Chain) && f.filterVirtualCell(c, 1, false) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+// filterPhysicalCell filters the children of the cell recursively, and returns
+// whether the cell should be kept. inNode tells whether the cell is inside the
+// node level cell of the Node condition.
+func (f CellStatusFilter) filterPhysicalCell(c *si.PhysicalCellStatus, depth int, inNode bool) bool {
+	if f.Node != "" && c.IsNodeLevel {
+		inNode = isNodeCellAddress(c.CellAddress, f.Node)
+	}
+	children := c.CellChildren
+	c.CellChildren = nil
+	childKept := false
+	for _, child := range children {
+		if f.filterPhysicalCell(child, depth+1, inNode) {
+			childKept = true
+			if f.Depth == 0 || depth < f.Depth {
+				c.CellChildren = append(c.CellChildren, child)
+			}
+		}
+	}
+	if !f.CrossLinks {
+		c.VirtualCell = nil
+	}
+	return childKept || (f.matchCell(c.CellStatus, inNode) && (f.VC == "" || c.VC == f.VC))
+}
+
+// filterVirtualCell is the same as filterPhysicalCell, but for a virtual cell.
+func (f CellStatusFilter) filterVirtualCell(c *si.VirtualCellStatus, depth int, inNode bool) bool {
+	if f.Node != "" && c.IsNodeLevel {
+		inNode = c.PhysicalCell != nil && isNodeCellAddress(c.PhysicalCell.CellAddress, f.Node)
+	}
+	children := c.CellChildren
+	c.CellChildren = nil
+	childKept := false
+	for _, child := range children {
+		if f.filterVirtualCell(child, depth+1, inNode) {
+			childKept = true
+			if f.Depth == 0 || depth < f.Depth {
+				c.CellChildren = append(c.CellChildren, child)
+			}
+		}
+	}
+	if !f.CrossLinks {
+		c.PhysicalCell = nil
+	}
+	return childKept || f.matchCell(c.CellStatus, inNode)
+}
+
+func (f CellStatusFilter) matchCell(c si.CellStatus, inNode bool) bool {
+	return (f.Node == "" || inNode) &&
+		(f.State == "" || c.CellState == f.State) &&
+		(f.Healthiness == "" || c.CellHealthiness == f.Healthiness)
+}
+
+// The address of a node level physical cell ends with its node name, and may be
+// prefixed by the addresses of its ancestors.
+func isNodeCellAddress(address si.CellAddress, node string) bool {
+	return string(address) == node || strings.HasSuffix(string(address), "/"+node)
+}
+
+// FindPhysicalCellNodes finds the physical cell by its address, or the node level
+// cell by the node name, in the PhysicalClusterStatus, and returns its address
+// and the nodes it is in. The address is empty if the cell does not exist.
+func FindPhysicalCellNodes(
+	pcs si.PhysicalClusterStatus, address si.CellAddress) (si.CellAddress, []string) {
+	for _, c := range pcs {
+		if found, nodes := findPhysicalCellNodes(c, address, ""); found != "" {
+			return found, nodes
+		}
+	}
+	return "", nil
+}
+
+// findPhysicalCellNodes is the same as FindPhysicalCellNodes, but in the cell
+// tree. node is the node the cell is in, if the cell is inside a node.
+func findPhysicalCellNodes(
+	c *si.PhysicalCellStatus, address si.CellAddress, node string) (si.CellAddress, []string) {
+	if c.IsNodeLevel {
+		node = nodeOfCellAddress(c.CellAddress)
+	}
+	if c.CellAddress == address || (c.IsNodeLevel && node == string(address)) {
+		return c.CellAddress, collectPhysicalCellStatusNodes(c, node)
+	}
+	for _, child := range c.CellChildren {
+		if found, nodes := findPhysicalCellNodes(child, address, node); found != "" {
+			return found, nodes
+		}
+	}
+	return "", nil
+}
+
+func collectPhysicalCellStatusNodes(c *si.PhysicalCellStatus, node string) []string {
+	if node != "" {
+		return []string{node}
+	}
+	nodes := []string{}
+	for _, child := range c.CellChildren {
+		if child.IsNodeLevel {
+			nodes = append(nodes, nodeOfCellAddress(child.CellAddress))
+		} else {
+			nodes = append(nodes, collectPhysicalCellStatusNodes(child, "")...)
+		}
+	}
+	return nodes
+}
+
+// nodeOfCellAddress returns the node name of a node level physical cell, see
+// isNodeCellAddress.
+func nodeOfCellAddress(address si.CellAddress) string {
+	return string(address)[strings.LastIndex(string(address), "/")+1:]
+}
+
+// PaginateAffinityGroups returns at most limit (0 means unlimited) affinity
+// groups after the continueToken in the order of their names, together with the
+// continue token for the next page if there are more.
+// The continue token is opaque, and it keeps valid even if the affinity groups
+// are changed between the pages.
+func PaginateAffinityGroups(
+	ags si.AffinityGroupList, limit int, continueToken string) si.AffinityGroupList {
+	after := ""
+	if continueToken != "" {
+		name, err := base64.RawURLEncoding.DecodeString(continueToken)
+		if err != nil {
+			panic(NewBadRequestError(fmt.Sprintf(
+				"Invalid continue token %v: %v", continueToken, err)))
+		}
+		after = string(name)
+	}
+
+	sort.Slice(ags.Items, func(i, j int) bool {
+		return ags.Items[i].Name < ags.Items[j].Name
+	})
+	start := sort.Search(len(ags.Items), func(i int) bool {
+		return ags.Items[i].Name > after
+	})
+	page := si.AffinityGroupList{Items: ags.Items[start:]}
+	if limit > 0 && len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.Continue = base64.RawURLEncoding.EncodeToString([]byte(page.Items[limit-1].Name))
+	}
+	return page
+}
@@ -0,0 +1,161 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Prometheus metrics are exposed in its text-based exposition format, see
+// https://prometheus.io/docs/instrumenting/exposition_formats.
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// The default latency buckets in seconds, which are the same as the Prometheus
+// client default buckets.
+var DefaultLatencyBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type MetricLabel struct {
+	Name  string
+	Value string
+}
+
+// MetricsWriter writes the metrics in the Prometheus text-based exposition
+// format.
+// All the samples of a metric should be written right after its header.
+type MetricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (mw *MetricsWriter) WriteHeader(name string, metricType string, help string) {
+	fmt.Fprintf(&mw.buf, "# HELP %v %v\n", name, escapeMetricHelp(help))
+	fmt.Fprintf(&mw.buf, "# TYPE %v %v\n", name, metricType)
+}
+
+func (mw *MetricsWriter) WriteSample(name string, labels []MetricLabel, value float64) {
+	mw.buf.WriteString(name)
+	if len(labels) > 0 {
+		mw.buf.WriteString("{")
+		for i, label := range labels {
+			if i > 0 {
+				mw.buf.WriteString(",")
+			}
+			fmt.Fprintf(&mw.buf, "%v=\"%v\"", label.Name, escapeMetricLabelValue(label.Value))
+		}
+		mw.buf.WriteString("}")
+	}
+	mw.buf.WriteString(" ")
+	mw.buf.WriteString(formatMetricValue(value))
+	mw.buf.WriteString("\n")
+}
+
+// Write a metric with a single sample.
+func (mw *MetricsWriter) WriteMetric(
+	name string, metricType string, help string, value float64) {
+	mw.WriteHeader(name, metricType, help)
+	mw.WriteSample(name, nil, value)
+}
+
+func (mw *MetricsWriter) Bytes() []byte {
+	return mw.buf.Bytes()
+}
+
+func escapeMetricHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeMetricLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// Histogram is a Prometheus histogram which is safe for concurrent use.
+type Histogram struct {
+	lock sync.Mutex
+	// Sorted upper bounds of the buckets, excluding the +Inf one.
+	buckets []float64
+	// Non-cumulative count of each bucket, including the +Inf one.
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(buckets []float64) *Histogram {
+	sortedBuckets := append([]float64{}, buckets...)
+	sort.Float64s(sortedBuckets)
+	return &Histogram{
+		buckets: sortedBuckets,
+		counts:  make([]uint64, len(sortedBuckets)+1),
+	}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.counts[sort.SearchFloat64s(h.buckets, value)]++
+	h.sum += value
+	h.count++
+}
+
+// Observe the elapsed seconds since the start time, such as:
+// defer h.ObserveSince(time.Now())
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) Write(mw *MetricsWriter, name string, help string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	mw.WriteHeader(name, "histogram", help)
+	cumulativeCount := uint64(0)
+	for i, bucket := range h.buckets {
+		cumulativeCount += h.counts[i]
+		mw.WriteSample(name+"_bucket",
+			[]MetricLabel{{"le", formatMetricValue(bucket)}}, float64(cumulativeCount))
+	}
+	mw.WriteSample(name+"_bucket",
+		[]MetricLabel{{"le", "+Inf"}}, float64(h.count))
+	mw.WriteSample(name+"_sum", nil, h.sum)
+	mw.WriteSample(name+"_count", nil, float64(h.count))
+}
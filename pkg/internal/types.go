@@ -28,6 +28,7 @@ import (
 	core "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ei "k8s.io/kubernetes/pkg/scheduler/api"
+	"time"
 )
 
 ///////////////////////////////////////////////////////////////////////////////////////
@@ -48,10 +49,33 @@ type ExtenderHandlers struct {
 type InspectHandlers struct {
 	GetAllAffinityGroupsHandler        func() si.AffinityGroupList
 	GetAffinityGroupHandler            func(groupName string) si.AffinityGroup
+	ExplainAffinityGroupHandler        func(groupName string) si.AffinityGroupExplanation
+	GetAllPodScheduleStatusesHandler   func(namespace string, state PodState) si.PodScheduleStatusList
+	GetPodScheduleStatusHandler        func(uid types.UID) si.PodScheduleStatus
 	GetClusterStatusHandler            func() si.ClusterStatus
 	GetPhysicalClusterStatusHandler    func() si.PhysicalClusterStatus
 	GetAllVirtualClustersStatusHandler func() map[si.VirtualClusterName]si.VirtualClusterStatus
 	GetVirtualClusterStatusHandler     func(vcName si.VirtualClusterName) si.VirtualClusterStatus
+	GetClusterSummaryHandler           func() si.ClusterSummary
+	GetPhysicalClusterSpecHandler      func() si.PhysicalClusterSpec
+	GetLeaderElectionStatusHandler     func() si.LeaderElectionStatus
+	GetMetricsHandler                  func() []byte
+	DryRunHandler                      func(spec string) si.DryRunResult
+	WatchHandler                       func(resourceVersion uint64) (
+		events []si.WatchEvent, latestResourceVersion uint64, newer <-chan struct{})
+	GetUsageReportHandler func(startTime, endTime time.Time) si.UsageReport
+}
+
+type AdminHandlers struct {
+	SetCellDrainingHandler               func(address si.CellAddress, draining bool)
+	SetCellBadHandler                    func(address si.CellAddress, bad bool)
+	CancelAffinityGroupPreemptionHandler func(groupName string)
+	SetAffinityGroupLazyPreemptedHandler func(groupName string, lazyPreempted bool)
+}
+
+type AdmissionHandlers struct {
+	ValidatePodHandler func(pod *core.Pod)
+	MutatePodHandler   func(pod *core.Pod) []si.JsonPatchOperation
 }
 
 // SchedulerAlgorithm is used to make the pod schedule decision based on its whole
@@ -97,6 +121,199 @@ type SchedulerAlgorithm interface {
 	GetPhysicalClusterStatus() si.PhysicalClusterStatus
 	GetAllVirtualClustersStatus() map[si.VirtualClusterName]si.VirtualClusterStatus
 	GetVirtualClusterStatus(si.VirtualClusterName) si.VirtualClusterStatus
+
+	// Change current scheduling status
+	// Drain or undrain a physical cell, see PhysicalCellStatus.CellDraining.
+	// The node-level cell can also be referred by the node name.
+	SetCellDraining(address si.CellAddress, draining bool)
+}
+
+// TypedErrorSchedulerAlgorithm is the variant of SchedulerAlgorithm which returns
+// the schedule error instead of panic, so that the algorithm can be easily
+// embedded in other tools, such as simulators and planners.
+// Notes:
+// 1. The returned error is always a *si.WebServerError, whose Reason tells the
+//    error category, such as si.ErrorReasonInvalidSpec.
+// 2. The pod which has to wait is not an error, i.e. the PodWaitInfo is still
+//    returned by PodScheduleResult, whose ErrorReason tells why it waits, such
+//    as si.ErrorReasonCapacityExhausted.
+// 3. The error with si.ErrorReasonInternalInvariant means the algorithm is
+//    broken, so its state should not be trusted anymore.
+type TypedErrorSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	TrySchedule(pod *core.Pod, suggestedNodes []string, phase SchedulingPhase) (PodScheduleResult, error)
+}
+
+// ValidatingSchedulerAlgorithm is the variant of SchedulerAlgorithm which can
+// validate the pod against its current cluster scheduling view, so that a bad
+// pod can be rejected at its creation time, instead of failing in Schedule.
+// Notes:
+// 1. The ValidatePod should not change any state, and the error is delivered by
+//    panic in the same way as Schedule.
+type ValidatingSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	ValidatePod(pod *core.Pod)
+}
+
+// ExplainingSchedulerAlgorithm is the variant of SchedulerAlgorithm which keeps
+// the decision traces of the most recent scheduling attempts of each affinity
+// group, so that it can explain why the group is scheduled, waiting or
+// preempting.
+// Notes:
+// 1. The traces of an affinity group are dropped once it is deleted, i.e. all
+//    its Pods are deleted.
+type ExplainingSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	ExplainAffinityGroup(name string) si.AffinityGroupExplanation
+}
+
+// SummarizingSchedulerAlgorithm is the variant of SchedulerAlgorithm which
+// summarizes the leaf cell usages of the physical cluster and each VC, so that
+// the clients need not walk the whole ClusterStatus.
+type SummarizingSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	GetClusterSummary() si.ClusterSummary
+}
+
+// WatchingSchedulerAlgorithm is the variant of SchedulerAlgorithm which records
+// the incremental changes of the cells and affinity groups as WatchEvents, so
+// that the clients can watch them instead of polling the whole status.
+// Notes:
+// 1. Only the most recent WatchEvents are kept, so Watch from a too old
+//    resourceVersion fails with http.StatusGone, and then the client should get
+//    the current status and Watch from now again.
+// 2. The resourceVersions keep increasing across the replaced SchedulerAlgorithms,
+//    and the ones got from a replaced SchedulerAlgorithm will also fail with
+//    http.StatusGone.
+type WatchingSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	// Watch returns the WatchEvents after the resourceVersion (0 means from now),
+	// the latest resourceVersion, and a channel which will be closed once there
+	// are WatchEvents newer than the latest resourceVersion.
+	Watch(resourceVersion uint64) (
+		events []si.WatchEvent, latestResourceVersion uint64, newer <-chan struct{})
+	// StartWatchFrom drops the WatchEvents so far, and lets the resourceVersions
+	// start after the resourceVersion.
+	StartWatchFrom(resourceVersion uint64)
+}
+
+// AdministratingSchedulerAlgorithm is the variant of SchedulerAlgorithm which
+// allows the operators to correct its current scheduling status by hand.
+// Notes:
+// 1. Same as SetCellDraining, the physical cell can be referred by its address
+//    or by the node name for the node-level cell.
+// 2. The change should be rejected by panic without changing any state, if it
+//    would violate the invariants of the algorithm, such as the VC safety.
+type AdministratingSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	// Mark or unmark a physical cell as bad, regardless of its node healthiness.
+	SetCellBad(address si.CellAddress, bad bool)
+	// Revoke the preemption of a preempting affinity group, so that its victims
+	// will not be preempted anymore. Its Pods will be scheduled again.
+	CancelAffinityGroupPreemption(name string)
+	// Lazy preempt an allocated affinity group from its VC, or revert it.
+	SetAffinityGroupLazyPreempted(name string, lazyPreempted bool)
+}
+
+// UsageRecordingSchedulerAlgorithm is the variant of SchedulerAlgorithm which
+// records the usage changes of the affinity groups as UsageRecords, so that
+// they can be appended to the UsageLedger.
+// Notes:
+// 1. The UsageRecords are only recorded after TakeUsageRecords is called for
+//    the first time, and at most a bounded number of them are kept until they
+//    are taken, in which case they are dropped and a Resynced UsageRecord is
+//    taken next time instead.
+type UsageRecordingSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	// TakeUsageRecords returns the UsageRecords since the last call, or a single
+	// Resynced UsageRecord of all the affinity groups using leaf cells if resync.
+	TakeUsageRecords(resync bool) []si.UsageRecord
+}
+
+// UsageLedger is the append-only store of the UsageRecords, such as a local
+// file, see UsageLedgerFile.
+// Notes:
+// 1. Error should be delivered by panic, and the UsageRecords failed to be
+//    appended will be appended again.
+// 2. The UsageRecords should be listed in the order they are appended.
+// 3. It should be shared by all the replicas, since the new leader continues to
+//    append to it once the leadership changes.
+type UsageLedger interface {
+	Append(records []si.UsageRecord)
+	// List the UsageRecords whose Time is not after the endTime, to aggregate
+	// the usage over the time range [startTime, endTime].
+	// The ones before the latest Resynced UsageRecord not after the startTime
+	// can be skipped, since they do not affect the usage within the time range.
+	List(startTime time.Time, endTime time.Time) []si.UsageRecord
+}
+
+// StoppableSchedulerAlgorithm is the variant of SchedulerAlgorithm which has
+// background routines (such as timers) changing its scheduling view, so that
+// they should be stopped once the SchedulerAlgorithm is replaced.
+type StoppableSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	Stop()
+}
+
+// InheritingSchedulerAlgorithm is the variant of SchedulerAlgorithm which has
+// the status that cannot be recovered from the Nodes and Pods, such as the
+// timers and the scheduling history, so that the status should be inherited
+// once the SchedulerAlgorithm is replaced.
+type InheritingSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	// Inherit inherits the status from the replaced SchedulerAlgorithm, after
+	// the Nodes and Pods are recovered.
+	Inherit(replaced SchedulerAlgorithm)
+}
+
+// MetricsSchedulerAlgorithm is the variant of SchedulerAlgorithm which exposes
+// the metrics of its current cluster scheduling view for monitoring.
+type MetricsSchedulerAlgorithm interface {
+	SchedulerAlgorithm
+
+	GetMetrics() AlgorithmMetrics
+}
+
+// The snapshot of the metrics of a MetricsSchedulerAlgorithm.
+type AlgorithmMetrics struct {
+	// The preassigned cell numbers of each VC, chain and cell type.
+	VirtualClusterCells []VirtualClusterCellMetrics
+	// The used leaf cell numbers of each chain and priority in the physical
+	// cluster.
+	UsedLeafCells []UsedLeafCellMetrics
+	// The affinity group numbers of each state.
+	AffinityGroups map[si.AffinityGroupState]int32
+	// The total numbers of the preemptions and (committed) lazy preemptions of
+	// the affinity groups since the algorithm started, which never decrease.
+	PreemptionCount     uint64
+	LazyPreemptionCount uint64
+}
+
+type VirtualClusterCellMetrics struct {
+	VirtualCluster si.VirtualClusterName
+	Chain          string
+	CellType       si.CellType
+	// The cells which are not bound to physical cells.
+	Free int32
+	// The cells which are bound to physical cells.
+	Used int32
+	// The free cells which are doomed to be bound to bad physical cells.
+	DoomedBad int32
+}
+
+type UsedLeafCellMetrics struct {
+	Chain    string
+	Priority int32
+	Number   int32
 }
 
 type SchedulingPhase string
@@ -133,6 +350,10 @@ type PodScheduleResult struct {
 	PodWaitInfo    *PodWaitInfo
 	PodPreemptInfo *PodPreemptInfo
 	PodBindInfo    *si.PodBindInfo
+	// The allocated Pods whose affinity groups are lazy preempted by the scheduling,
+	// i.e. they keep running but are downgraded to opportunistic.
+	// It can be set together with any one of the above.
+	LazyPreemptedPods []*core.Pod
 }
 
 // PodUID -> PodScheduleStatus
@@ -149,6 +370,9 @@ type PodScheduleStatus struct {
 	// in PodBinding state.
 	PodBindAttempts   int32
 	PodScheduleResult *PodScheduleResult
+	// The last authorization denial message of the Pod, so that the same denial
+	// is only recorded once.
+	UnauthorizedMessage string
 }
 
 type PodState string
@@ -201,6 +425,9 @@ func IsAllocated(state PodState) bool {
 type PodWaitInfo struct {
 	// The reason why no preemptible or free resource to allocate the Pod now.
 	Reason string
+	// The category of the Reason, such as si.ErrorReasonCapacityExhausted.
+	// si.ErrorReasonCapacityExhausted is assumed if it is empty.
+	ErrorReason si.ErrorReason
 }
 
 // No need to use it recover scheduler preempting resource
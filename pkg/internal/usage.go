@@ -0,0 +1,451 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/common"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// The max size of a line in the usage ledger file, i.e. a Resynced UsageRecord
+// of all the affinity groups.
+const maxUsageLedgerLineSize = 64 * 1024 * 1024
+
+// The start time suffix of the usage ledger segment files, which sorts in the
+// same order as the time.
+const usageLedgerSegmentTimeFormat = "20060102T150405Z"
+
+// FileUsageLedger is the UsageLedger which appends the UsageRecords to local
+// files as JSON lines.
+// Notes:
+// 1. The records are split into the segment files by day (UTC), i.e.
+//    {filePath}.{start time}, and each segment except the first one starts with
+//    a Resynced UsageRecord of the affinity groups using leaf cells at its start
+//    time, so that only the segments overlapping the time range are listed.
+// 2. A Resynced UsageRecord which is the same as the affinity groups using leaf
+//    cells in the ledger is not appended, such as the one taken after the
+//    leadership changes, so the ledger does not grow by the resyncs.
+// 3. A line which cannot be parsed, such as the one partially written when the
+//    scheduler crashed, is skipped when listing.
+// 4. The directory of the filePath should exist, such as a persistent volume
+//    shared by all the replicas, so that the new leader continues the ledger.
+type FileUsageLedger struct {
+	filePath string
+}
+
+type usageLedgerSegment struct {
+	filePath  string
+	startTime time.Time
+}
+
+func NewFileUsageLedger(filePath string) *FileUsageLedger {
+	dir := filepath.Dir(filePath)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		panic(fmt.Errorf(
+			"Usage ledger directory %v does not exist, it should be a persistent "+
+				"volume shared by all the replicas: %v", dir, err))
+	}
+	return &FileUsageLedger{filePath: filePath}
+}
+
+func (l *FileUsageLedger) Append(records []si.UsageRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	segments := l.listSegments()
+	var segment usageLedgerSegment
+	if len(segments) == 0 {
+		segment = l.newSegment(records[0].Time.Time)
+	} else if last := segments[len(segments)-1]; !isSameUTCDay(last.startTime, records[0].Time.Time) {
+		segment = l.newSegment(records[0].Time.Time)
+		if records[0].Type != si.UsageRecordResynced {
+			records = append([]si.UsageRecord{{
+				Time:           records[0].Time,
+				Type:           si.UsageRecordResynced,
+				AffinityGroups: openUsageAffinityGroups(l.readSegment(last)),
+			}}, records...)
+		}
+	} else {
+		segment = last
+		if records[0].Type == si.UsageRecordResynced && isSameUsageAffinityGroups(
+			records[0].AffinityGroups, openUsageAffinityGroups(l.readSegment(last))) {
+			records = records[1:]
+		}
+	}
+	if len(records) == 0 {
+		return
+	}
+
+	var buf bytes.Buffer
+	for _, r := range records {
+		buf.Write(common.ToJsonBytes(r))
+		buf.WriteByte('\n')
+	}
+	f, err := os.OpenFile(segment.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		panic(fmt.Errorf("Failed to open usage ledger file %v: %v", segment.filePath, err))
+	}
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		panic(fmt.Errorf("Failed to append to usage ledger file %v: %v", segment.filePath, err))
+	}
+}
+
+// List only reads the segments from the latest one started not after the
+// startTime, until the endTime.
+func (l *FileUsageLedger) List(startTime time.Time, endTime time.Time) []si.UsageRecord {
+	records := []si.UsageRecord{}
+	segments := l.listSegments()
+	first := 0
+	for i, segment := range segments {
+		if segment.startTime.After(startTime) {
+			break
+		}
+		first = i
+	}
+	for _, segment := range segments[first:] {
+		if segment.startTime.After(endTime) {
+			break
+		}
+		for _, r := range l.readSegment(segment) {
+			if !r.Time.Time.After(endTime) {
+				records = append(records, r)
+			}
+		}
+	}
+	return records
+}
+
+func (l *FileUsageLedger) newSegment(startTime time.Time) usageLedgerSegment {
+	return usageLedgerSegment{
+		filePath:  l.filePath + "." + startTime.UTC().Format(usageLedgerSegmentTimeFormat),
+		startTime: startTime,
+	}
+}
+
+// listSegments returns the segment files of the ledger, sorted by the start time.
+func (l *FileUsageLedger) listSegments() []usageLedgerSegment {
+	segments := []usageLedgerSegment{}
+	dir, prefix := filepath.Dir(l.filePath), filepath.Base(l.filePath)+"."
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return segments
+	} else if err != nil {
+		panic(fmt.Errorf("Failed to list usage ledger directory %v: %v", dir, err))
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), prefix) {
+			continue
+		}
+		startTime, err := time.Parse(
+			usageLedgerSegmentTimeFormat, strings.TrimPrefix(file.Name(), prefix))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, usageLedgerSegment{
+			filePath:  filepath.Join(dir, file.Name()),
+			startTime: startTime,
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].startTime.Before(segments[j].startTime)
+	})
+	return segments
+}
+
+func (l *FileUsageLedger) readSegment(segment usageLedgerSegment) []si.UsageRecord {
+	records := []si.UsageRecord{}
+	f, err := os.Open(segment.filePath)
+	if os.IsNotExist(err) {
+		return records
+	} else if err != nil {
+		panic(fmt.Errorf("Failed to open usage ledger file %v: %v", segment.filePath, err))
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxUsageLedgerLineSize)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		r := si.UsageRecord{}
+		if err := json.Unmarshal(line, &r); err != nil {
+			klog.Warningf("Skipped line %v of usage ledger file %v: %v", lineNum, segment.filePath, err)
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		panic(fmt.Errorf("Failed to read usage ledger file %v: %v", segment.filePath, err))
+	}
+	return records
+}
+
+func isSameUTCDay(x time.Time, y time.Time) bool {
+	return x.UTC().Format("20060102") == y.UTC().Format("20060102")
+}
+
+func isSameUsageAffinityGroups(x []si.UsageAffinityGroup, y []si.UsageAffinityGroup) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	groups := map[string]si.UsageAffinityGroup{}
+	for _, g := range x {
+		groups[g.Name] = g
+	}
+	for _, g := range y {
+		if xg, ok := groups[g.Name]; !ok || xg != g {
+			return false
+		}
+	}
+	return true
+}
+
+type usageReportKey struct {
+	vc           si.VirtualClusterName
+	user         string
+	priority     int32
+	leafCellType string
+}
+
+// openUsage is an affinity group using leaf cells, whose usage has been accounted
+// until the time since.
+type openUsage struct {
+	group si.UsageAffinityGroup
+	since time.Time
+}
+
+type usageAggregator struct {
+	startTime time.Time
+	endTime   time.Time
+	items     map[usageReportKey]*si.UsageReportItem
+	open      map[string]*openUsage
+}
+
+// AggregateUsageRecords aggregates the leaf cell usage over the time range
+// [startTime, endTime] from the UsageRecords listed from the UsageLedger.
+// Notes:
+// 1. The records before the startTime are also needed, to know the affinity
+//    groups already using leaf cells at the startTime.
+// 2. The affinity groups still using leaf cells at the end of the records are
+//    accounted until the endTime, so the endTime should not be in the future.
+// 3. An affinity group is accounted by the VC, user, priority and leaf cell type
+//    of the record it starts to use leaf cells, and duplicated records, such as
+//    the ones appended again after a failure, are ignored.
+// 4. The affinity groups not in a Resynced record are considered released at
+//    its Time, such as the ones deleted when the scheduler was down.
+func AggregateUsageRecords(
+	records []si.UsageRecord, startTime time.Time, endTime time.Time) si.UsageReport {
+	a := &usageAggregator{
+		startTime: startTime,
+		endTime:   endTime,
+		items:     map[usageReportKey]*si.UsageReportItem{},
+		open:      map[string]*openUsage{},
+	}
+	a.replay(records)
+	for _, name := range a.openNames() {
+		a.accrue(a.open[name], endTime)
+	}
+
+	report := si.UsageReport{
+		StartTime: meta.NewTime(startTime),
+		EndTime:   meta.NewTime(endTime),
+		Items:     []si.UsageReportItem{},
+	}
+	for _, item := range a.items {
+		report.Items = append(report.Items, *item)
+	}
+	sort.Slice(report.Items, func(i, j int) bool {
+		x, y := report.Items[i], report.Items[j]
+		if x.VirtualCluster != y.VirtualCluster {
+			return x.VirtualCluster < y.VirtualCluster
+		}
+		if x.User != y.User {
+			return x.User < y.User
+		}
+		if x.Priority != y.Priority {
+			return x.Priority > y.Priority
+		}
+		return x.LeafCellType < y.LeafCellType
+	})
+	return report
+}
+
+// openUsageAffinityGroups returns the affinity groups still using leaf cells at
+// the end of the UsageRecords, sorted by name.
+func openUsageAffinityGroups(records []si.UsageRecord) []si.UsageAffinityGroup {
+	// The empty time range only tracks the open usages without accounting.
+	a := &usageAggregator{
+		items: map[usageReportKey]*si.UsageReportItem{},
+		open:  map[string]*openUsage{},
+	}
+	a.replay(records)
+	groups := []si.UsageAffinityGroup{}
+	for _, name := range a.openNames() {
+		groups = append(groups, a.open[name].group)
+	}
+	return groups
+}
+
+func (a *usageAggregator) replay(records []si.UsageRecord) {
+	for _, r := range records {
+		if r.Type == si.UsageRecordResynced {
+			a.resync(r)
+		} else if r.AffinityGroup != nil {
+			a.add(r)
+		}
+	}
+}
+
+func (a *usageAggregator) add(r si.UsageRecord) {
+	t := r.Time.Time
+	name := r.AffinityGroup.Name
+	u := a.open[name]
+	switch r.Type {
+	case si.UsageRecordAllocated:
+		if u == nil {
+			a.open[name] = &openUsage{group: *r.AffinityGroup, since: t}
+			if item := a.item(*r.AffinityGroup, t); item != nil {
+				item.Allocations++
+			}
+		}
+	case si.UsageRecordReleased:
+		if u != nil {
+			a.accrue(u, t)
+			delete(a.open, name)
+		}
+	case si.UsageRecordPreempted:
+		g := *r.AffinityGroup
+		if u != nil {
+			g = u.group
+		}
+		if item := a.item(g, t); item != nil {
+			item.Preemptions++
+		}
+	case si.UsageRecordLazyPreempted, si.UsageRecordLazyPreemptionReverted:
+		lazyPreempted := r.Type == si.UsageRecordLazyPreempted
+		g := *r.AffinityGroup
+		if u != nil {
+			a.accrue(u, t)
+			if u.group.LazyPreempted == lazyPreempted {
+				return
+			}
+			u.group.LazyPreempted = lazyPreempted
+			g = u.group
+		}
+		if item := a.item(g, t); item != nil && lazyPreempted {
+			item.LazyPreemptions++
+		}
+	}
+}
+
+func (a *usageAggregator) resync(r si.UsageRecord) {
+	t := r.Time.Time
+	synced := map[string]bool{}
+	for _, g := range r.AffinityGroups {
+		synced[g.Name] = true
+		if u := a.open[g.Name]; u != nil {
+			a.accrue(u, t)
+			u.group.LazyPreempted = g.LazyPreempted
+		} else {
+			a.open[g.Name] = &openUsage{group: g, since: t}
+		}
+	}
+	for _, name := range a.openNames() {
+		if !synced[name] {
+			a.accrue(a.open[name], t)
+			delete(a.open, name)
+		}
+	}
+}
+
+// accrue accounts the usage of an affinity group from the time since until the
+// given time, within the time range.
+func (a *usageAggregator) accrue(u *openUsage, until time.Time) {
+	from, to := u.since, until
+	if from.Before(a.startTime) {
+		from = a.startTime
+	}
+	if to.After(a.endTime) {
+		to = a.endTime
+	}
+	if to.After(from) {
+		leafCellHours := to.Sub(from).Hours() * float64(u.group.LeafCellNumber)
+		item := a.item(u.group, from)
+		item.LeafCellHours += leafCellHours
+		if u.group.LazyPreempted {
+			item.LazyPreemptedLeafCellHours += leafCellHours
+		}
+	}
+	if until.After(u.since) {
+		u.since = until
+	}
+}
+
+// item returns the UsageReportItem of the affinity group, or nil if the time is
+// not within the time range.
+func (a *usageAggregator) item(g si.UsageAffinityGroup, t time.Time) *si.UsageReportItem {
+	if t.Before(a.startTime) || t.After(a.endTime) {
+		return nil
+	}
+	key := usageReportKey{
+		vc:           g.VirtualCluster,
+		user:         g.User,
+		priority:     g.Priority,
+		leafCellType: g.LeafCellType,
+	}
+	if a.items[key] == nil {
+		a.items[key] = &si.UsageReportItem{
+			VirtualCluster: g.VirtualCluster,
+			User:           g.User,
+			Priority:       g.Priority,
+			LeafCellType:   g.LeafCellType,
+		}
+	}
+	return a.items[key]
+}
+
+func (a *usageAggregator) openNames() []string {
+	var names []string
+	for name := range a.open {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
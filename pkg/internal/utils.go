@@ -25,12 +25,19 @@ package internal
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	si "github.com/microsoft/hivedscheduler/pkg/api"
 	"github.com/microsoft/hivedscheduler/pkg/common"
 	core "k8s.io/api/core/v1"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
 	kubeClient "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
@@ -46,6 +53,15 @@ func CreateClient(kConfig *rest.Config) kubeClient.Interface {
 	return kClient
 }
 
+func CreateDynamicClient(kConfig *rest.Config) dynamic.Interface {
+	dClient, err := dynamic.NewForConfig(kConfig)
+	if err != nil {
+		panic(fmt.Errorf("Failed to create DynamicClient: %v", err))
+	}
+
+	return dClient
+}
+
 func GetKey(obj interface{}) (string, error) {
 	return cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
 }
@@ -156,19 +172,121 @@ func IsUnbound(pod *core.Pod) bool {
 	return pod.Spec.NodeName == "" && IsLive(pod)
 }
 
-// A node is considered healthy if it is not unschedulable and in ready condition.
-func IsNodeHealthy(node *core.Node) bool {
-	if node.Spec.Unschedulable {
+// NodeHealthPolicy decides whether a node is healthy according to the
+// NodeHealthPolicySpec, with its label selectors parsed once.
+type NodeHealthPolicy struct {
+	spec              *si.NodeHealthPolicySpec
+	badLabelSelectors []labels.Selector
+}
+
+func NewNodeHealthPolicy(spec *si.NodeHealthPolicySpec) *NodeHealthPolicy {
+	p := &NodeHealthPolicy{spec: spec}
+	for _, s := range spec.BadLabelSelectors {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			panic(fmt.Errorf("Invalid badLabelSelectors in nodeHealthPolicy: %v: %v", s, err))
+		}
+		p.badLabelSelectors = append(p.badLabelSelectors, selector)
+	}
+	return p
+}
+
+// HealthyDamping is the period a bad node should keep healthy before it is
+// declared healthy again.
+func (p *NodeHealthPolicy) HealthyDamping() time.Duration {
+	return time.Duration(*p.spec.HealthyDampingSec) * time.Second
+}
+
+// A node is considered healthy if none of the bad rules in the policy matches it.
+// The default policy is that a node is healthy if it is not unschedulable and
+// in ready condition.
+func (p *NodeHealthPolicy) IsNodeHealthy(node *core.Node) bool {
+	if *p.spec.UnschedulableIsBad && node.Spec.Unschedulable {
 		return false
 	}
+	for _, hc := range p.spec.HealthyConditions {
+		if !hasNodeCondition(node, hc) {
+			return false
+		}
+	}
+	for _, bt := range p.spec.BadTaints {
+		for _, t := range node.Spec.Taints {
+			if t.Key == bt.Key && (bt.Effect == "" || string(t.Effect) == bt.Effect) {
+				return false
+			}
+		}
+	}
+	nodeLabels := labels.Set(node.Labels)
+	for _, selector := range p.badLabelSelectors {
+		if selector.Matches(nodeLabels) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasNodeCondition(node *core.Node, nc si.NodeConditionSpec) bool {
 	for _, c := range node.Status.Conditions {
-		if c.Type == core.NodeReady && c.Status == core.ConditionTrue {
+		if string(c.Type) == nc.Type && string(c.Status) == nc.Status {
 			return true
 		}
 	}
 	return false
 }
 
+// ExtractNodeBadLeafCellIndices extracts the bad leaf cell indices of a node
+// from its annotation, so that only these leaf cells are considered as bad if the
+// node itself is healthy.
+// The annotation is fed by external health checker, so the invalid indices are
+// just ignored with a warning instead of failing the whole node.
+func ExtractNodeBadLeafCellIndices(node *core.Node) []int32 {
+	indices := []int32{}
+	annotation := strings.TrimSpace(node.Annotations[si.AnnotationKeyNodeBadLeafCellIndices])
+	if annotation == "" {
+		return indices
+	}
+
+	for _, s := range strings.Split(annotation, ",") {
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 32)
+		if err != nil || i < 0 {
+			klog.Warningf("[%v]: Ignored invalid bad leaf cell index %v in annotation %v",
+				node.Name, common.Quote(s), si.AnnotationKeyNodeBadLeafCellIndices)
+			continue
+		}
+		indices = append(indices, int32(i))
+	}
+	return indices
+}
+
+// A node is considered draining if it is labeled as draining.
+func IsNodeDraining(node *core.Node) bool {
+	return node.Labels[si.LabelKeyNodeDraining] == "true"
+}
+
+// ExtractNodeDrainingCells extracts the addresses of the cells drained by the
+// admin API from the node annotation.
+func ExtractNodeDrainingCells(node *core.Node) []si.CellAddress {
+	return ExtractNodeAdminCells(node, si.AnnotationKeyNodeDrainingCells)
+}
+
+// ExtractNodeBadCells extracts the addresses of the cells marked as bad by the
+// admin API from the node annotation.
+func ExtractNodeBadCells(node *core.Node) []si.CellAddress {
+	return ExtractNodeAdminCells(node, si.AnnotationKeyNodeBadCells)
+}
+
+// ExtractNodeAdminCells extracts the comma separated cell addresses from the
+// given node annotation.
+func ExtractNodeAdminCells(node *core.Node, annotationKey string) []si.CellAddress {
+	addresses := []si.CellAddress{}
+	for _, s := range strings.Split(node.Annotations[annotationKey], ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			addresses = append(addresses, si.CellAddress(s))
+		}
+	}
+	return addresses
+}
+
 func NewBindingPod(pod *core.Pod, podBindInfo *si.PodBindInfo) *core.Pod {
 	bindingPod := pod.DeepCopy()
 
@@ -212,24 +330,335 @@ func ExtractPodBindInfo(allocatedPod *core.Pod) *si.PodBindInfo {
 }
 
 func ExtractPodBindAnnotations(allocatedPod *core.Pod) map[string]string {
+	var annotations map[string]string
 	if _, ok := allocatedPod.Annotations[si.AnnotationKeyPodLeafCellIsolation]; ok {
-		return map[string]string{
+		annotations = map[string]string{
 			si.AnnotationKeyPodLeafCellIsolation: allocatedPod.Annotations[si.AnnotationKeyPodLeafCellIsolation],
 			si.AnnotationKeyPodBindInfo:          allocatedPod.Annotations[si.AnnotationKeyPodBindInfo],
 		}
 	} else {
-		return map[string]string{
+		annotations = map[string]string{
 			si.AnnotationKeyPodLeafCellIsolation: allocatedPod.Annotations[si.DeprecatedAnnotationKeyPodGpuIsolation],
 			si.AnnotationKeyPodBindInfo:          convertOldAnnotation(allocatedPod.Annotations[si.AnnotationKeyPodBindInfo]),
 		}
 	}
+
+	// Also persist the PodSchedulingSpec resolved from the AffinityGroup custom
+	// resource, so that the allocated placement can be recovered even if the
+	// AffinityGroup is changed or deleted.
+	if _, ok := allocatedPod.Annotations[si.AnnotationKeyPodAffinityGroup]; ok {
+		annotations[si.AnnotationKeyPodSchedulingSpec] =
+			allocatedPod.Annotations[si.AnnotationKeyPodSchedulingSpec]
+	}
+	// So as the PodSchedulingSpec resolved from the PodGroup or the Pod
+	// controller, which may be scaled or deleted.
+	for _, key := range []string{
+		si.AnnotationKeyPodPodGroupAffinityGroup,
+		si.AnnotationKeyPodOwnerAffinityGroup} {
+		if uid, ok := allocatedPod.Annotations[key]; ok {
+			annotations[si.AnnotationKeyPodSchedulingSpec] =
+				allocatedPod.Annotations[si.AnnotationKeyPodSchedulingSpec]
+			annotations[key] = uid
+		}
+	}
+	return annotations
+}
+
+func NewAffinityGroupResourceGVR() schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    si.GroupName,
+		Version:  si.AffinityGroupResourceVersion,
+		Resource: si.AffinityGroupResourcePlural,
+	}
+}
+
+func NewPodGroupResourceGVR(kind si.PodGroupKind) schema.GroupVersionResource {
+	switch kind {
+	case si.PodGroupKindSchedulerPlugins:
+		return schema.GroupVersionResource{
+			Group:    "scheduling.x-k8s.io",
+			Version:  "v1alpha1",
+			Resource: "podgroups",
+		}
+	case si.PodGroupKindVolcano:
+		return schema.GroupVersionResource{
+			Group:    "scheduling.volcano.sh",
+			Version:  "v1beta1",
+			Resource: "podgroups",
+		}
+	default:
+		panic(fmt.Errorf("Unknown PodGroupKind %v", kind))
+	}
+}
+
+// Get the name of the PodGroup of the kind referenced by the Pod, if any.
+func ExtractPodGroupName(pod *core.Pod, kind si.PodGroupKind) string {
+	switch kind {
+	case si.PodGroupKindSchedulerPlugins:
+		return pod.Labels[si.LabelKeySchedulerPluginsPodGroup]
+	case si.PodGroupKindVolcano:
+		return pod.Annotations[si.AnnotationKeyVolcanoPodGroup]
+	default:
+		return ""
+	}
+}
+
+func NewVirtualClusterResourceGVR() schema.GroupVersionResource {
+	return schema.GroupVersionResource{
+		Group:    si.GroupName,
+		Version:  si.VirtualClusterResourceVersion,
+		Resource: si.VirtualClusterResourcePlural,
+	}
+}
+
+// VirtualClusterResource comes from external, and it will be validated when
+// applied.
+func ToVirtualClusterResource(obj *unstructured.Unstructured) *si.VirtualClusterResource {
+	vc := si.VirtualClusterResource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(
+		obj.UnstructuredContent(), &vc); err != nil {
+		panic(NewInvalidSpecError(fmt.Sprintf(
+			"VirtualCluster %v: Failed to convert: %v", obj.GetName(), err)))
+	}
+	return &vc
+}
+
+// AffinityGroupResource comes from external, so need Validation when
+// deserialization.
+func ToAffinityGroupResource(obj *unstructured.Unstructured) *si.AffinityGroupResource {
+	group := si.AffinityGroupResource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(
+		obj.UnstructuredContent(), &group); err != nil {
+		panic(NewInvalidSpecError(fmt.Sprintf(
+			"AffinityGroup %v/%v: Failed to convert: %v",
+			obj.GetNamespace(), obj.GetName(), err)))
+	}
+	return &group
+}
+
+func ToPodGroupResource(obj *unstructured.Unstructured) *si.PodGroupResource {
+	group := si.PodGroupResource{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(
+		obj.UnstructuredContent(), &group); err != nil {
+		panic(NewInvalidSpecError(fmt.Sprintf(
+			"PodGroup %v/%v: Failed to convert: %v",
+			obj.GetNamespace(), obj.GetName(), err)))
+	}
+	return &group
+}
+
+// NewPodWithPodGroupResource returns a copy of the Pod with its affinity group
+// resolved from the PodGroup custom resource it references, see
+// PodGroupResource.
+func NewPodWithPodGroupResource(pod *core.Pod, group *si.PodGroupResource) *core.Pod {
+	errPfx := fmt.Sprintf("Pod annotation %v: ", si.AnnotationKeyPodSchedulingSpec)
+
+	podSchedulingSpec := si.PodSchedulingSpec{IgnoreK8sSuggestedNodes: true}
+	func() {
+		defer AsInvalidSpecPanic()
+		common.FromYaml(convertOldAnnotation(pod.Annotations[si.AnnotationKeyPodSchedulingSpec]), &podSchedulingSpec)
+	}()
+	if podSchedulingSpec.LeafCellNumber <= 0 {
+		podSchedulingSpec.LeafCellNumber = ExtractPodLeafCellNumber(pod, si.ResourceNameNvidiaGpu)
+	}
+	if group.Spec.MinMember <= 0 {
+		panic(NewInvalidSpecError(fmt.Sprintf(errPfx+
+			"PodGroup %v/%v has non-positive MinMember", group.Namespace, group.Name)))
+	}
+	podSchedulingSpec.AffinityGroup = &si.AffinityGroupSpec{
+		Name: string(group.UID),
+		Members: []si.AffinityGroupMemberSpec{{
+			PodNumber:      group.Spec.MinMember,
+			LeafCellNumber: podSchedulingSpec.LeafCellNumber,
+		}},
+	}
+
+	resolvedPod := pod.DeepCopy()
+	resolvedPod.Annotations[si.AnnotationKeyPodSchedulingSpec] = common.ToYaml(podSchedulingSpec)
+	resolvedPod.Annotations[si.AnnotationKeyPodPodGroupAffinityGroup] = string(group.UID)
+	return resolvedPod
+}
+
+// NewPodWithAffinityGroupResource returns a copy of the Pod with its
+// PodSchedulingSpec annotation resolved from the AffinityGroup custom resource
+// it references, so that the Pod can be consumed in the same way as the Pod
+// specifying the PodSchedulingSpec annotation by itself.
+func NewPodWithAffinityGroupResource(
+	pod *core.Pod, group *si.AffinityGroupResource) *core.Pod {
+	errPfx := fmt.Sprintf("Pod annotation %v: ", si.AnnotationKeyPodAffinityGroup)
+
+	leafCellNumber := int32(0)
+	if s, ok := pod.Annotations[si.AnnotationKeyPodLeafCellNumber]; ok {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			panic(NewInvalidSpecError(fmt.Sprintf(
+				"Pod annotation %v: Invalid leaf cell number %v: %v",
+				si.AnnotationKeyPodLeafCellNumber, s, err)))
+		}
+		leafCellNumber = int32(n)
+	} else if len(group.Spec.Members) == 1 {
+		leafCellNumber = group.Spec.Members[0].LeafCellNumber
+	} else {
+		panic(NewInvalidSpecError(fmt.Sprintf(errPfx+
+			"AffinityGroup %v has %v members, so Pod annotation %v should be specified",
+			group.Name, len(group.Spec.Members), si.AnnotationKeyPodLeafCellNumber)))
+	}
+
+	ignoreK8sSuggestedNodes := true
+	if group.Spec.IgnoreK8sSuggestedNodes != nil {
+		ignoreK8sSuggestedNodes = *group.Spec.IgnoreK8sSuggestedNodes
+	}
+
+	resolvedPod := pod.DeepCopy()
+	resolvedPod.Annotations[si.AnnotationKeyPodSchedulingSpec] = common.ToYaml(si.PodSchedulingSpec{
+		VirtualCluster:          group.Spec.VirtualCluster,
+		Priority:                group.Spec.Priority,
+		PinnedCellId:            group.Spec.PinnedCellId,
+		LeafCellType:            group.Spec.LeafCellType,
+		LeafCellNumber:          leafCellNumber,
+		GangReleaseEnable:       group.Spec.GangReleaseEnable,
+		LazyPreemptionEnable:    group.Spec.LazyPreemptionEnable,
+		IgnoreK8sSuggestedNodes: ignoreK8sSuggestedNodes,
+		AffinityGroup: &si.AffinityGroupSpec{
+			Name:    fmt.Sprintf("%v/%v", group.Namespace, group.Name),
+			Members: group.Spec.Members,
+		},
+	})
+	return resolvedPod
+}
+
+// Get the service account of the Pod in {namespace}/{name} format.
+func ExtractPodServiceAccount(pod *core.Pod) string {
+	if pod.Spec.ServiceAccountName == "" {
+		return fmt.Sprintf("%v/default", pod.Namespace)
+	}
+	return fmt.Sprintf("%v/%v", pod.Namespace, pod.Spec.ServiceAccountName)
+}
+
+// Authorize the VC and priority usage of the Pod by the access rules, see
+// AccessRuleSpec.
+func AuthorizePod(rules []si.AccessRuleSpec, pod *core.Pod) {
+	s := ExtractPodSchedulingSpec(pod)
+	serviceAccount := ExtractPodServiceAccount(pod)
+
+	// The max allowed priority, which is less than OpportunisticPriority if the
+	// VC is not allowed at all.
+	maxPriority := si.OpportunisticPriority - 1
+	for _, rule := range rules {
+		if !common.StringsContains(rule.Namespaces, pod.Namespace) &&
+			!common.StringsContains(rule.ServiceAccounts, serviceAccount) {
+			continue
+		}
+		for _, vc := range rule.VirtualClusters {
+			if vc == s.VirtualCluster && *rule.MaxPriority > maxPriority {
+				maxPriority = *rule.MaxPriority
+			}
+		}
+	}
+
+	if maxPriority < si.OpportunisticPriority {
+		panic(NewUnauthorizedError(fmt.Sprintf(
+			"Pod namespace %v and service account %v are not allowed to use VC %v",
+			pod.Namespace, serviceAccount, s.VirtualCluster)))
+	}
+	if s.Priority > maxPriority {
+		panic(NewUnauthorizedError(fmt.Sprintf(
+			"Pod namespace %v and service account %v are not allowed to use priority "+
+				"%v in VC %v, the max allowed priority is %v",
+			pod.Namespace, serviceAccount, s.Priority, s.VirtualCluster, maxPriority)))
+	}
+}
+
+// Get the total leaf cell resource limits of the Pod containers.
+func ExtractPodLeafCellNumber(pod *core.Pod, leafCellResourceName string) int32 {
+	leafCellNumber := int64(0)
+	for _, container := range pod.Spec.Containers {
+		if q, ok := container.Resources.Limits[core.ResourceName(leafCellResourceName)]; ok {
+			leafCellNumber += q.Value()
+		}
+	}
+	return int32(leafCellNumber)
+}
+
+// All the Pods controlled by the same owner, such as a Job or StatefulSet, are
+// in one affinity group, named by the owner UID.
+func NewOwnerAffinityGroupSpec(
+	owner *meta.OwnerReference, podNumber int32, leafCellNumber int32) *si.AffinityGroupSpec {
+	return &si.AffinityGroupSpec{
+		Name: string(owner.UID),
+		Members: []si.AffinityGroupMemberSpec{{
+			PodNumber:      podNumber,
+			LeafCellNumber: leafCellNumber,
+		}},
+	}
+}
+
+// Whether the Pod explicitly specifies its affinity group, instead of the
+// default affinity group of itself.
+func IsPodAffinityGroupSpecified(pod *core.Pod) bool {
+	defer AsInvalidSpecPanic()
+
+	podSchedulingSpec := si.PodSchedulingSpec{}
+	common.FromYaml(convertOldAnnotation(pod.Annotations[si.AnnotationKeyPodSchedulingSpec]), &podSchedulingSpec)
+	return podSchedulingSpec.AffinityGroup != nil
+}
+
+// Return the Pod with its affinity group resolved from its controller, see
+// OwnerAffinityGroupEnable.
+func NewPodWithOwnerAffinityGroup(
+	pod *core.Pod, owner *meta.OwnerReference, podNumber int32) *core.Pod {
+	podSchedulingSpec := ExtractPodSchedulingSpec(pod)
+	podSchedulingSpec.AffinityGroup = NewOwnerAffinityGroupSpec(
+		owner, podNumber, podSchedulingSpec.LeafCellNumber)
+
+	resolvedPod := pod.DeepCopy()
+	resolvedPod.Annotations[si.AnnotationKeyPodSchedulingSpec] = common.ToYaml(podSchedulingSpec)
+	resolvedPod.Annotations[si.AnnotationKeyPodOwnerAffinityGroup] = string(owner.UID)
+	return resolvedPod
+}
+
+// Escape the JSON Pointer (RFC 6901) token, such as the annotation key.
+func escapeJsonPointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+func NewPodAnnotationPatch(pod *core.Pod, key string, value string) si.JsonPatchOperation {
+	if pod.Annotations == nil {
+		return si.JsonPatchOperation{
+			Op:    "add",
+			Path:  "/metadata/annotations",
+			Value: map[string]string{key: value},
+		}
+	}
+	return si.JsonPatchOperation{
+		Op:    "add",
+		Path:  "/metadata/annotations/" + escapeJsonPointer(key),
+		Value: value,
+	}
+}
+
+// Enable the Pod to be scheduled by this scheduler, by the resource limit on its
+// first container, see IsHivedEnabled.
+func NewPodSchedulingEnablePatch(pod *core.Pod) si.JsonPatchOperation {
+	if pod.Spec.Containers[0].Resources.Limits == nil {
+		return si.JsonPatchOperation{
+			Op:    "add",
+			Path:  "/spec/containers/0/resources/limits",
+			Value: map[string]string{si.ResourceNamePodSchedulingEnable: "1"},
+		}
+	}
+	return si.JsonPatchOperation{
+		Op:    "add",
+		Path:  "/spec/containers/0/resources/limits/" + escapeJsonPointer(si.ResourceNamePodSchedulingEnable),
+		Value: "1",
+	}
 }
 
 // PodSchedulingSpec comes from external, so need more Defaulting and Validation
 // when deserialization.
 func ExtractPodSchedulingSpec(pod *core.Pod) *si.PodSchedulingSpec {
 	// Consider all panics are BadRequestPanic.
-	defer AsBadRequestPanic()
+	defer AsInvalidSpecPanic()
 	errPfx := fmt.Sprintf("Pod annotation %v: ", si.AnnotationKeyPodSchedulingSpec)
 
 	podSchedulingSpec := si.PodSchedulingSpec{IgnoreK8sSuggestedNodes: true}
@@ -317,6 +746,49 @@ func NewBadRequestError(message string) *si.WebServerError {
 	return si.NewWebServerError(http.StatusBadRequest, message)
 }
 
+func NewInvalidSpecError(message string) *si.WebServerError {
+	return si.NewWebServerErrorWithReason(
+		http.StatusBadRequest, si.ErrorReasonInvalidSpec, message)
+}
+
+func NewUnknownVirtualClusterError(message string) *si.WebServerError {
+	return si.NewWebServerErrorWithReason(
+		http.StatusBadRequest, si.ErrorReasonUnknownVirtualCluster, message)
+}
+
+func NewUnknownPinnedCellError(message string) *si.WebServerError {
+	return si.NewWebServerErrorWithReason(
+		http.StatusBadRequest, si.ErrorReasonUnknownPinnedCell, message)
+}
+
+func NewUnauthorizedError(message string) *si.WebServerError {
+	return si.NewWebServerErrorWithReason(
+		http.StatusForbidden, si.ErrorReasonUnauthorized, message)
+}
+
+func NewInternalInvariantError(message string) *si.WebServerError {
+	return si.NewWebServerErrorWithReason(
+		http.StatusInternalServerError, si.ErrorReasonInternalInvariant, message)
+}
+
+// Convert a recovered Panic to WebServerError.
+// A Panic which is not a WebServerError is not expected, so it is considered
+// as the internal invariant broken.
+func AsWebServerError(r interface{}) *si.WebServerError {
+	if err, ok := r.(*si.WebServerError); ok {
+		return err
+	}
+	return NewInternalInvariantError(fmt.Sprintf("%v", r))
+}
+
+// Recover Panic as the returned error, see AsWebServerError.
+// It should be deferred directly with the named error result.
+func RecoverAsError(err *error) {
+	if r := recover(); r != nil {
+		*err = AsWebServerError(r)
+	}
+}
+
 // Wrap and Rethrow Panic as BadRequestError Panic
 func AsBadRequestPanic() {
 	if r := recover(); r != nil {
@@ -324,6 +796,13 @@ func AsBadRequestPanic() {
 	}
 }
 
+// Wrap and Rethrow Panic as InvalidSpecError Panic
+func AsInvalidSpecPanic() {
+	if r := recover(); r != nil {
+		panic(NewInvalidSpecError(fmt.Sprintf("%v", r)))
+	}
+}
+
 // Recover User Error Panic
 // Rethrow Platform Error Panic
 func HandleInformerPanic(logPfx string, logOnSucceeded bool) {
@@ -346,8 +825,9 @@ func HandleInformerPanic(logPfx string, logOnSucceeded bool) {
 func HandleRoutinePanic(logPfx string) {
 	if r := recover(); r != nil {
 		if err, ok := r.(*si.WebServerError); ok {
-			panic(si.NewWebServerError(
+			panic(si.NewWebServerErrorWithReason(
 				err.Code,
+				err.Reason,
 				fmt.Sprintf(logPfx+"Failed: %v", err.Message)))
 		} else {
 			panic(fmt.Errorf(logPfx+"Failed: %v", r))
@@ -360,12 +840,7 @@ func HandleRoutinePanic(logPfx string) {
 // Log and Recover Panic
 func HandleWebServerPanic(handler func(*si.WebServerError)) {
 	if r := recover(); r != nil {
-		err, ok := r.(*si.WebServerError)
-		if !ok {
-			err = si.NewWebServerError(
-				http.StatusInternalServerError,
-				fmt.Sprintf("%v", r))
-		}
+		err := AsWebServerError(r)
 
 		if err.Code >= http.StatusInternalServerError {
 			klog.Warningf("%v%v", err.Message, common.GetPanicDetails(r))
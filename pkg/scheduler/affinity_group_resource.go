@@ -0,0 +1,155 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/common"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	core "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/klog"
+)
+
+// The AffinityGroupStatus is mirrored to the AffinityGroup custom resources
+// periodically, instead of on each change, to avoid flooding ApiServer.
+const affinityGroupStatusSyncPeriod = 10 * time.Second
+
+func (s *HivedScheduler) initAffinityGroupResource(dClient dynamic.Interface) {
+	gvr := internal.NewAffinityGroupResourceGVR()
+	informer := dynamicinformer.NewDynamicSharedInformerFactory(dClient, 0).
+		ForResource(gvr).Informer()
+
+	s.affinityGroupClient = dClient.Resource(gvr)
+	s.affinityGroupInformer = informer
+	s.affinityGroupLister = dynamiclister.New(informer.GetIndexer(), gvr)
+}
+
+// Return the Pod with its PodSchedulingSpec resolved from the AffinityGroup
+// custom resource it references, if any.
+// The Pod which already has the PodSchedulingSpec annotation, such as the
+// allocated one, is returned as is, so its PodSchedulingSpec never changes.
+// Otherwise, its affinity group may be resolved from the PodGroup it
+// references or its controller, see resolvePodGroup and
+// resolveOwnerAffinityGroup.
+func (s *HivedScheduler) resolvePod(pod *core.Pod) *core.Pod {
+	groupName, ok := pod.Annotations[si.AnnotationKeyPodAffinityGroup]
+	if !ok || pod.Annotations[si.AnnotationKeyPodSchedulingSpec] != "" {
+		if resolvedPod := s.resolvePodGroup(pod); resolvedPod != pod {
+			return resolvedPod
+		}
+		if *s.sConfig.OwnerAffinityGroupEnable {
+			return s.resolveOwnerAffinityGroup(pod)
+		}
+		return pod
+	}
+	if s.affinityGroupLister == nil {
+		panic(internal.NewInvalidSpecError(fmt.Sprintf(
+			"Pod annotation %v: AffinityGroup custom resource is not enabled",
+			si.AnnotationKeyPodAffinityGroup)))
+	}
+
+	obj, err := s.affinityGroupLister.Namespace(pod.Namespace).Get(groupName)
+	if err != nil {
+		if apiErrors.IsNotFound(err) {
+			// If the AffinityGroup has not been informed to the scheduler:
+			// The inconsistency should can be reconciled by the scheduler
+			// AffinityGroupInformer.
+			panic(internal.NewInvalidSpecError(fmt.Sprintf(
+				"Pod annotation %v: AffinityGroup %v/%v does not exist or has not "+
+					"been informed to the scheduler",
+				si.AnnotationKeyPodAffinityGroup, pod.Namespace, groupName)))
+		}
+		panic(fmt.Errorf(
+			"Failed to get AffinityGroup %v/%v from local cache: %v",
+			pod.Namespace, groupName, err))
+	}
+
+	return internal.NewPodWithAffinityGroupResource(pod, internal.ToAffinityGroupResource(obj))
+}
+
+// Mirror the AffinityGroupStatus in the scheduler to all the AffinityGroup
+// custom resources.
+// It is only synced by the leader, since the standby replicas may be stale.
+func (s *HivedScheduler) syncAffinityGroupStatuses() {
+	if !s.isLeader() {
+		return
+	}
+
+	objs, err := s.affinityGroupLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("Failed to list AffinityGroups from local cache: %v", err)
+		return
+	}
+
+	statuses := map[string]si.AffinityGroupStatus{}
+	for _, group := range s.getAllAffinityGroups().Items {
+		statuses[group.Name] = group.Status
+	}
+	for _, obj := range objs {
+		s.syncAffinityGroupStatus(obj, statuses)
+	}
+}
+
+func (s *HivedScheduler) syncAffinityGroupStatus(
+	obj *unstructured.Unstructured, statuses map[string]si.AffinityGroupStatus) {
+	key := fmt.Sprintf("%v/%v", obj.GetNamespace(), obj.GetName())
+	logPfx := fmt.Sprintf("[%v]: syncAffinityGroupStatus: ", key)
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Warningf(logPfx+"Skipped: %v", r)
+		}
+	}()
+
+	group := internal.ToAffinityGroupResource(obj)
+	status, ok := statuses[key]
+	if !ok {
+		// The AffinityGroup is not allocated or preempting.
+		status = si.AffinityGroupStatus{
+			VC:       group.Spec.VirtualCluster,
+			Priority: group.Spec.Priority,
+		}
+	}
+	if common.ToJson(status) == common.ToJson(group.Status) {
+		return
+	}
+
+	statusObj := map[string]interface{}{}
+	common.FromJson(common.ToJson(status), &statusObj)
+	newObj := obj.DeepCopy()
+	newObj.Object["status"] = statusObj
+	if _, err := s.affinityGroupClient.Namespace(obj.GetNamespace()).UpdateStatus(
+		newObj, meta.UpdateOptions{}); err != nil {
+		panic(fmt.Errorf("Failed to update AffinityGroup status: %v", err))
+	}
+	klog.Infof(logPfx+"Updated to state %v", status.State)
+}
@@ -0,0 +1,162 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+)
+
+// The prefix of all the metric names of this scheduler.
+const metricsNamespace = "hivedscheduler_"
+
+type schedulerMetrics struct {
+	// The latency of the scheduling routines, including the time to wait for
+	// the schedulerLock.
+	filterLatency  *internal.Histogram
+	preemptLatency *internal.Histogram
+	bindLatency    *internal.Histogram
+	// The total number of force binds, protected by the schedulerLock.
+	forceBindCount uint64
+	// The offsets added to the counters of the current SchedulerAlgorithm, so
+	// that the counters keep increasing across the replaced SchedulerAlgorithms,
+	// protected by the schedulerLock.
+	preemptionCountOffset     int64
+	lazyPreemptionCountOffset int64
+}
+
+func newSchedulerMetrics() *schedulerMetrics {
+	return &schedulerMetrics{
+		filterLatency:  internal.NewHistogram(internal.DefaultLatencyBuckets),
+		preemptLatency: internal.NewHistogram(internal.DefaultLatencyBuckets),
+		bindLatency:    internal.NewHistogram(internal.DefaultLatencyBuckets),
+	}
+}
+
+// Get all the metrics of the scheduler in the Prometheus text-based exposition
+// format.
+// The algorithm metrics are only exposed if the SchedulerAlgorithm is a
+// MetricsSchedulerAlgorithm.
+func (s *HivedScheduler) getMetrics() []byte {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	mw := &internal.MetricsWriter{}
+	if algorithm, ok := s.schedulerAlgorithm.(internal.MetricsSchedulerAlgorithm); ok {
+		m := algorithm.GetMetrics()
+		m.PreemptionCount = uint64(int64(m.PreemptionCount) + s.metrics.preemptionCountOffset)
+		m.LazyPreemptionCount = uint64(int64(m.LazyPreemptionCount) + s.metrics.lazyPreemptionCountOffset)
+		writeAlgorithmMetrics(mw, m)
+	}
+
+	waitingPodNum := 0
+	for _, podStatus := range s.podScheduleStatuses {
+		if podStatus.PodState == internal.PodWaiting {
+			waitingPodNum++
+		}
+	}
+	mw.WriteMetric(metricsNamespace+"waiting_pods", "gauge",
+		"Number of the Pods waiting for resources.", float64(waitingPodNum))
+	mw.WriteMetric(metricsNamespace+"force_binds_total", "counter",
+		"Total number of the Pods force bound.", float64(s.metrics.forceBindCount))
+	s.metrics.filterLatency.Write(mw, metricsNamespace+"filter_duration_seconds",
+		"Latency of the filter routine in seconds.")
+	s.metrics.preemptLatency.Write(mw, metricsNamespace+"preempt_duration_seconds",
+		"Latency of the preempt routine in seconds.")
+	s.metrics.bindLatency.Write(mw, metricsNamespace+"bind_duration_seconds",
+		"Latency of the bind routine in seconds.")
+	return mw.Bytes()
+}
+
+// Rebase the algorithm counters once the SchedulerAlgorithm is replaced, so that
+// the counts of the old one are kept, and the ones recovered into the new one,
+// such as the recovered lazy preemptions, are not counted again.
+// The caller should hold the schedulerLock.
+func (m *schedulerMetrics) rebaseAlgorithmCounters(oldAlgorithm, newAlgorithm internal.SchedulerAlgorithm) {
+	oldMetrics, ok := oldAlgorithm.(internal.MetricsSchedulerAlgorithm)
+	if !ok {
+		return
+	}
+	newMetrics, ok := newAlgorithm.(internal.MetricsSchedulerAlgorithm)
+	if !ok {
+		return
+	}
+	o, n := oldMetrics.GetMetrics(), newMetrics.GetMetrics()
+	m.preemptionCountOffset += int64(o.PreemptionCount) - int64(n.PreemptionCount)
+	m.lazyPreemptionCountOffset += int64(o.LazyPreemptionCount) - int64(n.LazyPreemptionCount)
+}
+
+func writeAlgorithmMetrics(mw *internal.MetricsWriter, m internal.AlgorithmMetrics) {
+	for _, gauge := range []struct {
+		name  string
+		help  string
+		value func(c internal.VirtualClusterCellMetrics) int32
+	}{
+		{"vc_free_cells", "Number of the preassigned cells of the VC which are free.",
+			func(c internal.VirtualClusterCellMetrics) int32 { return c.Free }},
+		{"vc_used_cells", "Number of the preassigned cells of the VC which are used.",
+			func(c internal.VirtualClusterCellMetrics) int32 { return c.Used }},
+		{"vc_doomed_bad_cells", "Number of the free preassigned cells of the VC which are doomed to be bad.",
+			func(c internal.VirtualClusterCellMetrics) int32 { return c.DoomedBad }},
+	} {
+		name := metricsNamespace + gauge.name
+		mw.WriteHeader(name, "gauge", gauge.help)
+		for _, c := range m.VirtualClusterCells {
+			mw.WriteSample(name, []internal.MetricLabel{
+				{Name: "vc", Value: string(c.VirtualCluster)},
+				{Name: "chain", Value: c.Chain},
+				{Name: "cell_type", Value: string(c.CellType)},
+			}, float64(gauge.value(c)))
+		}
+	}
+
+	name := metricsNamespace + "used_leaf_cells"
+	mw.WriteHeader(name, "gauge", "Number of the used leaf cells at each priority.")
+	for _, c := range m.UsedLeafCells {
+		mw.WriteSample(name, []internal.MetricLabel{
+			{Name: "chain", Value: c.Chain},
+			{Name: "priority", Value: fmt.Sprint(c.Priority)},
+		}, float64(c.Number))
+	}
+
+	name = metricsNamespace + "affinity_groups"
+	mw.WriteHeader(name, "gauge", "Number of the affinity groups in each state.")
+	states := []string{}
+	for state := range m.AffinityGroups {
+		states = append(states, string(state))
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		mw.WriteSample(name, []internal.MetricLabel{
+			{Name: "state", Value: state},
+		}, float64(m.AffinityGroups[si.AffinityGroupState(state)]))
+	}
+
+	mw.WriteMetric(metricsNamespace+"preemptions_total", "counter",
+		"Total number of the affinity group preemptions.", float64(m.PreemptionCount))
+	mw.WriteMetric(metricsNamespace+"lazy_preemptions_total", "counter",
+		"Total number of the affinity group lazy preemptions.", float64(m.LazyPreemptionCount))
+}
@@ -0,0 +1,132 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"fmt"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	apps "k8s.io/api/apps/v1"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeInformer "k8s.io/client-go/informers"
+	kubeClient "k8s.io/client-go/kubernetes"
+)
+
+func (s *HivedScheduler) initOwnerAffinityGroup(kClient kubeClient.Interface) {
+	jobListerInformer := kubeInformer.NewSharedInformerFactory(kClient, 0).Batch().V1().Jobs()
+	statefulSetListerInformer := kubeInformer.NewSharedInformerFactory(kClient, 0).Apps().V1().StatefulSets()
+
+	s.jobInformer = jobListerInformer.Informer()
+	s.statefulSetInformer = statefulSetListerInformer.Informer()
+	s.jobLister = jobListerInformer.Lister()
+	s.statefulSetLister = statefulSetListerInformer.Lister()
+}
+
+// Return the Pod with its affinity group inferred from its controller, if it
+// does not specify its affinity group, see OwnerAffinityGroupEnable.
+// The Pod whose affinity group is already inferred, or the allocated one, is
+// returned as is, so its affinity group never changes.
+func (s *HivedScheduler) resolveOwnerAffinityGroup(pod *core.Pod) *core.Pod {
+	if pod.Annotations[si.AnnotationKeyPodOwnerAffinityGroup] != "" ||
+		pod.Annotations[si.AnnotationKeyPodBindInfo] != "" {
+		return pod
+	}
+	owner := meta.GetControllerOf(pod)
+	if owner == nil || internal.IsPodAffinityGroupSpecified(pod) {
+		return pod
+	}
+	podNumber := s.getControllerPodNumber(pod.Namespace, owner)
+	if podNumber <= 0 {
+		return pod
+	}
+
+	return internal.NewPodWithOwnerAffinityGroup(pod, owner, podNumber)
+}
+
+// Get the desired Pod number of the Pod controller, or 0 if the controller is
+// not a Job or StatefulSet.
+// The controller is read from the Lister if OwnerAffinityGroupEnable, otherwise
+// from the Client.
+func (s *HivedScheduler) getControllerPodNumber(
+	namespace string, owner *meta.OwnerReference) int32 {
+	switch owner.Kind {
+	case "Job":
+		var job *batch.Job
+		var err error
+		if s.jobLister != nil {
+			job, err = s.jobLister.Jobs(namespace).Get(owner.Name)
+		} else {
+			job, err = s.kClient.BatchV1().Jobs(namespace).Get(owner.Name, meta.GetOptions{})
+		}
+		if err != nil {
+			panic(newGetControllerError(namespace, owner, err))
+		}
+		if job.UID != owner.UID {
+			return 0
+		}
+		podNumber := int32(1)
+		if job.Spec.Parallelism != nil {
+			podNumber = *job.Spec.Parallelism
+		}
+		if job.Spec.Completions != nil && *job.Spec.Completions < podNumber {
+			podNumber = *job.Spec.Completions
+		}
+		return podNumber
+	case "StatefulSet":
+		var set *apps.StatefulSet
+		var err error
+		if s.statefulSetLister != nil {
+			set, err = s.statefulSetLister.StatefulSets(namespace).Get(owner.Name)
+		} else {
+			set, err = s.kClient.AppsV1().StatefulSets(namespace).Get(owner.Name, meta.GetOptions{})
+		}
+		if err != nil {
+			panic(newGetControllerError(namespace, owner, err))
+		}
+		if set.UID != owner.UID {
+			return 0
+		}
+		podNumber := int32(1)
+		if set.Spec.Replicas != nil {
+			podNumber = *set.Spec.Replicas
+		}
+		return podNumber
+	default:
+		return 0
+	}
+}
+
+func newGetControllerError(namespace string, owner *meta.OwnerReference, err error) error {
+	if apiErrors.IsNotFound(err) {
+		// If the controller has not been informed to the scheduler:
+		// The inconsistency should can be reconciled by the scheduler Informer.
+		return internal.NewBadRequestError(fmt.Sprintf(
+			"Pod controller %v %v/%v does not exist or has not been informed to "+
+				"the scheduler", owner.Kind, namespace, owner.Name))
+	}
+	return fmt.Errorf("Failed to get %v %v/%v: %v", owner.Kind, namespace, owner.Name, err)
+}
@@ -0,0 +1,182 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	core "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+// The PodGroup phase is updated periodically, instead of on each change, to
+// avoid flooding ApiServer.
+const podGroupStatusSyncPeriod = 10 * time.Second
+
+// PodGroup custom resources of one PodGroupKind are synced by the Informer and
+// read by the Lister, and their status are written by the Client.
+type podGroupResource struct {
+	client   dynamic.NamespaceableResourceInterface
+	informer cache.SharedIndexInformer
+	lister   dynamiclister.Lister
+}
+
+func (s *HivedScheduler) initPodGroupResources(dClient dynamic.Interface) {
+	s.podGroupResources = map[si.PodGroupKind]*podGroupResource{}
+	for _, kind := range *s.sConfig.PodGroupKinds {
+		gvr := internal.NewPodGroupResourceGVR(kind)
+		informer := dynamicinformer.NewDynamicSharedInformerFactory(dClient, 0).
+			ForResource(gvr).Informer()
+
+		s.podGroupResources[kind] = &podGroupResource{
+			client:   dClient.Resource(gvr),
+			informer: informer,
+			lister:   dynamiclister.New(informer.GetIndexer(), gvr),
+		}
+	}
+}
+
+// Return the Pod with its affinity group resolved from the PodGroup custom
+// resource it references, if it does not specify its affinity group, see
+// PodGroupResource.
+// The Pod whose affinity group is already resolved, or the allocated one, is
+// returned as is, so its affinity group never changes.
+func (s *HivedScheduler) resolvePodGroup(pod *core.Pod) *core.Pod {
+	if pod.Annotations[si.AnnotationKeyPodPodGroupAffinityGroup] != "" ||
+		pod.Annotations[si.AnnotationKeyPodBindInfo] != "" {
+		return pod
+	}
+	for kind, resource := range s.podGroupResources {
+		groupName := internal.ExtractPodGroupName(pod, kind)
+		if groupName == "" {
+			continue
+		}
+		if internal.IsPodAffinityGroupSpecified(pod) {
+			return pod
+		}
+
+		obj, err := resource.lister.Namespace(pod.Namespace).Get(groupName)
+		if err != nil {
+			if apiErrors.IsNotFound(err) {
+				// If the PodGroup has not been informed to the scheduler:
+				// The inconsistency should can be reconciled by the scheduler
+				// PodGroup Informer.
+				panic(internal.NewBadRequestError(fmt.Sprintf(
+					"PodGroup %v %v/%v does not exist or has not been informed to "+
+						"the scheduler", kind, pod.Namespace, groupName)))
+			}
+			panic(fmt.Errorf(
+				"Failed to get PodGroup %v %v/%v from local cache: %v",
+				kind, pod.Namespace, groupName, err))
+		}
+		return internal.NewPodWithPodGroupResource(pod, internal.ToPodGroupResource(obj))
+	}
+	return pod
+}
+
+// Update the phase of all the PodGroup custom resources from the
+// AffinityGroupState in the scheduler.
+// It is only synced by the leader, since the standby replicas may be stale.
+func (s *HivedScheduler) syncPodGroupStatuses() {
+	if !s.isLeader() {
+		return
+	}
+
+	states := map[string]si.AffinityGroupState{}
+	for _, group := range s.getAllAffinityGroups().Items {
+		states[group.Name] = group.Status.State
+	}
+	for kind, resource := range s.podGroupResources {
+		objs, err := resource.lister.List(labels.Everything())
+		if err != nil {
+			klog.Warningf("Failed to list PodGroups %v from local cache: %v", kind, err)
+			continue
+		}
+		for _, obj := range objs {
+			s.syncPodGroupStatus(kind, resource, obj, states)
+		}
+	}
+}
+
+func (s *HivedScheduler) syncPodGroupStatus(
+	kind si.PodGroupKind, resource *podGroupResource,
+	obj *unstructured.Unstructured, states map[string]si.AffinityGroupState) {
+	key := fmt.Sprintf("%v/%v/%v", kind, obj.GetNamespace(), obj.GetName())
+	logPfx := fmt.Sprintf("[%v]: syncPodGroupStatus: ", key)
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Warningf(logPfx+"Skipped: %v", r)
+		}
+	}()
+
+	state, ok := states[string(obj.GetUID())]
+	if !ok {
+		// The PodGroup is not allocated or preempting, so its phase is left
+		// to its owner.
+		return
+	}
+	phase := getPodGroupPhase(kind, state)
+	if phase == "" || phase == internal.ToPodGroupResource(obj).Status.Phase {
+		return
+	}
+
+	newObj := obj.DeepCopy()
+	if err := unstructured.SetNestedField(newObj.Object, phase, "status", "phase"); err != nil {
+		panic(fmt.Errorf("Failed to set PodGroup phase: %v", err))
+	}
+	if _, err := resource.client.Namespace(obj.GetNamespace()).UpdateStatus(
+		newObj, meta.UpdateOptions{}); err != nil {
+		panic(fmt.Errorf("Failed to update PodGroup status: %v", err))
+	}
+	klog.Infof(logPfx+"Updated to phase %v", phase)
+}
+
+// Map the AffinityGroupState to the PodGroup phase of the kind, or "" if it
+// should not be updated.
+func getPodGroupPhase(kind si.PodGroupKind, state si.AffinityGroupState) string {
+	switch state {
+	case "Allocated":
+		if kind == si.PodGroupKindVolcano {
+			return "Running"
+		}
+		return "Scheduled"
+	case "Preempting":
+		if kind == si.PodGroupKindVolcano {
+			return "Inqueue"
+		}
+		return "Scheduling"
+	default:
+		return ""
+	}
+}
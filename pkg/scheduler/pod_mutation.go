@@ -0,0 +1,80 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"fmt"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/common"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// Inject the namespace default PodSchedulingSpec into the Pod at its creation
+// time, so that the users need not to specify it for each Pod, see
+// NamespaceDefaultSpec.
+// It returns the JSONPatch to mutate the Pod, which is empty if the Pod needs
+// not to be mutated.
+func (s *HivedScheduler) mutatePodRoutine(pod *core.Pod) []si.JsonPatchOperation {
+	logPfx := fmt.Sprintf("[%v]: mutatePodRoutine: ", internal.Key(pod))
+	klog.Infof(logPfx + "Started")
+	defer internal.HandleRoutinePanic(logPfx)
+
+	patches := []si.JsonPatchOperation{}
+	_, hasSpec := pod.Annotations[si.AnnotationKeyPodSchedulingSpec]
+	_, hasGroup := pod.Annotations[si.AnnotationKeyPodAffinityGroup]
+	if !hasSpec && !hasGroup {
+		defaults, ok := (*s.sConfig.NamespaceDefaults)[pod.Namespace]
+		if !ok {
+			return patches
+		}
+		leafCellNumber := internal.ExtractPodLeafCellNumber(pod, defaults.LeafCellResourceName)
+		if leafCellNumber <= 0 {
+			return patches
+		}
+
+		podSchedulingSpec := si.PodSchedulingSpec{
+			VirtualCluster:          defaults.VirtualCluster,
+			Priority:                defaults.Priority,
+			LeafCellType:            defaults.LeafCellType,
+			LeafCellNumber:          leafCellNumber,
+			IgnoreK8sSuggestedNodes: true,
+		}
+		if owner := meta.GetControllerOf(pod); owner != nil {
+			if podNumber := s.getControllerPodNumber(pod.Namespace, owner); podNumber > 0 {
+				podSchedulingSpec.AffinityGroup = internal.NewOwnerAffinityGroupSpec(
+					owner, podNumber, leafCellNumber)
+			}
+		}
+		patches = append(patches, internal.NewPodAnnotationPatch(
+			pod, si.AnnotationKeyPodSchedulingSpec, common.ToYaml(podSchedulingSpec)))
+	}
+
+	if !internal.IsHivedEnabled(pod) {
+		patches = append(patches, internal.NewPodSchedulingEnablePatch(pod))
+	}
+	return patches
+}
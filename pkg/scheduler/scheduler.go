@@ -23,8 +23,13 @@
 package scheduler
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/microsoft/hivedscheduler/pkg/algorithm"
@@ -34,16 +39,34 @@ import (
 	"github.com/microsoft/hivedscheduler/pkg/webserver"
 	core "k8s.io/api/core/v1"
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamiclister"
 	kubeInformer "k8s.io/client-go/informers"
 	kubeClient "k8s.io/client-go/kubernetes"
+	appsLister "k8s.io/client-go/listers/apps/v1"
+	batchLister "k8s.io/client-go/listers/batch/v1"
 	coreLister "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog"
 	ei "k8s.io/kubernetes/pkg/scheduler/api"
 )
 
+// Max number of the concurrent dry runs, beyond which they are rejected, since
+// each of them builds a new SchedulerAlgorithm.
+const maxConcurrentDryRuns = 1
+
+// The period an affinity group is not allowed to preempt again, after its
+// preemption is canceled by the Admin API.
+const canceledPreemptionBackoff = 5 * time.Minute
+
 // HivedScheduler is the scheduling framework which serves as the bridge between
 // the scheduling algorithm and K8S.
 // It provides the whole cluster scheduling view and the interested pod scheduling
@@ -94,6 +117,31 @@ type HivedScheduler struct {
 	// Pod object provides the bound Pods and bound resource of a Node.
 	podLister coreLister.PodLister
 
+	// AffinityGroup custom resources are synced by the Informer and read by the
+	// Lister, and their status are written by the Client.
+	// They are nil if the AffinityGroupResource is not enabled.
+	affinityGroupClient   dynamic.NamespaceableResourceInterface
+	affinityGroupInformer cache.SharedIndexInformer
+	affinityGroupLister   dynamiclister.Lister
+
+	// VirtualCluster custom resources are synced by the Informer and read by the
+	// Lister, and their status are written by the Client.
+	// They are nil if the VirtualClusterResource is not enabled.
+	virtualClusterClient   dynamic.NamespaceableResourceInterface
+	virtualClusterInformer cache.SharedIndexInformer
+	virtualClusterLister   dynamiclister.Lister
+
+	// PodGroup custom resources of each PodGroupKind, see podGroupResource.
+	// It is empty if no PodGroupKinds is enabled.
+	podGroupResources map[si.PodGroupKind]*podGroupResource
+
+	// Job and StatefulSet objects provide the affinity group size of their Pods.
+	// They are nil if the OwnerAffinityGroup is not enabled.
+	jobInformer         cache.SharedIndexInformer
+	statefulSetInformer cache.SharedIndexInformer
+	jobLister           batchLister.JobLister
+	statefulSetLister   appsLister.StatefulSetLister
+
 	// WebServer is used to interact with K8S Default Scheduler and others.
 	//
 	// Platform Error Panic in WebServer Callbacks will be recovered, since generally
@@ -101,10 +149,22 @@ type HivedScheduler struct {
 	// whole scheduling.
 	webServer *webserver.WebServer
 
+	// EventRecorder is used to expose the scheduling decisions to users by K8S
+	// Events and Pod conditions.
+	eventRecorder *internal.EventRecorder
+
+	// LeaderElector is used to elect the only replica to serve the scheduling
+	// requests, while other replicas keep their scheduling view synced as hot
+	// standby.
+	// It is nil if the LeaderElection is disabled, i.e. current replica is always
+	// the leader.
+	leaderElector *leaderelection.LeaderElector
+
 	// SchedulerLock is used to protect the PodScheduleStatuses and its derived
 	// scheduling view inside the SchedulerAlgorithm.
 	// It also ensures the SchedulerAlgorithm.Schedule() will never be executed
-	// concurrently.
+	// concurrently, and the SchedulerAlgorithm will never be replaced during
+	// scheduling.
 	schedulerLock *sync.RWMutex
 
 	// PodScheduleStatuses serves as the ground truth of the scheduling view.
@@ -114,9 +174,53 @@ type HivedScheduler struct {
 	// it up later.
 	podScheduleStatuses internal.PodScheduleStatuses
 
+	// PhysicalCluster is the physical cluster spec used by SchedulerAlgorithm.
+	// It is the same as the one in sConfig, unless PhysicalClusterDiscovery is
+	// enabled, in which case it is discovered from all Nodes at startup.
+	physicalCluster *si.PhysicalClusterSpec
+
 	// SchedulerAlgorithm is used to make the pod schedule decision based on the
 	// scheduling view.
+	// It is initialized after the NodeInformer is synced, so that the physical
+	// cluster can be discovered from the Nodes.
+	// It is replaced by a new one once the VirtualClusters are changed.
 	schedulerAlgorithm internal.SchedulerAlgorithm
+
+	// The cells drained or marked as bad by the Admin API, which are replayed to
+	// the new SchedulerAlgorithm once it is replaced.
+	drainingCells common.Set
+	badCells      common.Set
+	// The affinity groups whose preemptions are canceled by the Admin API,
+	// mapped to the time until which they are not allowed to preempt again.
+	canceledPreemptions map[string]time.Time
+
+	// VirtualClusters is the VCs currently used by the SchedulerAlgorithm.
+	// It is the same as the one in sConfig, unless VirtualClusterResource is
+	// enabled, in which case it may be overridden by the custom resources.
+	virtualClusters map[si.VirtualClusterName]si.VirtualClusterSpec
+	// The errors why the latest VirtualClusters are rejected to be applied, by
+	// the names of the rejected ones.
+	virtualClusterApplyErrors map[si.VirtualClusterName]string
+
+	// Metrics of the scheduling routines, see getMetrics.
+	metrics *schedulerMetrics
+
+	// DryRunSlots limits the concurrent dry runs, see dryRunRoutine.
+	dryRunSlots chan struct{}
+	// The number of dry runs ever started, which makes the dry run Pod unique.
+	dryRunCount uint64
+
+	// UsageLedger is used to append the UsageRecords taken from the
+	// SchedulerAlgorithm, see appendUsageRecords.
+	// It is nil if the UsageLedgerFile is not specified.
+	usageLedger internal.UsageLedger
+	// UsageLedgerLock is used to protect below fields.
+	usageLedgerLock sync.Mutex
+	// The SchedulerAlgorithm whose UsageRecords are being taken, or nil if they
+	// should be resynced next time.
+	usageRecordingAlgorithm internal.SchedulerAlgorithm
+	// The UsageRecords taken but failed to be appended.
+	pendingUsageRecords []si.UsageRecord
 }
 
 func NewHivedScheduler() *HivedScheduler {
@@ -144,37 +248,21 @@ func NewHivedScheduler() *HivedScheduler {
 		podInformer:         podInformer,
 		nodeLister:          nodeLister,
 		podLister:           podLister,
+		eventRecorder:       internal.NewEventRecorder(kClient),
 		schedulerLock:       &sync.RWMutex{},
 		podScheduleStatuses: internal.PodScheduleStatuses{},
-		schedulerAlgorithm:  algorithm.NewHivedAlgorithm(sConfig),
+		physicalCluster:     sConfig.PhysicalCluster,
+		drainingCells:       common.NewSet(),
+		badCells:            common.NewSet(),
+		canceledPreemptions: map[string]time.Time{},
+		metrics:             newSchedulerMetrics(),
+		dryRunSlots:         make(chan struct{}, maxConcurrentDryRuns),
 	}
 
-	// Setup Informer Callbacks
-	s.nodeInformer.AddEventHandler(
-		cache.ResourceEventHandlerFuncs{
-			AddFunc:    s.addNode,
-			UpdateFunc: s.updateNode,
-			DeleteFunc: s.deleteNode,
-		},
-	)
-
-	s.podInformer.AddEventHandler(
-		cache.FilteringResourceEventHandler{
-			FilterFunc: func(obj interface{}) bool {
-				pod := internal.ToPod(obj)
-				return internal.IsInterested(pod)
-			},
-			Handler: cache.ResourceEventHandlerFuncs{
-				AddFunc:    s.addPod,
-				UpdateFunc: s.updatePod,
-				DeleteFunc: s.deletePod,
-			},
-		},
-	)
-
 	// Setup WebServer Callbacks
 	s.webServer = webserver.NewWebServer(
 		sConfig,
+		kClient,
 		internal.ExtenderHandlers{
 			FilterHandler:  s.filterRoutine,
 			BindHandler:    s.bindRoutine,
@@ -183,16 +271,94 @@ func NewHivedScheduler() *HivedScheduler {
 		internal.InspectHandlers{
 			GetAllAffinityGroupsHandler:        s.getAllAffinityGroups,
 			GetAffinityGroupHandler:            s.getAffinityGroup,
+			ExplainAffinityGroupHandler:        s.explainAffinityGroup,
+			GetAllPodScheduleStatusesHandler:   s.getAllPodScheduleStatuses,
+			GetPodScheduleStatusHandler:        s.getPodScheduleStatus,
 			GetClusterStatusHandler:            s.getClusterStatus,
 			GetPhysicalClusterStatusHandler:    s.getPhysicalClusterStatus,
 			GetAllVirtualClustersStatusHandler: s.getAllVirtualClustersStatus,
 			GetVirtualClusterStatusHandler:     s.getVirtualClusterStatus,
+			GetClusterSummaryHandler:           s.getClusterSummary,
+			GetPhysicalClusterSpecHandler:      s.getPhysicalClusterSpec,
+			GetLeaderElectionStatusHandler:     s.getLeaderElectionStatus,
+			GetMetricsHandler:                  s.getMetrics,
+			DryRunHandler:                      s.dryRunRoutine,
+			WatchHandler:                       s.watch,
+			GetUsageReportHandler:              s.getUsageReport,
+		},
+		internal.AdminHandlers{
+			SetCellDrainingHandler:               s.setCellDraining,
+			SetCellBadHandler:                    s.setCellBad,
+			CancelAffinityGroupPreemptionHandler: s.cancelAffinityGroupPreemption,
+			SetAffinityGroupLazyPreemptedHandler: s.setAffinityGroupLazyPreempted,
+		},
+		internal.AdmissionHandlers{
+			ValidatePodHandler: s.validatePodRoutine,
+			MutatePodHandler:   s.mutatePodRoutine,
 		},
 	)
 
+	if sConfig.LeaderElection != nil {
+		s.leaderElector = s.newLeaderElector()
+	}
+	if *sConfig.AffinityGroupResourceEnable {
+		s.initAffinityGroupResource(internal.CreateDynamicClient(kConfig))
+	}
+	if *sConfig.VirtualClusterResourceEnable {
+		s.initVirtualClusterResource(internal.CreateDynamicClient(kConfig))
+	}
+	if len(*sConfig.PodGroupKinds) > 0 {
+		s.initPodGroupResources(internal.CreateDynamicClient(kConfig))
+	}
+	if *sConfig.OwnerAffinityGroupEnable {
+		s.initOwnerAffinityGroup(kClient)
+	}
+	if sConfig.UsageLedgerFile != nil {
+		s.initUsageLedger()
+	}
+
 	return s
 }
 
+func (s *HivedScheduler) newLeaderElector() *leaderelection.LeaderElector {
+	l := s.sConfig.LeaderElection
+	lock, err := resourcelock.New(
+		resourcelock.LeasesResourceLock,
+		l.LeaseNamespace,
+		l.LeaseName,
+		s.kClient.CoreV1(),
+		s.kClient.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: l.Identity})
+	if err != nil {
+		panic(fmt.Errorf("Failed to create LeaderElection lock: %v", err))
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: time.Duration(*l.LeaseDurationSec) * time.Second,
+		RenewDeadline: time.Duration(*l.RenewDeadlineSec) * time.Second,
+		RetryPeriod:   time.Duration(*l.RetryPeriodSec) * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				klog.Infof("[%v]: Started leading, start to serve scheduling requests", l.Identity)
+			},
+			OnStoppedLeading: func() {
+				// The scheduling view may be diverged from the new leader, such as the
+				// binding Pods, so exit and restart as a standby replica.
+				panic(fmt.Errorf("[%v]: Stopped leading, exiting ...", l.Identity))
+			},
+			OnNewLeader: func(identity string) {
+				klog.Infof("[%v]: Observed leader: %v", l.Identity, identity)
+			},
+		},
+		Name: si.ComponentName,
+	})
+	if err != nil {
+		panic(fmt.Errorf("Failed to create LeaderElector: %v", err))
+	}
+	return le
+}
+
 func (s *HivedScheduler) Run(stopCh <-chan struct{}) {
 	defer klog.Errorf("Stopping " + si.ComponentName)
 	defer runtime.HandleCrash()
@@ -200,6 +366,56 @@ func (s *HivedScheduler) Run(stopCh <-chan struct{}) {
 	klog.Infof("Recovering " + si.ComponentName)
 
 	go s.nodeInformer.Run(stopCh)
+	if !cache.WaitForCacheSync(
+		stopCh,
+		s.nodeInformer.HasSynced) {
+		panic(fmt.Errorf("Failed to WaitForCacheSync"))
+	}
+
+	// VirtualClusters should be synced before the SchedulerAlgorithm is
+	// initialized, so that it can use them at the first place.
+	if s.virtualClusterInformer != nil {
+		go s.virtualClusterInformer.Run(stopCh)
+		if !cache.WaitForCacheSync(
+			stopCh,
+			s.virtualClusterInformer.HasSynced) {
+			panic(fmt.Errorf("Failed to WaitForCacheSync"))
+		}
+	}
+
+	s.initSchedulerAlgorithm()
+
+	// AffinityGroups should be synced before Pods, so that the bound Pods
+	// referencing them can be recovered.
+	if s.affinityGroupInformer != nil {
+		go s.affinityGroupInformer.Run(stopCh)
+		if !cache.WaitForCacheSync(
+			stopCh,
+			s.affinityGroupInformer.HasSynced) {
+			panic(fmt.Errorf("Failed to WaitForCacheSync"))
+		}
+	}
+	// So as PodGroups, Jobs and StatefulSets, so that the Pods without bind info can be
+	// resolved.
+	for _, resource := range s.podGroupResources {
+		go resource.informer.Run(stopCh)
+		if !cache.WaitForCacheSync(
+			stopCh,
+			resource.informer.HasSynced) {
+			panic(fmt.Errorf("Failed to WaitForCacheSync"))
+		}
+	}
+	if s.jobInformer != nil {
+		go s.jobInformer.Run(stopCh)
+		go s.statefulSetInformer.Run(stopCh)
+		if !cache.WaitForCacheSync(
+			stopCh,
+			s.jobInformer.HasSynced,
+			s.statefulSetInformer.HasSynced) {
+			panic(fmt.Errorf("Failed to WaitForCacheSync"))
+		}
+	}
+
 	go s.podInformer.Run(stopCh)
 	if !cache.WaitForCacheSync(
 		stopCh,
@@ -209,14 +425,151 @@ func (s *HivedScheduler) Run(stopCh <-chan struct{}) {
 	}
 
 	// Previous bound pods recovery completed, start to accept scheduling request.
+	// For a standby replica, the scheduling view will keep on syncing, so that
+	// it can accept scheduling request once it gains leadership.
+	go s.eventRecorder.Run(stopCh)
 	s.webServer.AsyncRun(stopCh)
+	if s.leaderElector != nil {
+		go s.runLeaderElection(stopCh)
+	}
+	if s.affinityGroupInformer != nil {
+		go wait.Until(s.syncAffinityGroupStatuses, affinityGroupStatusSyncPeriod, stopCh)
+	}
+	if len(s.podGroupResources) > 0 {
+		go wait.Until(s.syncPodGroupStatuses, podGroupStatusSyncPeriod, stopCh)
+	}
+	if s.virtualClusterInformer != nil {
+		go wait.Until(s.syncVirtualClusterStatuses, virtualClusterStatusSyncPeriod, stopCh)
+	}
+	if s.usageLedger != nil {
+		go wait.Until(s.syncUsageLedger, usageLedgerSyncPeriod, stopCh)
+	}
 	klog.Infof("Running " + si.ComponentName)
 
 	<-stopCh
 }
 
+func (s *HivedScheduler) runLeaderElection(stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	klog.Infof("[%v]: Running LeaderElection as standby", s.sConfig.LeaderElection.Identity)
+	s.leaderElector.Run(ctx)
+}
+
+func (s *HivedScheduler) isLeader() bool {
+	return s.leaderElector == nil || s.leaderElector.IsLeader()
+}
+
+// Only the leader can serve the scheduling requests.
+func (s *HivedScheduler) leaderAdmissionCheck() {
+	if !s.isLeader() {
+		panic(si.NewWebServerError(http.StatusServiceUnavailable, fmt.Sprintf(
+			"Current replica %v is standby, the leader is %v",
+			s.sConfig.LeaderElection.Identity, s.leaderElector.GetLeader())))
+	}
+}
+
+func (s *HivedScheduler) initSchedulerAlgorithm() {
+	if s.sConfig.PhysicalClusterDiscovery != nil {
+		nodes, err := s.nodeLister.List(labels.Everything())
+		if err != nil {
+			panic(fmt.Errorf("Failed to list Nodes: %v", err))
+		}
+		s.physicalCluster = internal.DiscoverPhysicalCluster(
+			s.sConfig.PhysicalClusterDiscovery, s.sConfig.PhysicalCluster, nodes)
+		klog.Infof("With Discovered PhysicalCluster: \n%v", common.ToYaml(s.physicalCluster))
+	}
+
+	// Fall back to the config for the rejected VirtualClusters, so that the
+	// scheduler can still start.
+	var schedulerAlgorithm internal.SchedulerAlgorithm
+	s.virtualClusters, schedulerAlgorithm, s.virtualClusterApplyErrors = s.tryChangeVirtualClusters(
+		*s.sConfig.VirtualClusters, s.getDesiredVirtualClusters(), s.tryNewSchedulerAlgorithm)
+	for vcn, err := range s.virtualClusterApplyErrors {
+		klog.Warningf("[%v]: Rejected to apply VirtualCluster: %v", vcn, err)
+	}
+	if schedulerAlgorithm == nil {
+		schedulerAlgorithm = s.newSchedulerAlgorithm(s.virtualClusters)
+	}
+	if sa, ok := schedulerAlgorithm.(internal.WatchingSchedulerAlgorithm); ok {
+		// Start from the time the scheduler starts, so that the versions got from a
+		// previous scheduler process will be rejected instead of being resumed
+		// wrongly. Afterwards, the versions are only carried over and increased.
+		sa.StartWatchFrom(uint64(time.Now().UnixNano()))
+	}
+	s.schedulerAlgorithm = schedulerAlgorithm
+
+	// Setup Informer Callbacks
+	// The existing Nodes will also be delivered to the callbacks even if the
+	// NodeInformer is already synced.
+	s.nodeInformer.AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    s.addNode,
+			UpdateFunc: s.updateNode,
+			DeleteFunc: s.deleteNode,
+		},
+	)
+
+	s.podInformer.AddEventHandler(
+		cache.FilteringResourceEventHandler{
+			FilterFunc: func(obj interface{}) bool {
+				pod := internal.ToPod(obj)
+				return internal.IsInterested(pod)
+			},
+			Handler: cache.ResourceEventHandlerFuncs{
+				AddFunc:    s.addPod,
+				UpdateFunc: s.updatePod,
+				DeleteFunc: s.deletePod,
+			},
+		},
+	)
+
+	if s.virtualClusterInformer != nil {
+		// The existing VirtualClusters will also be delivered, but they are just
+		// the same as the applied ones.
+		s.virtualClusterInformer.AddEventHandler(
+			cache.ResourceEventHandlerFuncs{
+				AddFunc:    func(obj interface{}) { s.applyVirtualClusters() },
+				UpdateFunc: func(oldObj, newObj interface{}) { s.applyVirtualClusters() },
+				DeleteFunc: func(obj interface{}) { s.applyVirtualClusters() },
+			},
+		)
+	}
+}
+
+func (s *HivedScheduler) newSchedulerAlgorithm(
+	virtualClusters map[si.VirtualClusterName]si.VirtualClusterSpec) internal.SchedulerAlgorithm {
+	aConfig := *s.sConfig
+	aConfig.PhysicalCluster = s.physicalCluster
+	aConfig.VirtualClusters = &virtualClusters
+	return algorithm.NewHivedAlgorithm(&aConfig)
+}
+
+// The same as newSchedulerAlgorithm except that the error is returned instead
+// of panic, such as the VirtualClusters cannot be guaranteed by the physical
+// cluster.
+func (s *HivedScheduler) tryNewSchedulerAlgorithm(
+	virtualClusters map[si.VirtualClusterName]si.VirtualClusterSpec) (
+	sa internal.SchedulerAlgorithm, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	return s.newSchedulerAlgorithm(virtualClusters), nil
+}
+
 func (s *HivedScheduler) addNode(obj interface{}) {
 	node := internal.ToNode(obj)
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	logPfx := fmt.Sprintf("[%v]: addNode: ", node.Name)
 	klog.Infof(logPfx + "Started")
 	defer internal.HandleInformerPanic(logPfx, true)
@@ -235,6 +588,9 @@ func (s *HivedScheduler) updateNode(oldObj, newObj interface{}) {
 		return
 	}
 
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	logPfx := fmt.Sprintf("[%v]: updateNode: ", newNode.Name)
 	defer internal.HandleInformerPanic(logPfx, false)
 
@@ -243,6 +599,9 @@ func (s *HivedScheduler) updateNode(oldObj, newObj interface{}) {
 
 func (s *HivedScheduler) deleteNode(obj interface{}) {
 	node := internal.ToNode(obj)
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	logPfx := fmt.Sprintf("[%v]: deleteNode: ", node.Name)
 	klog.Infof(logPfx + "Started")
 	defer internal.HandleInformerPanic(logPfx, true)
@@ -293,13 +652,16 @@ func (s *HivedScheduler) deletePod(obj interface{}) {
 
 	podStatus := s.podScheduleStatuses[pod.UID]
 	if podStatus != nil {
+		// Untrack it first, so that it will not be leaked even if its
+		// PodSchedulingSpec cannot be resolved, such as its referenced
+		// AffinityGroup has never existed.
+		delete(s.podScheduleStatuses, pod.UID)
+
 		if internal.IsAllocated(podStatus.PodState) {
 			s.schedulerAlgorithm.DeleteAllocatedPod(podStatus.Pod)
 		} else {
 			s.schedulerAlgorithm.DeleteUnallocatedPod(podStatus.Pod)
 		}
-
-		delete(s.podScheduleStatuses, pod.UID)
 	}
 }
 
@@ -322,12 +684,14 @@ func (s *HivedScheduler) addBoundPod(pod *core.Pod) {
 					PodState:          internal.PodBound,
 					PodScheduleResult: nil,
 				}
+				s.recordPodScheduleStatus(podStatus, s.podScheduleStatuses[pod.UID])
 			}
 			return
 		}
 	}
 
 	// Recover bound pod.
+	pod = s.resolvePod(pod)
 	s.schedulerAlgorithm.AddAllocatedPod(pod)
 	s.podScheduleStatuses[pod.UID] = &internal.PodScheduleStatus{
 		Pod:               pod,
@@ -449,16 +813,19 @@ func (s *HivedScheduler) shouldForceBind(
 	// So overall keeps on binding, regardless of potential problematic decision,
 	// is acceptable.
 	if podBindAttempts >= *s.sConfig.ForcePodBindThreshold {
-		klog.Warningf(logPfx+
+		reason := fmt.Sprintf(
 			"The Pod binding has already been tried %v times which reaches the "+
-			"ForcePodBindThreshold %v",
+				"ForcePodBindThreshold %v",
 			podBindAttempts, *s.sConfig.ForcePodBindThreshold)
+		klog.Warningf(logPfx + reason)
+		s.recordForceBinding(pod, reason)
 		return true
 	} else if err := s.validatePodBindInfo(podBindInfo, suggestedNodes); err != nil {
 		// Proactively trigger force bind, if the pod schedule decision has already
 		// been detected to be probably invalid based on current status, to reduce
 		// the binding time.
 		klog.Warningf(logPfx+"%v", err)
+		s.recordForceBinding(pod, err.Error())
 		return true
 	}
 
@@ -483,6 +850,7 @@ func (s *HivedScheduler) forceBindExecutor(bindingPod *core.Pod) {
 }
 
 func (s *HivedScheduler) filterRoutine(args ei.ExtenderArgs) *ei.ExtenderFilterResult {
+	defer s.metrics.filterLatency.ObserveSince(time.Now())
 	s.schedulerLock.Lock()
 	defer s.schedulerLock.Unlock()
 
@@ -496,6 +864,9 @@ func (s *HivedScheduler) filterRoutine(args ei.ExtenderArgs) *ei.ExtenderFilterR
 
 	defer internal.HandleRoutinePanic(logPfx)
 
+	s.leaderAdmissionCheck()
+
+	pod = s.resolvePod(pod)
 	podStatus := s.generalScheduleAdmissionCheck(s.podScheduleStatuses[pod.UID])
 	if podStatus.PodState == internal.PodBinding {
 		// Insist previous bind result, since Pod binding should be idempotent, and
@@ -514,9 +885,11 @@ func (s *HivedScheduler) filterRoutine(args ei.ExtenderArgs) *ei.ExtenderFilterR
 
 	// At this point, podState must be in:
 	// {PodWaiting, PodPreempting}
+	s.authorizePod(pod, podStatus)
 
 	// Carry out a new scheduling
 	result := s.schedulerAlgorithm.Schedule(pod, suggestedNodes, internal.FilteringPhase)
+	s.recordLazyPreemption(pod, result.LazyPreemptedPods)
 
 	if result.PodBindInfo != nil {
 		bindingPod := internal.NewBindingPod(pod, result.PodBindInfo)
@@ -531,6 +904,7 @@ func (s *HivedScheduler) filterRoutine(args ei.ExtenderArgs) *ei.ExtenderFilterR
 			PodState:          internal.PodBinding,
 			PodScheduleResult: &result,
 		}
+		s.recordPodScheduleStatus(podStatus, s.podScheduleStatuses[pod.UID])
 
 		if s.shouldForceBind(s.podScheduleStatuses[pod.UID], suggestedNodes) {
 			go s.forceBindExecutor(bindingPod)
@@ -566,6 +940,7 @@ func (s *HivedScheduler) filterRoutine(args ei.ExtenderArgs) *ei.ExtenderFilterR
 			PodState:          internal.PodWaiting,
 			PodScheduleResult: &result,
 		}
+		s.recordPodScheduleStatus(podStatus, s.podScheduleStatuses[pod.UID])
 
 		// Block the whole scheduling to achieve better FIFO
 		if *s.sConfig.WaitingPodSchedulingBlockMilliSec > 0 {
@@ -576,11 +951,9 @@ func (s *HivedScheduler) filterRoutine(args ei.ExtenderArgs) *ei.ExtenderFilterR
 		// Return fake FailedNodes, so that the waitReason can be exposed along with
 		// other waitReasons generated from K8S Default Scheduler.
 		failedNodes := map[string]string{}
-		waitReason := "Pod is waiting for preemptible or free resource to appear"
-		if result.PodWaitInfo != nil {
-			waitReason += ": " + result.PodWaitInfo.Reason
-		}
-		failedNodes[si.ComponentName] = waitReason
+		waitReason := getWaitReason(&result)
+		failedNodes[si.ComponentName] = fmt.Sprintf(
+			"Reason: %v, Message: %v", getWaitErrorReason(&result), waitReason)
 
 		klog.Infof(logPfx + waitReason)
 		return &ei.ExtenderFilterResult{
@@ -595,6 +968,7 @@ func (s *HivedScheduler) filterRoutine(args ei.ExtenderArgs) *ei.ExtenderFilterR
 //    pod. This ensures that once a specific Pod is allocated by AddAllocatedPod,
 //    its placement will never be changed to another one.
 func (s *HivedScheduler) bindRoutine(args ei.ExtenderBindingArgs) *ei.ExtenderBindingResult {
+	defer s.metrics.bindLatency.ObserveSince(time.Now())
 	s.schedulerLock.RLock()
 	defer s.schedulerLock.RUnlock()
 
@@ -605,6 +979,8 @@ func (s *HivedScheduler) bindRoutine(args ei.ExtenderBindingArgs) *ei.ExtenderBi
 	klog.Infof(logPfx + "Started")
 	defer internal.HandleRoutinePanic(logPfx)
 
+	s.leaderAdmissionCheck()
+
 	podStatus := s.generalScheduleAdmissionCheck(s.podScheduleStatuses[podKey.UID])
 	if podStatus.PodState == internal.PodBinding {
 		bindingPod := podStatus.Pod
@@ -630,6 +1006,7 @@ func (s *HivedScheduler) bindRoutine(args ei.ExtenderBindingArgs) *ei.ExtenderBi
 }
 
 func (s *HivedScheduler) preemptRoutine(args ei.ExtenderPreemptionArgs) *ei.ExtenderPreemptionResult {
+	defer s.metrics.preemptLatency.ObserveSince(time.Now())
 	s.schedulerLock.Lock()
 	defer s.schedulerLock.Unlock()
 
@@ -644,6 +1021,9 @@ func (s *HivedScheduler) preemptRoutine(args ei.ExtenderPreemptionArgs) *ei.Exte
 	klog.Infof(logPfx + "Started")
 	defer internal.HandleRoutinePanic(logPfx)
 
+	s.leaderAdmissionCheck()
+
+	pod = s.resolvePod(pod)
 	podStatus := s.generalScheduleAdmissionCheck(s.podScheduleStatuses[pod.UID])
 	if podStatus.PodState == internal.PodBinding {
 		// The inconsistency should can be reconciled by K8S Default Scheduler.
@@ -654,6 +1034,18 @@ func (s *HivedScheduler) preemptRoutine(args ei.ExtenderPreemptionArgs) *ei.Exte
 
 	// At this point, podState must be in:
 	// {PodWaiting, PodPreempting}
+	s.authorizePod(pod, podStatus)
+
+	groupName := internal.ExtractPodSchedulingSpec(pod).AffinityGroup.Name
+	if until, ok := s.canceledPreemptions[groupName]; ok {
+		if time.Now().Before(until) {
+			klog.Infof(logPfx+
+				"Pod is not allowed to preempt until %v, since its preemption was canceled",
+				until.Format(time.RFC3339))
+			return &ei.ExtenderPreemptionResult{}
+		}
+		delete(s.canceledPreemptions, groupName)
+	}
 
 	// If the podState is PodWaiting:
 	// Maybe filterRoutine will never be called by K8S Default Scheduler, but only
@@ -670,6 +1062,7 @@ func (s *HivedScheduler) preemptRoutine(args ei.ExtenderPreemptionArgs) *ei.Exte
 	// So, in either case, we need to schedule again with more suggestedNodes, as
 	// lower priority Pods are ignored by K8S Default Scheduler now.
 	result := s.schedulerAlgorithm.Schedule(pod, suggestedNodes, internal.PreemptingPhase)
+	s.recordLazyPreemption(pod, result.LazyPreemptedPods)
 
 	if result.PodBindInfo != nil {
 		klog.Infof(logPfx+
@@ -682,6 +1075,7 @@ func (s *HivedScheduler) preemptRoutine(args ei.ExtenderPreemptionArgs) *ei.Exte
 			PodState:          internal.PodPreempting,
 			PodScheduleResult: &result,
 		}
+		s.recordPodScheduleStatus(podStatus, s.podScheduleStatuses[pod.UID])
 
 		victims := result.PodPreemptInfo.VictimPods
 		nodesVictims := map[string]*ei.MetaVictims{}
@@ -713,36 +1107,549 @@ func (s *HivedScheduler) preemptRoutine(args ei.ExtenderPreemptionArgs) *ei.Exte
 			PodState:          internal.PodWaiting,
 			PodScheduleResult: &result,
 		}
+		s.recordPodScheduleStatus(podStatus, s.podScheduleStatuses[pod.UID])
 
-		waitReason := "Pod is waiting for preemptible or free resource to appear"
-		if result.PodWaitInfo != nil {
-			waitReason += ": " + result.PodWaitInfo.Reason
-		}
+		waitReason := getWaitReason(&result)
 		klog.Infof(logPfx + waitReason)
 		return &ei.ExtenderPreemptionResult{}
 	}
 }
 
+// Validate the Pod at its creation time, so that the bad Pod is rejected early,
+// instead of keeping pending with the schedule error.
+// It is also served by the standby replicas, since their scheduling view is
+// also synced.
+func (s *HivedScheduler) validatePodRoutine(pod *core.Pod) {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	logPfx := fmt.Sprintf("[%v]: validatePodRoutine: ", internal.Key(pod))
+	klog.Infof(logPfx + "Started")
+	defer internal.HandleRoutinePanic(logPfx)
+
+	if !internal.IsHivedEnabled(pod) {
+		return
+	}
+
+	pod = s.resolvePod(pod)
+	internal.ExtractPodSchedulingSpec(pod)
+	s.authorizePod(pod, nil)
+	if algorithm, ok := s.schedulerAlgorithm.(internal.ValidatingSchedulerAlgorithm); ok {
+		algorithm.ValidatePod(pod)
+	}
+}
+
+func (s *HivedScheduler) listNodeNames() []string {
+	nodes, err := s.nodeLister.List(labels.Everything())
+	if err != nil {
+		panic(fmt.Errorf("Failed to list Nodes: %v", err))
+	}
+	nodeNames := []string{}
+	for _, node := range nodes {
+		nodeNames = append(nodeNames, node.Name)
+	}
+	return nodeNames
+}
+
+// Tell what would happen if a Pod with the PodSchedulingSpec were scheduled now,
+// without changing any state.
+// Since scheduling changes the state of the SchedulerAlgorithm, such as creating
+// preemptions and lazy preemptions, the Pod is scheduled in a throwaway snapshot
+// of it, whose scheduling view is recovered in the same way as the scheduler
+// restarts, together with the administrated status, and then the ongoing
+// preemptions are scheduled again in it.
+// The live state is copied under the schedulerLock, but the snapshot is built
+// out of it, so that the scheduling is not blocked by the dry runs, and the
+// concurrent dry runs are limited since each of them is costly.
+func (s *HivedScheduler) dryRunRoutine(spec string) si.DryRunResult {
+	select {
+	case s.dryRunSlots <- struct{}{}:
+		defer func() { <-s.dryRunSlots }()
+	default:
+		panic(si.NewWebServerError(http.StatusTooManyRequests,
+			"Too many dry runs are in progress, please retry later"))
+	}
+
+	logPfx := "dryRunRoutine: "
+	klog.Infof(logPfx + "Started")
+	defer internal.HandleRoutinePanic(logPfx)
+
+	// The Pod only lives in the snapshot, but its UID is still unique, so that
+	// it is never confused with the others, such as by the decision traces.
+	dryRunID := fmt.Sprintf("dry-run-%v", atomic.AddUint64(&s.dryRunCount, 1))
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Namespace: meta.NamespaceDefault,
+			Name:      dryRunID,
+			UID:       types.UID(dryRunID),
+			Annotations: map[string]string{
+				si.AnnotationKeyPodSchedulingSpec: spec,
+			},
+		},
+	}
+	podSchedulingSpec := internal.ExtractPodSchedulingSpec(pod)
+
+	var virtualClusters map[si.VirtualClusterName]si.VirtualClusterSpec
+	var allocatedPods, preemptingPods []*core.Pod
+	var status administratedStatus
+	func() {
+		s.schedulerLock.RLock()
+		defer s.schedulerLock.RUnlock()
+		virtualClusters = s.virtualClusters
+		allocatedPods = s.listAllocatedPods()
+		for _, podStatus := range s.podScheduleStatuses {
+			if podStatus.PodState == internal.PodPreempting {
+				preemptingPods = append(preemptingPods, podStatus.Pod)
+			}
+		}
+		status = s.getAdministratedStatus()
+	}()
+
+	snapshot := s.newSchedulerAlgorithm(virtualClusters)
+	if sa, ok := snapshot.(internal.StoppableSchedulerAlgorithm); ok {
+		defer sa.Stop()
+	}
+	algorithm, ok := snapshot.(internal.ExplainingSchedulerAlgorithm)
+	if !ok {
+		panic(internal.NewBadRequestError(
+			"Dry run is not supported by current SchedulerAlgorithm"))
+	}
+	s.recoverSchedulerAlgorithm(snapshot, allocatedPods)
+	recoverAdministratedStatus(snapshot, status)
+	nodeNames := s.listNodeNames()
+	for _, preemptingPod := range preemptingPods {
+		func() {
+			defer internal.HandleInformerPanic(fmt.Sprintf(
+				logPfx+"[%v]: Schedule preempting Pod again: ", internal.Key(preemptingPod)), false)
+			snapshot.Schedule(preemptingPod, nodeNames, internal.PreemptingPhase)
+		}()
+	}
+
+	result := snapshot.Schedule(pod, nodeNames, internal.PreemptingPhase)
+	if result.PodBindInfo != nil {
+		snapshot.AddAllocatedPod(internal.NewBindingPod(pod, result.PodBindInfo))
+	}
+
+	attempts := algorithm.ExplainAffinityGroup(podSchedulingSpec.AffinityGroup.Name).Attempts
+	dryRunResult := si.DryRunResult{Attempt: attempts[len(attempts)-1]}
+	if result.PodBindInfo != nil || result.PodPreemptInfo != nil {
+		group := snapshot.GetAffinityGroup(podSchedulingSpec.AffinityGroup.Name)
+		dryRunResult.AffinityGroup = &group
+	}
+	for _, lazyPreemptedPod := range result.LazyPreemptedPods {
+		dryRunResult.LazyPreemptedPods = append(
+			dryRunResult.LazyPreemptedPods, internal.Key(lazyPreemptedPod))
+	}
+	return dryRunResult
+}
+
+func getWaitReason(result *internal.PodScheduleResult) string {
+	waitReason := "Pod is waiting for preemptible or free resource to appear"
+	if result != nil && result.PodWaitInfo != nil {
+		waitReason += ": " + result.PodWaitInfo.Reason
+	}
+	return waitReason
+}
+
+func getWaitErrorReason(result *internal.PodScheduleResult) si.ErrorReason {
+	if result != nil && result.PodWaitInfo != nil && result.PodWaitInfo.ErrorReason != "" {
+		return result.PodWaitInfo.ErrorReason
+	}
+	return si.ErrorReasonCapacityExhausted
+}
+
+// getPodScheduleMessage describes the decision of the PodScheduleStatus.
+func getPodScheduleMessage(podStatus *internal.PodScheduleStatus) string {
+	switch podStatus.PodState {
+	case internal.PodWaiting:
+		return getWaitReason(podStatus.PodScheduleResult)
+	case internal.PodPreempting:
+		victims := []string{}
+		for _, victim := range podStatus.PodScheduleResult.PodPreemptInfo.VictimPods {
+			victims = append(victims, internal.Key(victim))
+		}
+		sort.Strings(victims)
+		return fmt.Sprintf("Pod is preempting victim Pods: %v", strings.Join(victims, ", "))
+	case internal.PodBinding:
+		return fmt.Sprintf("Pod is binding to node %v, leaf cells %v",
+			podStatus.Pod.Spec.NodeName,
+			podStatus.Pod.Annotations[si.AnnotationKeyPodLeafCellIsolation])
+	case internal.PodBound:
+		return fmt.Sprintf("Pod is bound to node %v", podStatus.Pod.Spec.NodeName)
+	default:
+		return ""
+	}
+}
+
+// Authorize the VC and priority usage of the Pod, see AccessRuleSpec.
+// The denial during scheduling is also exposed by the Pod Event, which is only
+// recorded once, since the Pod may be scheduled again and again with the same
+// denial.
+func (s *HivedScheduler) authorizePod(pod *core.Pod, podStatus *internal.PodScheduleStatus) {
+	if s.sConfig.AccessRules == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			message := internal.AsWebServerError(r).Message
+			if podStatus != nil && podStatus.UnauthorizedMessage != message && s.isLeader() {
+				podStatus.UnauthorizedMessage = message
+				s.eventRecorder.PodEventf(pod, core.EventTypeWarning, si.EventReasonUnauthorized, "%v", message)
+			}
+			panic(r)
+		}
+	}()
+	internal.AuthorizePod(*s.sConfig.AccessRules, pod)
+}
+
+// Expose the decision of the new PodScheduleStatus to users by the PodState
+// condition and K8S Events.
+// The same Pod may be scheduled again and again with the same decision, so it
+// is only recorded if the decision changed, to avoid flooding ApiServer.
+// And it is only recorded by the leader, since the standby replicas also see
+// the Pods transitioned to PodBound.
+func (s *HivedScheduler) recordPodScheduleStatus(
+	oldStatus *internal.PodScheduleStatus, newStatus *internal.PodScheduleStatus) {
+	if !s.isLeader() {
+		return
+	}
+
+	pod := newStatus.Pod
+	message := getPodScheduleMessage(newStatus)
+	if oldStatus != nil && oldStatus.PodState == newStatus.PodState &&
+		getPodScheduleMessage(oldStatus) == message {
+		return
+	}
+
+	s.eventRecorder.SetPodStateCondition(pod, newStatus.PodState, message)
+	if newStatus.PodState == internal.PodWaiting {
+		s.eventRecorder.PodEventf(pod, core.EventTypeNormal, si.EventReasonWaiting, "%v", message)
+	} else if newStatus.PodState == internal.PodPreempting {
+		s.eventRecorder.PodEventf(pod, core.EventTypeNormal, si.EventReasonPreempting, "%v", message)
+
+		nodesVictims := map[string][]string{}
+		for _, victim := range newStatus.PodScheduleResult.PodPreemptInfo.VictimPods {
+			node := victim.Spec.NodeName
+			nodesVictims[node] = append(nodesVictims[node], internal.Key(victim))
+			s.eventRecorder.PodEventf(victim, core.EventTypeWarning, si.EventReasonPreempted,
+				"Pod is preempted by Pod %v", internal.Key(pod))
+		}
+		for node, victims := range nodesVictims {
+			sort.Strings(victims)
+			s.eventRecorder.NodeEventf(node, core.EventTypeNormal, si.EventReasonPreempting,
+				"Pod %v is preempting victim Pods on the node: %v",
+				internal.Key(pod), strings.Join(victims, ", "))
+		}
+	}
+}
+
+func (s *HivedScheduler) recordLazyPreemption(preemptor *core.Pod, victims []*core.Pod) {
+	for _, victim := range victims {
+		s.eventRecorder.PodEventf(victim, core.EventTypeWarning, si.EventReasonLazyPreempted,
+			"Pod is lazy preempted to be opportunistic by Pod %v, but it keeps running "+
+				"on node %v", internal.Key(preemptor), victim.Spec.NodeName)
+	}
+}
+
+func (s *HivedScheduler) recordForceBinding(pod *core.Pod, reason string) {
+	s.metrics.forceBindCount++
+	s.eventRecorder.PodEventf(pod, core.EventTypeWarning, si.EventReasonForceBinding,
+		"Pod is force bound to node %v: %v", pod.Spec.NodeName, reason)
+	s.eventRecorder.NodeEventf(pod.Spec.NodeName, core.EventTypeWarning, si.EventReasonForceBinding,
+		"Pod %v is force bound to the node: %v", internal.Key(pod), reason)
+}
+
 func (s *HivedScheduler) getAllAffinityGroups() si.AffinityGroupList {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	return s.schedulerAlgorithm.GetAllAffinityGroups()
 }
 
 func (s *HivedScheduler) getAffinityGroup(name string) si.AffinityGroup {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	return s.schedulerAlgorithm.GetAffinityGroup(name)
 }
 
+func (s *HivedScheduler) explainAffinityGroup(name string) si.AffinityGroupExplanation {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	if algorithm, ok := s.schedulerAlgorithm.(internal.ExplainingSchedulerAlgorithm); ok {
+		return algorithm.ExplainAffinityGroup(name)
+	}
+	panic(internal.NewBadRequestError(
+		"Explaining affinity group is not supported by current SchedulerAlgorithm"))
+}
+
+func (s *HivedScheduler) getAllPodScheduleStatuses(
+	namespace string, state internal.PodState) si.PodScheduleStatusList {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	pss := si.PodScheduleStatusList{Items: []si.PodScheduleStatus{}}
+	for _, podStatus := range s.podScheduleStatuses {
+		if (namespace == "" || podStatus.Pod.Namespace == namespace) &&
+			(state == "" || podStatus.PodState == state) {
+			pss.Items = append(pss.Items, toPodScheduleStatus(podStatus))
+		}
+	}
+	sort.Slice(pss.Items, func(i, j int) bool {
+		a, b := pss.Items[i], pss.Items[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+	return pss
+}
+
+func (s *HivedScheduler) getPodScheduleStatus(uid types.UID) si.PodScheduleStatus {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	if podStatus := s.podScheduleStatuses[uid]; podStatus != nil {
+		return toPodScheduleStatus(podStatus)
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"Pod %v does not exist since it is not tracked by the scheduler, "+
+			"such as it is completed or not informed yet", uid)))
+}
+
+func toPodScheduleStatus(podStatus *internal.PodScheduleStatus) si.PodScheduleStatus {
+	pod := podStatus.Pod
+	ps := si.PodScheduleStatus{
+		Namespace:           pod.Namespace,
+		Name:                pod.Name,
+		UID:                 pod.UID,
+		State:               string(podStatus.PodState),
+		BindAttempts:        podStatus.PodBindAttempts,
+		UnauthorizedMessage: podStatus.UnauthorizedMessage,
+	}
+	func() {
+		// The PodSchedulingSpec of a Waiting Pod may be invalid.
+		var err error
+		defer internal.RecoverAsError(&err)
+		ps.AffinityGroup = internal.ExtractPodSchedulingSpec(pod).AffinityGroup.Name
+	}()
+	if result := podStatus.PodScheduleResult; result != nil {
+		if result.PodWaitInfo != nil {
+			ps.WaitReason = result.PodWaitInfo.Reason
+			ps.WaitErrorReason = getWaitErrorReason(result)
+		}
+		if result.PodPreemptInfo != nil {
+			ps.WaitErrorReason = si.ErrorReasonPreempting
+			for _, victim := range result.PodPreemptInfo.VictimPods {
+				ps.PreemptionVictims = append(ps.PreemptionVictims, internal.Key(victim))
+			}
+		}
+		ps.BindInfo = result.PodBindInfo
+		for _, p := range result.LazyPreemptedPods {
+			ps.LazyPreemptedPods = append(ps.LazyPreemptedPods, internal.Key(p))
+		}
+	}
+	return ps
+}
+
 func (s *HivedScheduler) getClusterStatus() si.ClusterStatus {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	return s.schedulerAlgorithm.GetClusterStatus()
 }
 
 func (s *HivedScheduler) getPhysicalClusterStatus() si.PhysicalClusterStatus {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	return s.schedulerAlgorithm.GetPhysicalClusterStatus()
 }
 
 func (s *HivedScheduler) getAllVirtualClustersStatus() map[si.VirtualClusterName]si.VirtualClusterStatus {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	return s.schedulerAlgorithm.GetAllVirtualClustersStatus()
 }
 
 func (s *HivedScheduler) getVirtualClusterStatus(vcn si.VirtualClusterName) si.VirtualClusterStatus {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
 	return s.schedulerAlgorithm.GetVirtualClusterStatus(vcn)
 }
+
+func (s *HivedScheduler) getClusterSummary() si.ClusterSummary {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	if algorithm, ok := s.schedulerAlgorithm.(internal.SummarizingSchedulerAlgorithm); ok {
+		return algorithm.GetClusterSummary()
+	}
+	panic(internal.NewBadRequestError(
+		"Summarizing cluster is not supported by current SchedulerAlgorithm"))
+}
+
+func (s *HivedScheduler) watch(resourceVersion uint64) (
+	events []si.WatchEvent, latestResourceVersion uint64, newer <-chan struct{}) {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	if algorithm, ok := s.schedulerAlgorithm.(internal.WatchingSchedulerAlgorithm); ok {
+		return algorithm.Watch(resourceVersion)
+	}
+	panic(internal.NewBadRequestError(
+		"Watching is not supported by current SchedulerAlgorithm"))
+}
+
+func (s *HivedScheduler) getPhysicalClusterSpec() si.PhysicalClusterSpec {
+	return *s.physicalCluster
+}
+
+func (s *HivedScheduler) getLeaderElectionStatus() si.LeaderElectionStatus {
+	status := si.LeaderElectionStatus{Role: si.LeaderElectionRoleStandby}
+	if s.isLeader() {
+		status.Role = si.LeaderElectionRoleLeader
+	}
+	if s.leaderElector != nil {
+		status.Identity = s.sConfig.LeaderElection.Identity
+		status.Leader = s.leaderElector.GetLeader()
+	}
+	return status
+}
+
+func (s *HivedScheduler) setCellDraining(address si.CellAddress, draining bool) {
+	klog.Infof("[%v]: setCellDraining: %v", address, draining)
+	address, nodeNames := func() (si.CellAddress, []string) {
+		s.schedulerLock.Lock()
+		defer s.schedulerLock.Unlock()
+
+		s.schedulerAlgorithm.SetCellDraining(address, draining)
+		found, nodeNames := internal.FindPhysicalCellNodes(
+			s.schedulerAlgorithm.GetPhysicalClusterStatus(), address)
+		setAdminCell(s.drainingCells, found, draining)
+		return found, nodeNames
+	}()
+	// Persist it out of the schedulerLock to avoid blocking the scheduling.
+	s.persistAdminCell(si.AnnotationKeyNodeDrainingCells, address, nodeNames, draining)
+}
+
+// Persist the cell drained or marked as bad by the admin API in the annotation
+// of all the nodes it is in, so that it is replayed from the Nodes once the
+// scheduler restarts or fails over.
+func (s *HivedScheduler) persistAdminCell(
+	annotationKey string, address si.CellAddress, nodeNames []string, set bool) {
+	for _, nodeName := range nodeNames {
+		node, err := s.nodeLister.Get(nodeName)
+		if err != nil {
+			if apiErrors.IsNotFound(err) {
+				// The cell in the Node will be set again once it is added back.
+				continue
+			}
+			panic(fmt.Errorf("Failed to get Node %v from local cache: %v", nodeName, err))
+		}
+
+		oldAddresses := internal.ExtractNodeAdminCells(node, annotationKey)
+		newAddresses := []string{}
+		for _, a := range oldAddresses {
+			if a != address {
+				newAddresses = append(newAddresses, string(a))
+			}
+		}
+		if set {
+			newAddresses = append(newAddresses, string(address))
+		}
+		if len(newAddresses) == len(oldAddresses) {
+			continue
+		}
+
+		var annotation interface{}
+		if len(newAddresses) > 0 {
+			annotation = strings.Join(newAddresses, ",")
+		}
+		patch := common.ToJsonBytes(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]interface{}{
+					annotationKey: annotation,
+				},
+			},
+		})
+		_, err = s.kClient.CoreV1().Nodes().Patch(nodeName, types.MergePatchType, patch)
+		if err != nil {
+			panic(fmt.Errorf("Failed to persist cell %v to annotation %v of Node %v: %v",
+				address, annotationKey, nodeName, err))
+		}
+	}
+}
+
+func (s *HivedScheduler) setCellBad(address si.CellAddress, bad bool) {
+	klog.Infof("[%v]: setCellBad: %v", address, bad)
+	address, nodeNames := func() (si.CellAddress, []string) {
+		s.schedulerLock.Lock()
+		defer s.schedulerLock.Unlock()
+
+		s.administratingSchedulerAlgorithm().SetCellBad(address, bad)
+		found, nodeNames := internal.FindPhysicalCellNodes(
+			s.schedulerAlgorithm.GetPhysicalClusterStatus(), address)
+		setAdminCell(s.badCells, found, bad)
+		return found, nodeNames
+	}()
+	// Persist it out of the schedulerLock to avoid blocking the scheduling.
+	s.persistAdminCell(si.AnnotationKeyNodeBadCells, address, nodeNames, bad)
+}
+
+// Record the cell drained or marked as bad by the Admin API by its address, so
+// that it can be replayed to the new SchedulerAlgorithm.
+func setAdminCell(cells common.Set, address si.CellAddress, set bool) {
+	if set {
+		cells.Add(address)
+	} else {
+		cells.Delete(address)
+	}
+}
+
+func (s *HivedScheduler) cancelAffinityGroupPreemption(groupName string) {
+	klog.Infof("[%v]: cancelAffinityGroupPreemption", groupName)
+	s.schedulerLock.Lock()
+	defer s.schedulerLock.Unlock()
+
+	s.administratingSchedulerAlgorithm().CancelAffinityGroupPreemption(groupName)
+	// Back off the preemption, otherwise the group will just preempt again in the
+	// next scheduling cycle.
+	now := time.Now()
+	for name, until := range s.canceledPreemptions {
+		if !now.Before(until) {
+			delete(s.canceledPreemptions, name)
+		}
+	}
+	s.canceledPreemptions[groupName] = now.Add(canceledPreemptionBackoff)
+	for uid, podStatus := range s.podScheduleStatuses {
+		if podStatus.PodState == internal.PodPreempting &&
+			internal.ExtractPodSchedulingSpec(podStatus.Pod).AffinityGroup.Name == groupName {
+			// The preemption is revoked, so the preempting Pod has to be scheduled
+			// again.
+			s.podScheduleStatuses[uid] = &internal.PodScheduleStatus{
+				Pod:               podStatus.Pod,
+				PodState:          internal.PodWaiting,
+				PodScheduleResult: nil,
+			}
+		}
+	}
+}
+
+func (s *HivedScheduler) setAffinityGroupLazyPreempted(groupName string, lazyPreempted bool) {
+	klog.Infof("[%v]: setAffinityGroupLazyPreempted: %v", groupName, lazyPreempted)
+	s.schedulerLock.Lock()
+	defer s.schedulerLock.Unlock()
+
+	s.administratingSchedulerAlgorithm().SetAffinityGroupLazyPreempted(groupName, lazyPreempted)
+}
+
+func (s *HivedScheduler) administratingSchedulerAlgorithm() internal.AdministratingSchedulerAlgorithm {
+	if algorithm, ok := s.schedulerAlgorithm.(internal.AdministratingSchedulerAlgorithm); ok {
+		return algorithm
+	}
+	panic(internal.NewBadRequestError(
+		"Administrating is not supported by current SchedulerAlgorithm"))
+}
@@ -0,0 +1,1194 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/microsoft/hivedscheduler/pkg/algorithm"
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/common"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeInformer "k8s.io/client-go/informers"
+	kubeClient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ei "k8s.io/kubernetes/pkg/scheduler/api"
+)
+
+const testConfig = `
+physicalCluster:
+  cellTypes:
+    K80-NODE:
+      childCellType: K80
+      childCellNumber: 4
+      isNodeLevel: true
+    2-K80-NODE:
+      childCellType: K80-NODE
+      childCellNumber: 2
+  physicalCells:
+  - cellType: 2-K80-NODE
+    cellChildren:
+    - cellAddress: node1
+    - cellAddress: node2
+virtualClusters:
+  VC1:
+    virtualCells:
+    - cellType: 2-K80-NODE.K80-NODE
+      cellNumber: 2
+`
+
+// fakeApiServer accepts and records all the requests.
+type fakeApiServer struct {
+	lock     sync.Mutex
+	requests map[string]bool
+	// The response body of the requests, default to the success Status.
+	responses map[string]string
+}
+
+func (f *fakeApiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.requests[fmt.Sprintf("%v %v", r.Method, r.URL.Path)] = true
+	event := core.Event{}
+	if json.NewDecoder(r.Body).Decode(&event) == nil && event.Reason != "" {
+		f.requests[fmt.Sprintf("EVENT %v %v/%v",
+			event.Reason, event.InvolvedObject.Kind, event.InvolvedObject.Name)] = true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if response, ok := f.responses[fmt.Sprintf("%v %v", r.Method, r.URL.Path)]; ok {
+		w.Write([]byte(response))
+		return
+	}
+	w.Write([]byte(`{"kind": "Status", "apiVersion": "v1", "status": "Success"}`))
+}
+
+func (f *fakeApiServer) received(method, path string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.requests[fmt.Sprintf("%v %v", method, path)]
+}
+
+func (f *fakeApiServer) receivedEvent(reason, kind, name string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.requests[fmt.Sprintf("EVENT %v %v/%v", reason, kind, name)]
+}
+
+func newTestHivedScheduler(kClient kubeClient.Interface, nodes []*core.Node) *HivedScheduler {
+	rawConfig := si.Config{}
+	common.FromYaml(testConfig, &rawConfig)
+	sConfig := si.NewConfig(&rawConfig)
+
+	nodeListerInformer := kubeInformer.NewSharedInformerFactory(kClient, 0).Core().V1().Nodes()
+	s := &HivedScheduler{
+		sConfig:             sConfig,
+		kClient:             kClient,
+		nodeLister:          nodeListerInformer.Lister(),
+		eventRecorder:       internal.NewEventRecorder(kClient),
+		schedulerLock:       &sync.RWMutex{},
+		podScheduleStatuses: internal.PodScheduleStatuses{},
+		physicalCluster:     sConfig.PhysicalCluster,
+		drainingCells:       common.NewSet(),
+		badCells:            common.NewSet(),
+		canceledPreemptions: map[string]time.Time{},
+		schedulerAlgorithm:  algorithm.NewHivedAlgorithm(sConfig),
+		virtualClusters:     *sConfig.VirtualClusters,
+		metrics:             newSchedulerMetrics(),
+		dryRunSlots:         make(chan struct{}, maxConcurrentDryRuns),
+	}
+	for _, node := range nodes {
+		nodeListerInformer.Informer().GetIndexer().Add(node)
+		s.schedulerAlgorithm.AddNode(node)
+	}
+	return s
+}
+
+func newTestPod(name string, priority int32, group *si.AffinityGroupSpec) *core.Pod {
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID(name),
+			Annotations: map[string]string{
+				si.AnnotationKeyPodSchedulingSpec: common.ToYaml(si.PodSchedulingSpec{
+					VirtualCluster: "VC1",
+					Priority:       priority,
+					LeafCellType:   "K80",
+					LeafCellNumber: 4,
+					AffinityGroup:  group,
+				}),
+			},
+		},
+		Spec: core.PodSpec{
+			Containers: []core.Container{{
+				Resources: core.ResourceRequirements{
+					Limits: core.ResourceList{
+						si.ResourceNamePodSchedulingEnable: resource.MustParse("1"),
+					},
+				},
+			}},
+		},
+	}
+	return pod
+}
+
+func TestStandbyReplica(t *testing.T) {
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nil)
+
+	if status := s.getLeaderElectionStatus(); status.Role != si.LeaderElectionRoleLeader {
+		t.Errorf("Expected to be leader if leader election is disabled, but got %v", status.Role)
+	}
+
+	s.sConfig.LeaderElection = &si.LeaderElectionSpec{
+		LeaseNamespace:   "default",
+		LeaseName:        si.ComponentName,
+		Identity:         "replica1",
+		LeaseDurationSec: common.PtrInt64(15),
+		RenewDeadlineSec: common.PtrInt64(10),
+		RetryPeriodSec:   common.PtrInt64(2),
+	}
+	s.leaderElector = s.newLeaderElector()
+	status := s.getLeaderElectionStatus()
+	if status.Role != si.LeaderElectionRoleStandby || status.Identity != "replica1" {
+		t.Errorf("Expected to be standby replica1, but got %v", status)
+	}
+
+	pod := newTestPod("pod1", 0, nil)
+	s.addUnboundPod(pod)
+	var err error
+	func() {
+		defer internal.RecoverAsError(&err)
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &[]string{}})
+	}()
+	if e, ok := err.(*si.WebServerError); !ok || e.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected standby to reject scheduling, but got %v", err)
+	}
+}
+
+func TestSchedulingEvents(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	apiServer := &fakeApiServer{requests: map[string]bool{}}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go s.eventRecorder.Run(stopCh)
+
+	pods := []*core.Pod{
+		newTestPod("pod1", 0, nil),
+		newTestPod("pod2", 0, nil),
+		newTestPod("pod3", 0, nil),
+		newTestPod("pod4", 1, nil),
+	}
+	for _, pod := range pods {
+		s.addUnboundPod(pod)
+	}
+
+	// The first 2 Pods use up all the nodes.
+	for _, pod := range pods[:2] {
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+		s.addBoundPod(s.podScheduleStatuses[pod.UID].Pod.DeepCopy())
+	}
+	// The same priority Pod has to wait.
+	s.filterRoutine(ei.ExtenderArgs{Pod: pods[2], NodeNames: &nodeNames})
+	// The higher priority Pod preempts one of them.
+	s.preemptRoutine(ei.ExtenderPreemptionArgs{
+		Pod: pods[3],
+		NodeNameToMetaVictims: map[string]*ei.MetaVictims{
+			"node1": {}, "node2": {},
+		},
+	})
+	podStatus := s.podScheduleStatuses[pods[3].UID]
+	if podStatus.PodState != internal.PodPreempting {
+		t.Fatalf("[pod4]: Expected to be preempting, but got %v", podStatus.PodState)
+	}
+	victim := podStatus.PodScheduleResult.PodPreemptInfo.VictimPods[0]
+
+	expectations := map[string]func() bool{}
+	for _, pod := range pods {
+		path := "/api/v1/namespaces/default/pods/" + pod.Name + "/status"
+		expectations["PodState condition of "+pod.Name] = func() bool {
+			return apiServer.received("PATCH", path)
+		}
+	}
+	for _, e := range []struct{ reason, kind, name string }{
+		{si.EventReasonWaiting, "Pod", pods[2].Name},
+		{si.EventReasonPreempting, "Pod", pods[3].Name},
+		{si.EventReasonPreempted, "Pod", victim.Name},
+		{si.EventReasonPreempting, "Node", victim.Spec.NodeName},
+	} {
+		e := e
+		expectations[e.reason+" Event of "+e.kind+" "+e.name] = func() bool {
+			return apiServer.receivedEvent(e.reason, e.kind, e.name)
+		}
+	}
+	for name, received := range expectations {
+		if wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+			return received(), nil
+		}) != nil {
+			t.Errorf("Expected %v to be recorded, but not", name)
+		}
+	}
+}
+
+func TestAffinityGroupResource(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	apiServer := &fakeApiServer{requests: map[string]bool{}}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	kConfig := &rest.Config{Host: server.URL}
+	s := newTestHivedScheduler(internal.CreateClient(kConfig), nodes)
+	s.initAffinityGroupResource(internal.CreateDynamicClient(kConfig))
+	s.affinityGroupInformer.GetIndexer().Add(&unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": si.GroupName + "/" + si.AffinityGroupResourceVersion,
+		"kind":       "AffinityGroup",
+		"metadata":   map[string]interface{}{"namespace": "default", "name": "group1"},
+		"spec": map[string]interface{}{
+			"virtualCluster": "VC1",
+			"priority":       int64(0),
+			"leafCellType":   "K80",
+			"members": []interface{}{
+				map[string]interface{}{"podNumber": int64(2), "leafCellNumber": int64(4)},
+			},
+		},
+	}})
+
+	pods := []*core.Pod{}
+	for _, name := range []string{"pod1", "pod2", "pod3"} {
+		pod := newTestPod(name, 0, nil)
+		delete(pod.Annotations, si.AnnotationKeyPodSchedulingSpec)
+		pod.Annotations[si.AnnotationKeyPodAffinityGroup] = "group1"
+		pods = append(pods, pod)
+		s.addUnboundPod(pod)
+	}
+	pods[2].Annotations[si.AnnotationKeyPodAffinityGroup] = "group2"
+
+	for _, pod := range pods[:2] {
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+		podStatus := s.podScheduleStatuses[pod.UID]
+		if podStatus.PodState != internal.PodBinding {
+			t.Fatalf("[%v]: Expected to be binding, but got %v", pod.Name, podStatus.PodState)
+		}
+		// The resolved PodSchedulingSpec is persisted when bound.
+		spec := internal.ExtractPodBindAnnotations(podStatus.Pod)[si.AnnotationKeyPodSchedulingSpec]
+		if spec == "" {
+			t.Errorf("[%v]: Expected PodSchedulingSpec to be persisted when bound", pod.Name)
+		}
+		boundPod := pod.DeepCopy()
+		boundPod.Spec.NodeName = podStatus.Pod.Spec.NodeName
+		boundPod.Annotations[si.AnnotationKeyPodSchedulingSpec] = spec
+		s.addBoundPod(boundPod)
+	}
+	if status := s.getAffinityGroup("default/group1").Status; len(status.AllocatedPods) != 2 {
+		t.Errorf("Expected 2 Pods allocated in default/group1, but got %v", status.AllocatedPods)
+	}
+
+	func() {
+		defer func() {
+			if err, ok := recover().(*si.WebServerError); !ok || err.Reason != si.ErrorReasonInvalidSpec {
+				t.Errorf("[pod3]: Expected InvalidSpec error for not existing AffinityGroup, but got %v", err)
+			}
+		}()
+		s.filterRoutine(ei.ExtenderArgs{Pod: pods[2], NodeNames: &nodeNames})
+	}()
+
+	s.syncAffinityGroupStatuses()
+	if !apiServer.received("PUT", "/apis/"+si.GroupName+"/"+si.AffinityGroupResourceVersion+
+		"/namespaces/default/"+si.AffinityGroupResourcePlural+"/group1/status") {
+		t.Errorf("Expected AffinityGroup status to be updated")
+	}
+}
+
+func newTestVirtualCluster(name string, nodeNumber int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": si.GroupName + "/" + si.VirtualClusterResourceVersion,
+		"kind":       "VirtualCluster",
+		"metadata":   map[string]interface{}{"name": name},
+		"spec": map[string]interface{}{
+			"virtualCells": []interface{}{
+				map[string]interface{}{"cellType": "2-K80-NODE.K80-NODE", "cellNumber": nodeNumber},
+			},
+		},
+	}}
+}
+
+func TestVirtualClusterResource(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	apiServer := &fakeApiServer{requests: map[string]bool{}}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	kConfig := &rest.Config{Host: server.URL}
+	s := newTestHivedScheduler(internal.CreateClient(kConfig), nodes)
+	s.initVirtualClusterResource(internal.CreateDynamicClient(kConfig))
+
+	pod := newTestPod("pod1", 0, nil)
+	s.addUnboundPod(pod)
+	s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	boundPod := s.podScheduleStatuses[pod.UID].Pod.DeepCopy()
+	s.addBoundPod(boundPod)
+	pod2 := newTestPod("pod2", 0, nil)
+	s.addUnboundPod(pod2)
+	s.filterRoutine(ei.ExtenderArgs{Pod: pod2, NodeNames: &nodeNames})
+	boundPod2 := s.podScheduleStatuses[pod2.UID].Pod.DeepCopy()
+	s.addBoundPod(boundPod2)
+
+	// Unsafe change: VC1 cannot be shrunk while both nodes are allocated in it,
+	// and VC2 cannot be guaranteed without VC1 shrunk.
+	indexer := s.virtualClusterInformer.GetIndexer()
+	indexer.Add(newTestVirtualCluster("VC1", 1))
+	indexer.Add(newTestVirtualCluster("VC2", 1))
+	s.applyVirtualClusters()
+	if s.virtualClusters["VC1"].VirtualCells[0].CellNumber != 2 ||
+		!strings.Contains(s.virtualClusterApplyErrors["VC1"], "default/pod") ||
+		s.virtualClusterApplyErrors["VC2"] == "" {
+		t.Fatalf("Expected VC1 and VC2 to be rejected, but got errors: %v", s.virtualClusterApplyErrors)
+	}
+	for _, name := range []string{"default/pod1", "default/pod2"} {
+		if status := s.getAffinityGroup(name).Status; status.LazyPreemptionStatus != nil {
+			t.Errorf("Expected %v to be kept after VirtualClusters rejected, but got %v", name, status)
+		}
+	}
+	s.deletePod(boundPod2)
+
+	s.setCellDraining("node2", true)
+	s.setCellBad("node2", true)
+	s.setAffinityGroupLazyPreempted("default/pod1", true)
+
+	// Safe change: VC1 is shrunk to give a node to VC2.
+	s.applyVirtualClusters()
+	if _, ok := s.virtualClusters["VC2"]; !ok || len(s.virtualClusterApplyErrors) != 0 {
+		t.Fatalf("Expected VC2 to be applied, but got errors: %v", s.virtualClusterApplyErrors)
+	}
+	// The scheduling history is also inherited.
+	if attempts := s.explainAffinityGroup("default/pod1").Attempts; len(attempts) == 0 {
+		t.Errorf("Expected the scheduling attempts of pod1 to be kept after VirtualClusters applied")
+	}
+	if status := s.getAffinityGroup("default/pod1").Status; len(status.AllocatedPods) != 1 {
+		t.Errorf("Expected pod1 to be recovered after VirtualClusters applied, but got %v", status)
+	}
+	// The status changed by the Admin API is also recovered, and not counted again.
+	if status := s.getAffinityGroup("default/pod1").Status; status.LazyPreemptionStatus == nil {
+		t.Errorf("Expected pod1 to keep lazy preempted after VirtualClusters applied, but got %v", status)
+	}
+	pcs := internal.CellStatusFilter{Node: "node2"}.FilterPhysicalClusterStatus(s.getPhysicalClusterStatus())
+	if len(pcs) != 1 || !pcs[0].CellChildren[0].CellDraining || pcs[0].CellChildren[0].CellHealthiness != si.CellBad {
+		t.Errorf("Expected node2 to keep draining and bad after VirtualClusters applied, but got %v",
+			common.ToJson(pcs))
+	}
+	if metrics := string(s.getMetrics()); !strings.Contains(metrics, "hivedscheduler_lazy_preemptions_total 1\n") {
+		t.Errorf("Expected 1 lazy preemption after VirtualClusters applied, but got:\n%v", metrics)
+	}
+	if status := s.getVirtualClusterResourceStatus("VC2", s.virtualClusters["VC2"]); status.Conditions[0].Status != "True" ||
+		status.LeafCells["K80"].Total != 4 {
+		t.Errorf("Expected VC2 to be applied with 4 K80, but got %v", common.ToJson(status))
+	}
+
+	// Partially unsafe change: VC3 cannot be guaranteed by the physical cluster,
+	// while VC2 can still be deleted.
+	vc3 := newTestVirtualCluster("VC3", 2)
+	indexer.Add(vc3)
+	indexer.Delete(newTestVirtualCluster("VC2", 1))
+	s.applyVirtualClusters()
+	if _, ok := s.virtualClusters["VC3"]; ok || s.virtualClusterApplyErrors["VC3"] == "" {
+		t.Fatalf("Expected VC3 to be rejected, but applied")
+	}
+	if _, ok := s.virtualClusters["VC2"]; ok || len(s.virtualClusterApplyErrors) != 1 {
+		t.Errorf("Expected VC2 to be deleted without error, but got errors: %v", s.virtualClusterApplyErrors)
+	}
+	status := s.getVirtualClusterResourceStatus("VC3", internal.ToVirtualClusterResource(vc3).Spec)
+	if status.Conditions[0].Status != "False" || status.LeafCells != nil {
+		t.Errorf("Expected VC3 to be not applied, but got %v", common.ToJson(status))
+	}
+
+	s.syncVirtualClusterStatuses()
+	if !apiServer.received("PUT", "/apis/"+si.GroupName+"/"+si.VirtualClusterResourceVersion+
+		"/"+si.VirtualClusterResourcePlural+"/VC3/status") {
+		t.Errorf("Expected VirtualCluster status to be updated")
+	}
+}
+
+func TestMutatePod(t *testing.T) {
+	apiServer := &fakeApiServer{
+		requests: map[string]bool{},
+		responses: map[string]string{
+			"GET /apis/batch/v1/namespaces/default/jobs/job1": `{"kind": "Job", "apiVersion": "batch/v1", ` +
+				`"metadata": {"name": "job1", "uid": "job1-uid"}, "spec": {"parallelism": 2}}`,
+		},
+	}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nil)
+	s.sConfig.NamespaceDefaults = &map[string]si.NamespaceDefaultSpec{
+		"default": {VirtualCluster: "VC1", LeafCellType: "K80", LeafCellResourceName: si.ResourceNameNvidiaGpu},
+	}
+
+	pod := &core.Pod{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      "job1-0",
+			Namespace: "default",
+			OwnerReferences: []meta.OwnerReference{{
+				Kind: "Job", Name: "job1", UID: "job1-uid", Controller: common.PtrBool(true),
+			}},
+		},
+		Spec: core.PodSpec{
+			Containers: []core.Container{{
+				Resources: core.ResourceRequirements{
+					Limits: core.ResourceList{
+						si.ResourceNameNvidiaGpu: resource.MustParse("4"),
+					},
+				},
+			}},
+		},
+	}
+	patches := s.mutatePodRoutine(pod)
+	if len(patches) != 2 {
+		t.Fatalf("Expected 2 patches, but got %v", common.ToJson(patches))
+	}
+	if patches[1].Path != "/spec/containers/0/resources/limits/hivedscheduler.microsoft.com~1pod-scheduling-enable" {
+		t.Errorf("Expected the resource limit to be injected, but got %v", common.ToJson(patches[1]))
+	}
+	pod.Annotations = patches[0].Value.(map[string]string)
+	podSchedulingSpec := internal.ExtractPodSchedulingSpec(pod)
+	if podSchedulingSpec.VirtualCluster != "VC1" || podSchedulingSpec.LeafCellNumber != 4 ||
+		podSchedulingSpec.AffinityGroup.Name != "job1-uid" ||
+		podSchedulingSpec.AffinityGroup.Members[0].PodNumber != 2 {
+		t.Errorf("Expected the default PodSchedulingSpec of job1, but got %v", common.ToJson(podSchedulingSpec))
+	}
+
+	if patches := s.mutatePodRoutine(newTestPod("pod1", 0, nil)); len(patches) != 0 {
+		t.Errorf("Expected the Pod with PodSchedulingSpec not to be mutated, but got %v", common.ToJson(patches))
+	}
+}
+
+func TestAuthorizePod(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	apiServer := &fakeApiServer{requests: map[string]bool{}}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+	s.sConfig.AccessRules = &[]si.AccessRuleSpec{{
+		Namespaces:      []string{"default"},
+		VirtualClusters: []si.VirtualClusterName{"VC1"},
+		MaxPriority:     common.PtrInt32(0),
+	}}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go s.eventRecorder.Run(stopCh)
+
+	tryFilter := func(pod *core.Pod) (err error) {
+		defer internal.RecoverAsError(&err)
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+		return nil
+	}
+
+	pod := newTestPod("pod1", 0, nil)
+	s.addUnboundPod(pod)
+	if err := tryFilter(pod); err != nil {
+		t.Errorf("[pod1]: Expected to be authorized, but got %v", err)
+	}
+
+	pod = newTestPod("pod2", 1, nil)
+	s.addUnboundPod(pod)
+	for i := 0; i < 2; i++ {
+		err := tryFilter(pod)
+		if e, ok := err.(*si.WebServerError); !ok || e.Reason != si.ErrorReasonUnauthorized {
+			t.Errorf("[pod2]: Expected to be unauthorized, but got %v", err)
+		}
+	}
+	if s.podScheduleStatuses[pod.UID].UnauthorizedMessage == "" {
+		t.Errorf("[pod2]: Expected the denial to be recorded")
+	}
+	if err := wait.Poll(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		return apiServer.receivedEvent(si.EventReasonUnauthorized, "Pod", "pod2"), nil
+	}); err != nil {
+		t.Errorf("[pod2]: Expected event %v", si.EventReasonUnauthorized)
+	}
+
+	pod = newTestPod("pod3", 0, nil)
+	pod.Namespace = "other"
+	err := func() (err error) {
+		defer internal.RecoverAsError(&err)
+		s.validatePodRoutine(pod)
+		return nil
+	}()
+	if e, ok := err.(*si.WebServerError); !ok || e.Reason != si.ErrorReasonUnauthorized {
+		t.Errorf("[pod3]: Expected to be rejected at admission, but got %v", err)
+	}
+}
+
+func TestOwnerAffinityGroup(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	kClient := internal.CreateClient(&rest.Config{Host: server.URL})
+	s := newTestHivedScheduler(kClient, nodes)
+	s.sConfig.OwnerAffinityGroupEnable = common.PtrBool(true)
+	s.initOwnerAffinityGroup(kClient)
+	s.jobInformer.GetIndexer().Add(&batch.Job{
+		ObjectMeta: meta.ObjectMeta{Name: "job1", Namespace: "default", UID: "job1-uid"},
+		Spec:       batch.JobSpec{Parallelism: common.PtrInt32(2)},
+	})
+
+	owner := meta.OwnerReference{Kind: "Job", Name: "job1", UID: "job1-uid", Controller: common.PtrBool(true)}
+	pod := newTestPod("job1-0", 0, nil)
+	pod.OwnerReferences = []meta.OwnerReference{owner}
+	s.addUnboundPod(pod)
+	s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+
+	group := s.getAffinityGroup("job1-uid")
+	if len(group.Status.PhysicalPlacement) != 2 || group.Status.State != "Allocated" {
+		t.Errorf("Expected job1 to be allocated as a whole, but got %v", common.ToJson(group.Status))
+	}
+	bindingPod := s.podScheduleStatuses[pod.UID].Pod
+	annotations := internal.ExtractPodBindAnnotations(bindingPod)
+	if annotations[si.AnnotationKeyPodOwnerAffinityGroup] != "job1-uid" {
+		t.Errorf("Expected the owner affinity group to be persisted, but got %v", annotations)
+	}
+
+	// The Pod explicitly specifying its affinity group is not inferred.
+	pod = newTestPod("job1-1", 0, &si.AffinityGroupSpec{
+		Name:    "group1",
+		Members: []si.AffinityGroupMemberSpec{{PodNumber: 1, LeafCellNumber: 4}},
+	})
+	pod.OwnerReferences = []meta.OwnerReference{owner}
+	if resolvedPod := s.resolvePod(pod); resolvedPod != pod {
+		t.Errorf("Expected the Pod with affinity group not to be resolved, but got %v",
+			resolvedPod.Annotations)
+	}
+}
+
+func TestPodGroupResource(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	apiServer := &fakeApiServer{requests: map[string]bool{}}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	kConfig := &rest.Config{Host: server.URL}
+	s := newTestHivedScheduler(internal.CreateClient(kConfig), nodes)
+	s.sConfig.PodGroupKinds = &[]si.PodGroupKind{si.PodGroupKindVolcano}
+	s.initPodGroupResources(internal.CreateDynamicClient(kConfig))
+	s.podGroupResources[si.PodGroupKindVolcano].informer.GetIndexer().Add(
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "scheduling.volcano.sh/v1beta1",
+			"kind":       "PodGroup",
+			"metadata": map[string]interface{}{
+				"namespace": "default", "name": "pg1", "uid": "pg1-uid"},
+			"spec":   map[string]interface{}{"minMember": int64(2), "queue": "default"},
+			"status": map[string]interface{}{"phase": "Pending"},
+		}})
+
+	pod := newTestPod("pg1-0", 0, nil)
+	pod.Annotations[si.AnnotationKeyVolcanoPodGroup] = "pg1"
+	s.addUnboundPod(pod)
+	s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+
+	group := s.getAffinityGroup("pg1-uid")
+	if len(group.Status.PhysicalPlacement) != 2 || group.Status.State != "Allocated" {
+		t.Errorf("Expected pg1 to be allocated as a whole, but got %v", common.ToJson(group.Status))
+	}
+	bindingPod := s.podScheduleStatuses[pod.UID].Pod
+	annotations := internal.ExtractPodBindAnnotations(bindingPod)
+	if annotations[si.AnnotationKeyPodPodGroupAffinityGroup] != "pg1-uid" {
+		t.Errorf("Expected the PodGroup affinity group to be persisted, but got %v", annotations)
+	}
+
+	// The Pod referencing a not existing PodGroup waits for it to be informed.
+	pod = newTestPod("pg2-0", 0, nil)
+	pod.Annotations[si.AnnotationKeyVolcanoPodGroup] = "pg2"
+	func() {
+		defer func() {
+			if err, ok := recover().(*si.WebServerError); !ok || err.Code != http.StatusBadRequest {
+				t.Errorf("[pg2-0]: Expected BadRequest error for not existing PodGroup, but got %v", err)
+			}
+		}()
+		s.resolvePod(pod)
+	}()
+
+	s.syncPodGroupStatuses()
+	if !apiServer.received("PUT",
+		"/apis/scheduling.volcano.sh/v1beta1/namespaces/default/podgroups/pg1/status") {
+		t.Errorf("Expected PodGroup phase to be updated")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+
+	pods := []*core.Pod{
+		newTestPod("pod1", 0, nil),
+		newTestPod("pod2", 0, nil),
+		newTestPod("pod3", 0, nil),
+	}
+	for _, pod := range pods {
+		s.addUnboundPod(pod)
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	}
+
+	metrics := string(s.getMetrics())
+	for _, sample := range []string{
+		`hivedscheduler_vc_free_cells{vc="VC1",chain="2-K80-NODE",cell_type="K80-NODE"} 0`,
+		`hivedscheduler_vc_used_cells{vc="VC1",chain="2-K80-NODE",cell_type="K80-NODE"} 2`,
+		`hivedscheduler_used_leaf_cells{chain="2-K80-NODE",priority="0"} 8`,
+		`hivedscheduler_affinity_groups{state="Allocated"} 2`,
+		`hivedscheduler_preemptions_total 0`,
+		`hivedscheduler_waiting_pods 1`,
+		`hivedscheduler_filter_duration_seconds_count 3`,
+		`hivedscheduler_bind_duration_seconds_bucket{le="+Inf"} 0`,
+	} {
+		if !strings.Contains(metrics, sample+"\n") {
+			t.Errorf("Expected metrics to contain %v, but got:\n%v", sample, metrics)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+
+	newSpec := func(priority int32) string {
+		return common.ToYaml(si.PodSchedulingSpec{
+			VirtualCluster: "VC1",
+			Priority:       priority,
+			LeafCellType:   "K80",
+			LeafCellNumber: 4,
+		})
+	}
+
+	result := s.dryRunRoutine(newSpec(0))
+	if result.Attempt.Result != si.SchedulingAttemptBind ||
+		result.AffinityGroup == nil || result.AffinityGroup.Status.State != "Allocated" {
+		t.Errorf("Expected the dry run Pod to be bound, but got %v", common.ToJson(result))
+	}
+
+	for _, pod := range []*core.Pod{newTestPod("pod1", 0, nil), newTestPod("pod2", 0, nil)} {
+		s.addUnboundPod(pod)
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	}
+	getGroups := func() string {
+		return common.ToJson([]si.AffinityGroup{
+			s.schedulerAlgorithm.GetAffinityGroup("default/pod1"),
+			s.schedulerAlgorithm.GetAffinityGroup("default/pod2"),
+		})
+	}
+	groups := getGroups()
+
+	result = s.dryRunRoutine(newSpec(0))
+	if result.Attempt.Result != si.SchedulingAttemptWait || result.AffinityGroup != nil {
+		t.Errorf("Expected the dry run Pod to wait, but got %v", common.ToJson(result))
+	}
+	result = s.dryRunRoutine(newSpec(1))
+	if result.Attempt.Result != si.SchedulingAttemptPreempt || len(result.Attempt.PreemptionVictims) != 1 ||
+		result.AffinityGroup == nil || result.AffinityGroup.Status.State != "Preempting" {
+		t.Errorf("Expected the dry run Pod to preempt one Pod, but got %v", common.ToJson(result))
+	}
+
+	if newGroups := getGroups(); newGroups != groups {
+		t.Errorf("Expected the affinity groups not to be changed by the dry run, but got %v", newGroups)
+	}
+	if _, err := func() (result si.DryRunResult, err error) {
+		defer internal.RecoverAsError(&err)
+		return s.dryRunRoutine("virtualCluster: VC1\nleafCellNumber: 0"), nil
+	}(); err == nil {
+		t.Errorf("Expected the dry run to fail for the invalid PodSchedulingSpec")
+	}
+
+	// the administrated status is also copied to the snapshot
+	s.setCellBad(s.getPhysicalClusterStatus()[0].CellAddress, true)
+	result = s.dryRunRoutine(newSpec(1))
+	if result.Attempt.Result != si.SchedulingAttemptWait || result.AffinityGroup != nil {
+		t.Errorf("Expected the dry run Pod to wait for the bad cells, but got %v", common.ToJson(result))
+	}
+
+	// the concurrent dry runs are limited
+	for i := 0; i < maxConcurrentDryRuns; i++ {
+		s.dryRunSlots <- struct{}{}
+	}
+	_, err := func() (result si.DryRunResult, err error) {
+		defer internal.RecoverAsError(&err)
+		return s.dryRunRoutine(newSpec(0)), nil
+	}()
+	if e, ok := err.(*si.WebServerError); !ok || e.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the dry run to be rejected when too many are in progress, but got %v", err)
+	}
+}
+
+func TestPodScheduleStatuses(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+
+	for _, pod := range []*core.Pod{
+		newTestPod("pod1", 0, nil),
+		newTestPod("pod2", 0, nil),
+		newTestPod("pod3", 0, nil),
+	} {
+		s.addUnboundPod(pod)
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	}
+
+	pss := s.getAllPodScheduleStatuses("", "")
+	if len(pss.Items) != 3 || pss.Items[0].Name != "pod1" || pss.Items[2].Name != "pod3" {
+		t.Errorf("Expected all 3 Pods sorted by name, but got %v", common.ToJson(pss))
+	}
+	pss = s.getAllPodScheduleStatuses("default", internal.PodBinding)
+	if len(pss.Items) != 2 {
+		t.Errorf("Expected 2 Binding Pods, but got %v", common.ToJson(pss))
+	}
+	for _, ps := range pss.Items {
+		if ps.BindInfo == nil || ps.BindAttempts != 0 || ps.AffinityGroup != "default/"+ps.Name {
+			t.Errorf("Expected Pod %v to be binding in its own group, but got %v", ps.Name, common.ToJson(ps))
+		}
+	}
+	if pss = s.getAllPodScheduleStatuses("kube-system", ""); len(pss.Items) != 0 {
+		t.Errorf("Expected no Pod in namespace kube-system, but got %v", common.ToJson(pss))
+	}
+
+	ps := s.getPodScheduleStatus("pod3")
+	if ps.State != string(internal.PodWaiting) || ps.WaitReason == "" || ps.BindInfo != nil {
+		t.Errorf("Expected pod3 to be waiting with a reason, but got %v", common.ToJson(ps))
+	}
+	if _, err := func() (ps si.PodScheduleStatus, err error) {
+		defer internal.RecoverAsError(&err)
+		return s.getPodScheduleStatus("pod4"), nil
+	}(); err == nil {
+		t.Errorf("Expected error when getting the untracked pod4, but got nil")
+	}
+}
+
+func TestFilterWaitReason(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+
+	filter := func(pod *core.Pod, suggestedNodes []string) (map[string]string, si.PodScheduleStatus) {
+		s.addUnboundPod(pod)
+		result := s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &suggestedNodes})
+		return result.FailedNodes, s.getPodScheduleStatus(pod.UID)
+	}
+
+	// the resource is sufficient, but no node is suggested
+	failedNodes, status := filter(newTestPod("pod0", 0, nil), []string{})
+	if m := failedNodes[si.ComponentName]; !strings.HasPrefix(m, "Reason: BadOrNonSuggestedNodes, Message: ") ||
+		status.WaitErrorReason != si.ErrorReasonBadOrNonSuggestedNodes {
+		t.Errorf("Expected pod0 to wait for the non-suggested nodes, but got %v and %v",
+			common.ToJson(failedNodes), common.ToJson(status))
+	}
+	s.deletePod(newTestPod("pod0", 0, nil))
+
+	for _, pod := range []*core.Pod{newTestPod("pod1", 0, nil), newTestPod("pod2", 0, nil)} {
+		filter(pod, nodeNames)
+	}
+	failedNodes, status = filter(newTestPod("pod3", 0, nil), nodeNames)
+	if m := failedNodes[si.ComponentName]; !strings.HasPrefix(m, "Reason: CapacityExhausted, Message: ") ||
+		status.WaitErrorReason != si.ErrorReasonCapacityExhausted {
+		t.Errorf("Expected pod3 to wait for the capacity, but got %v and %v",
+			common.ToJson(failedNodes), common.ToJson(status))
+	}
+	failedNodes, _ = filter(newTestPod("pod4", 1, nil), nodeNames)
+	if len(failedNodes) != 1 {
+		t.Errorf("Expected pod4 to preempt on one node, but got %v", common.ToJson(failedNodes))
+	}
+	for node, m := range failedNodes {
+		if !strings.HasPrefix(m, "node("+node+") has preemptible Pods: ") {
+			t.Errorf("Expected pod4 to preempt on %v, but got %v", node, m)
+		}
+	}
+}
+
+func TestInspectFilter(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+
+	for _, pod := range []*core.Pod{newTestPod("pod1", 0, nil), newTestPod("pod2", 0, nil)} {
+		s.addUnboundPod(pod)
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	}
+
+	cs := internal.CellStatusFilter{Node: "node1", CrossLinks: true}.FilterClusterStatus(s.getClusterStatus())
+	if len(cs.PhysicalCluster) != 1 || len(cs.PhysicalCluster[0].CellChildren) != 1 ||
+		len(cs.PhysicalCluster[0].CellChildren[0].CellChildren) != 4 {
+		t.Errorf("Expected only node1 and its leaf cells, but got %v", common.ToJson(cs.PhysicalCluster))
+	}
+	if vcs := cs.VirtualClusters["VC1"]; len(vcs) != 1 || vcs[0].PhysicalCell == nil {
+		t.Errorf("Expected only the VC1 cell bound to node1, but got %v", common.ToJson(vcs))
+	}
+
+	pcs := internal.CellStatusFilter{State: "Used", Depth: 2}.FilterPhysicalClusterStatus(
+		s.getPhysicalClusterStatus())
+	if len(pcs) != 1 || len(pcs[0].CellChildren) != 2 ||
+		pcs[0].CellChildren[0].CellChildren != nil || pcs[0].CellChildren[0].VirtualCell != nil {
+		t.Errorf("Expected only the used node cells without their children and virtual cells, but got %v",
+			common.ToJson(pcs))
+	}
+	if pcs = (internal.CellStatusFilter{Chain: "4-K80-NODE"}).FilterPhysicalClusterStatus(
+		s.getPhysicalClusterStatus()); len(pcs) != 0 {
+		t.Errorf("Expected no cell in the unknown chain, but got %v", common.ToJson(pcs))
+	}
+
+	page := internal.PaginateAffinityGroups(s.getAllAffinityGroups(), 1, "")
+	if len(page.Items) != 1 || page.Items[0].Name != "default/pod1" || page.Continue == "" {
+		t.Errorf("Expected the first page with group default/pod1, but got %v", common.ToJson(page))
+	}
+	page = internal.PaginateAffinityGroups(s.getAllAffinityGroups(), 1, page.Continue)
+	if len(page.Items) != 1 || page.Items[0].Name != "default/pod2" || page.Continue != "" {
+		t.Errorf("Expected the last page with group default/pod2, but got %v", common.ToJson(page))
+	}
+}
+
+func TestAdministrating(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	apiServer := &fakeApiServer{requests: map[string]bool{}}
+	server := httptest.NewServer(apiServer)
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+
+	pods := []*core.Pod{
+		newTestPod("pod1", 0, nil),
+		newTestPod("pod2", 0, nil),
+		newTestPod("pod3", 1, nil),
+	}
+	for _, pod := range pods[:2] {
+		s.addUnboundPod(pod)
+		s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+		s.addBoundPod(s.podScheduleStatuses[pod.UID].Pod.DeepCopy())
+	}
+	s.addUnboundPod(pods[2])
+	s.preemptRoutine(ei.ExtenderPreemptionArgs{
+		Pod: pods[2],
+		NodeNameToMetaVictims: map[string]*ei.MetaVictims{
+			"node1": {}, "node2": {},
+		},
+	})
+	if podStatus := s.podScheduleStatuses[pods[2].UID]; podStatus.PodState != internal.PodPreempting {
+		t.Fatalf("[pod3]: Expected to be preempting, but got %v", podStatus.PodState)
+	}
+
+	s.cancelAffinityGroupPreemption("default/pod3")
+	// the canceled preemption is not retried in the next scheduling cycle
+	s.filterRoutine(ei.ExtenderArgs{Pod: pods[2], NodeNames: &nodeNames})
+	result := s.preemptRoutine(ei.ExtenderPreemptionArgs{
+		Pod: pods[2],
+		NodeNameToMetaVictims: map[string]*ei.MetaVictims{
+			"node1": {}, "node2": {},
+		},
+	})
+	if len(result.NodeNameToMetaVictims) != 0 {
+		t.Errorf("[pod3]: Expected no victims after its preemption is canceled, but got %v",
+			common.ToJson(result.NodeNameToMetaVictims))
+	}
+	if podStatus := s.podScheduleStatuses[pods[2].UID]; podStatus.PodState != internal.PodWaiting {
+		t.Errorf("[pod3]: Expected to be waiting after its preemption is canceled, but got %v",
+			podStatus.PodState)
+	}
+	// but it is retried once the backoff elapses
+	s.canceledPreemptions["default/pod3"] = time.Now()
+	result = s.preemptRoutine(ei.ExtenderPreemptionArgs{
+		Pod: pods[2],
+		NodeNameToMetaVictims: map[string]*ei.MetaVictims{
+			"node1": {}, "node2": {},
+		},
+	})
+	if podStatus := s.podScheduleStatuses[pods[2].UID]; podStatus.PodState != internal.PodPreempting ||
+		len(result.NodeNameToMetaVictims) == 0 {
+		t.Fatalf("[pod3]: Expected to be preempting again after the backoff, but got %v", podStatus.PodState)
+	}
+	s.cancelAffinityGroupPreemption("default/pod3")
+	for _, node := range s.getPhysicalClusterStatus()[0].CellChildren {
+		for _, c := range node.CellChildren {
+			if c.CellState != si.CellState("Used") || c.CellPriority != 0 {
+				t.Errorf("Expected cell %v to be returned to the victims, but got %v at priority %v",
+					c.CellAddress, c.CellState, c.CellPriority)
+			}
+		}
+	}
+
+	var err error
+	func() {
+		defer internal.RecoverAsError(&err)
+		s.cancelAffinityGroupPreemption("default/pod3")
+	}()
+	if e, ok := err.(*si.WebServerError); !ok || e.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request error to cancel a canceled preemption, but got %v", err)
+	}
+
+	s.setCellBad("node1", true)
+	pcs := internal.CellStatusFilter{Node: "node1"}.FilterPhysicalClusterStatus(s.getPhysicalClusterStatus())
+	if len(pcs) != 1 || pcs[0].CellChildren[0].CellHealthiness != si.CellBad {
+		t.Errorf("Expected node1 to be bad, but got %v", common.ToJson(pcs))
+	}
+
+	// the draining cells are persisted in the annotation of all the nodes in them
+	s.setCellDraining("node1", true)
+	if !apiServer.received("PATCH", "/api/v1/nodes/node1") ||
+		apiServer.received("PATCH", "/api/v1/nodes/node2") {
+		t.Errorf("Expected draining node1 to be persisted only to node1")
+	}
+	s.setCellDraining(s.getPhysicalClusterStatus()[0].CellAddress, true)
+	if !apiServer.received("PATCH", "/api/v1/nodes/node2") {
+		t.Errorf("Expected the draining top level cell to be persisted to node2")
+	}
+
+	// the bad cells are also persisted in the annotation of the nodes in them
+	apiServer.lock.Lock()
+	apiServer.requests = map[string]bool{}
+	apiServer.lock.Unlock()
+	s.setCellBad("node2", true)
+	if !apiServer.received("PATCH", "/api/v1/nodes/node2") ||
+		apiServer.received("PATCH", "/api/v1/nodes/node1") {
+		t.Errorf("Expected bad node2 to be persisted only to node2")
+	}
+}
+
+func TestUsageLedger(t *testing.T) {
+	nodes := []*core.Node{}
+	nodeNames := []string{"node1", "node2"}
+	for _, name := range nodeNames {
+		nodes = append(nodes, &core.Node{
+			ObjectMeta: meta.ObjectMeta{Name: name},
+			Status: core.NodeStatus{
+				Conditions: []core.NodeCondition{{Type: core.NodeReady, Status: core.ConditionTrue}},
+			},
+		})
+	}
+	server := httptest.NewServer(&fakeApiServer{requests: map[string]bool{}})
+	defer server.Close()
+	s := newTestHivedScheduler(internal.CreateClient(&rest.Config{Host: server.URL}), nodes)
+	dir, err := ioutil.TempDir("", "usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s.usageLedger = internal.NewFileUsageLedger(filepath.Join(dir, "ledger"))
+
+	// the usage changes are appended after the resync
+	s.syncUsageLedger()
+	pod := newTestPod("pod1", 0, nil)
+	s.addUnboundPod(pod)
+	s.filterRoutine(ei.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	boundPod := s.podScheduleStatuses[pod.UID].Pod.DeepCopy()
+	s.addBoundPod(boundPod)
+	s.syncUsageLedger()
+	s.deletePod(boundPod)
+	s.syncUsageLedger()
+	records := s.usageLedger.List(time.Time{}, time.Now())
+	if len(records) != 3 || records[0].Type != si.UsageRecordResynced ||
+		records[1].Type != si.UsageRecordAllocated || records[2].Type != si.UsageRecordReleased ||
+		records[1].AffinityGroup.User != "default/default" || records[1].AffinityGroup.LeafCellNumber != 4 {
+		t.Errorf("Expected the resync, allocation and release of pod1, but got %v", common.ToJson(records))
+	}
+
+	// the usage is aggregated within the time range
+	s.usageLedger = internal.NewFileUsageLedger(filepath.Join(dir, "aggregated"))
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours float64) meta.Time {
+		return meta.NewTime(t0.Add(time.Duration(hours * float64(time.Hour))))
+	}
+	groupA := si.UsageAffinityGroup{
+		Name: "A", VirtualCluster: "VC1", Priority: 1, User: "ns/a", LeafCellType: "K80", LeafCellNumber: 4}
+	groupB := si.UsageAffinityGroup{
+		Name: "B", VirtualCluster: "VC1", Priority: -1, User: "ns/b", LeafCellType: "K80", LeafCellNumber: 2}
+	s.usageLedger.Append([]si.UsageRecord{
+		{Time: at(0), Type: si.UsageRecordResynced, AffinityGroups: []si.UsageAffinityGroup{groupA}},
+		{Time: at(1), Type: si.UsageRecordAllocated, AffinityGroup: &groupB},
+		{Time: at(2), Type: si.UsageRecordLazyPreempted, AffinityGroup: &groupA, Preemptor: "C"},
+		{Time: at(2), Type: si.UsageRecordAllocated, AffinityGroup: &groupB},
+		{Time: at(3), Type: si.UsageRecordReleased, AffinityGroup: &groupA},
+		{Time: at(4), Type: si.UsageRecordResynced, AffinityGroups: []si.UsageAffinityGroup{groupB}},
+		{Time: at(5), Type: si.UsageRecordPreempted, AffinityGroup: &groupB, Preemptor: "C"},
+		{Time: at(6), Type: si.UsageRecordReleased, AffinityGroup: &groupB},
+	})
+	report := s.getUsageReport(at(1).Time, at(5.5).Time)
+	expectedItems := []si.UsageReportItem{
+		{VirtualCluster: "VC1", User: "ns/a", Priority: 1, LeafCellType: "K80",
+			LeafCellHours: 8, LazyPreemptedLeafCellHours: 4, LazyPreemptions: 1},
+		{VirtualCluster: "VC1", User: "ns/b", Priority: -1, LeafCellType: "K80",
+			LeafCellHours: 9, Allocations: 1, Preemptions: 1},
+	}
+	if !reflect.DeepEqual(report.Items, expectedItems) {
+		t.Errorf("Expected usage report items %v, but got %v",
+			common.ToJson(expectedItems), common.ToJson(report.Items))
+	}
+
+	// the unchanged resync is not appended, and the ledger is split by day
+	s.usageLedger.Append([]si.UsageRecord{{Time: at(7), Type: si.UsageRecordResynced}})
+	if records := s.usageLedger.List(time.Time{}, at(7).Time); len(records) != 8 {
+		t.Errorf("Expected the unchanged resync not to be appended, but got %v", common.ToJson(records))
+	}
+	s.usageLedger.Append([]si.UsageRecord{{Time: at(25), Type: si.UsageRecordAllocated, AffinityGroup: &groupA}})
+	if segments, _ := filepath.Glob(filepath.Join(dir, "aggregated.*")); len(segments) != 2 {
+		t.Errorf("Expected the ledger to be split into 2 segments, but got %v", segments)
+	}
+	records = s.usageLedger.List(at(26).Time, at(27).Time)
+	if len(records) != 2 || records[0].Type != si.UsageRecordResynced || len(records[0].AffinityGroups) != 0 {
+		t.Errorf("Expected only the records of the second day, but got %v", common.ToJson(records))
+	}
+	report = s.getUsageReport(at(24).Time, at(27).Time)
+	expectedItems = []si.UsageReportItem{
+		{VirtualCluster: "VC1", User: "ns/a", Priority: 1, LeafCellType: "K80",
+			LeafCellHours: 8, Allocations: 1},
+	}
+	if !reflect.DeepEqual(report.Items, expectedItems) {
+		t.Errorf("Expected usage report items %v, but got %v",
+			common.ToJson(expectedItems), common.ToJson(report.Items))
+	}
+}
@@ -0,0 +1,104 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"time"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	"k8s.io/klog"
+)
+
+// The UsageRecords are appended to the UsageLedger periodically, instead of on
+// each change, to avoid blocking the scheduling by the ledger IO.
+const usageLedgerSyncPeriod = 10 * time.Second
+
+// Max number of the UsageRecords kept to be appended again after failures,
+// beyond which they are dropped and resynced instead.
+const maxPendingUsageRecords = 100000
+
+func (s *HivedScheduler) initUsageLedger() {
+	s.usageLedger = internal.NewFileUsageLedger(*s.sConfig.UsageLedgerFile)
+}
+
+func (s *HivedScheduler) syncUsageLedger() {
+	s.schedulerLock.RLock()
+	schedulerAlgorithm := s.schedulerAlgorithm
+	s.schedulerLock.RUnlock()
+
+	s.appendUsageRecords(schedulerAlgorithm)
+}
+
+// Take the UsageRecords from the SchedulerAlgorithm and append them to the
+// UsageLedger.
+// Only the leader appends them, and it resyncs once it takes them from a new
+// SchedulerAlgorithm, such as the scheduler restarts, gains leadership or its
+// VirtualClusters are changed, since the changes before may be missed or have
+// been appended by the previous leader.
+func (s *HivedScheduler) appendUsageRecords(schedulerAlgorithm internal.SchedulerAlgorithm) {
+	s.usageLedgerLock.Lock()
+	defer s.usageLedgerLock.Unlock()
+
+	algorithm, ok := schedulerAlgorithm.(internal.UsageRecordingSchedulerAlgorithm)
+	if !ok {
+		return
+	}
+	if !s.isLeader() {
+		s.usageRecordingAlgorithm = nil
+		return
+	}
+
+	resync := schedulerAlgorithm != s.usageRecordingAlgorithm
+	s.usageRecordingAlgorithm = schedulerAlgorithm
+	s.pendingUsageRecords = append(s.pendingUsageRecords, algorithm.TakeUsageRecords(resync)...)
+
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Warningf("Failed to append %v UsageRecords, will retry: %v",
+				len(s.pendingUsageRecords), r)
+			if len(s.pendingUsageRecords) > maxPendingUsageRecords {
+				s.pendingUsageRecords = nil
+				s.usageRecordingAlgorithm = nil
+			}
+		}
+	}()
+	s.usageLedger.Append(s.pendingUsageRecords)
+	s.pendingUsageRecords = nil
+}
+
+func (s *HivedScheduler) getUsageReport(startTime, endTime time.Time) si.UsageReport {
+	if s.usageLedger == nil {
+		panic(internal.NewBadRequestError(
+			"Usage ledger is not enabled, see Config usageLedgerFile"))
+	}
+	if now := time.Now(); endTime.After(now) {
+		endTime = now
+	}
+	if startTime.After(endTime) {
+		panic(internal.NewBadRequestError(
+			"The start time of the usage report is after its end time"))
+	}
+	return internal.AggregateUsageRecords(
+		s.usageLedger.List(startTime, endTime), startTime, endTime)
+}
@@ -0,0 +1,459 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package scheduler
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/common"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/dynamic/dynamiclister"
+	"k8s.io/klog"
+)
+
+// The VirtualClusterStatus is summarized to the VirtualCluster custom resources
+// periodically, instead of on each change, to avoid flooding ApiServer.
+const virtualClusterStatusSyncPeriod = 10 * time.Second
+
+func (s *HivedScheduler) initVirtualClusterResource(dClient dynamic.Interface) {
+	gvr := internal.NewVirtualClusterResourceGVR()
+	informer := dynamicinformer.NewDynamicSharedInformerFactory(dClient, 0).
+		ForResource(gvr).Informer()
+
+	s.virtualClusterClient = dClient.Resource(gvr)
+	s.virtualClusterInformer = informer
+	s.virtualClusterLister = dynamiclister.New(informer.GetIndexer(), gvr)
+}
+
+// Get the VirtualClusters in the config, overridden by the VirtualCluster
+// custom resources, if enabled.
+func (s *HivedScheduler) getDesiredVirtualClusters() map[si.VirtualClusterName]si.VirtualClusterSpec {
+	vcs := map[si.VirtualClusterName]si.VirtualClusterSpec{}
+	for vcn, spec := range *s.sConfig.VirtualClusters {
+		vcs[vcn] = spec
+	}
+	if s.virtualClusterLister == nil {
+		return vcs
+	}
+
+	objs, err := s.virtualClusterLister.List(labels.Everything())
+	if err != nil {
+		panic(fmt.Errorf("Failed to list VirtualClusters from local cache: %v", err))
+	}
+	for _, obj := range objs {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					klog.Warningf("[%v]: Skipped VirtualCluster: %v", obj.GetName(), r)
+				}
+			}()
+			vcs[si.VirtualClusterName(obj.GetName())] = internal.ToVirtualClusterResource(obj).Spec
+		}()
+	}
+	return vcs
+}
+
+// Apply the desired VirtualClusters by replacing the SchedulerAlgorithm, if
+// they are changed and still safe, i.e. they can be guaranteed by the physical
+// cluster and all the allocated affinity groups can be restored under them.
+// Otherwise, the current ones are kept for the unsafe VirtualClusters.
+func (s *HivedScheduler) applyVirtualClusters() {
+	s.schedulerLock.Lock()
+	defer s.schedulerLock.Unlock()
+
+	logPfx := "applyVirtualClusters: "
+	defer internal.HandleInformerPanic(logPfx, false)
+
+	vcs := s.getDesiredVirtualClusters()
+	if reflect.DeepEqual(vcs, s.virtualClusters) {
+		s.virtualClusterApplyErrors = nil
+		return
+	}
+
+	vcs, schedulerAlgorithm, applyErrors := s.tryChangeVirtualClusters(
+		s.virtualClusters, vcs, s.tryNewRecoveredSchedulerAlgorithm)
+	s.virtualClusterApplyErrors = applyErrors
+	for vcn, err := range applyErrors {
+		klog.Warningf(logPfx+"[%v]: Rejected since VirtualCluster is unsafe: %v", vcn, err)
+	}
+	if schedulerAlgorithm == nil {
+		return
+	}
+
+	klog.Infof(logPfx+"Applying VirtualClusters: %v", common.ToJson(vcs))
+	s.replaceSchedulerAlgorithm(schedulerAlgorithm)
+	s.virtualClusters = vcs
+	klog.Infof(logPfx + "Succeeded")
+}
+
+// Try to create a SchedulerAlgorithm with the VirtualClusters changed from the
+// applied ones to the desired ones by tryFunc. If it fails, the changes of the
+// VirtualClusters which fail by themselves are rejected, and the others are
+// tried again.
+// It returns the VirtualClusters to apply, the SchedulerAlgorithm created for
+// them (nil if all the changes are rejected), and the errors of the rejected
+// VirtualClusters.
+func (s *HivedScheduler) tryChangeVirtualClusters(
+	applied map[si.VirtualClusterName]si.VirtualClusterSpec,
+	desired map[si.VirtualClusterName]si.VirtualClusterSpec,
+	tryFunc func(map[si.VirtualClusterName]si.VirtualClusterSpec) (internal.SchedulerAlgorithm, error)) (
+	map[si.VirtualClusterName]si.VirtualClusterSpec,
+	internal.SchedulerAlgorithm,
+	map[si.VirtualClusterName]string) {
+	changed := getChangedVirtualClusters(applied, desired)
+	if len(changed) == 0 {
+		return applied, nil, nil
+	}
+	schedulerAlgorithm, err := tryFunc(desired)
+	if err == nil {
+		return desired, schedulerAlgorithm, nil
+	}
+
+	applyErrors := map[si.VirtualClusterName]string{}
+	var accepted []si.VirtualClusterName
+	if len(changed) > 1 {
+		for _, vcn := range changed {
+			if _, vcErr := tryFunc(changeVirtualClusters(applied, desired, vcn)); vcErr != nil {
+				applyErrors[vcn] = vcErr.Error()
+			} else {
+				accepted = append(accepted, vcn)
+			}
+		}
+	}
+	if len(applyErrors) > 0 && len(accepted) > 0 {
+		vcs := changeVirtualClusters(applied, desired, accepted...)
+		if schedulerAlgorithm, err = tryFunc(vcs); err == nil {
+			return vcs, schedulerAlgorithm, applyErrors
+		}
+	}
+	// The changes are unsafe only together, so all of them are rejected.
+	for _, vcn := range changed {
+		if _, ok := applyErrors[vcn]; !ok {
+			applyErrors[vcn] = err.Error()
+		}
+	}
+	return applied, nil, applyErrors
+}
+
+// Get the names of the VirtualClusters which are added, deleted or updated.
+func getChangedVirtualClusters(
+	applied map[si.VirtualClusterName]si.VirtualClusterSpec,
+	desired map[si.VirtualClusterName]si.VirtualClusterSpec) []si.VirtualClusterName {
+	changed := []si.VirtualClusterName{}
+	for vcn, spec := range desired {
+		if appliedSpec, ok := applied[vcn]; !ok || !reflect.DeepEqual(appliedSpec, spec) {
+			changed = append(changed, vcn)
+		}
+	}
+	for vcn := range applied {
+		if _, ok := desired[vcn]; !ok {
+			changed = append(changed, vcn)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i] < changed[j] })
+	return changed
+}
+
+// Get the applied VirtualClusters with only the given ones changed to the
+// desired ones.
+func changeVirtualClusters(
+	applied map[si.VirtualClusterName]si.VirtualClusterSpec,
+	desired map[si.VirtualClusterName]si.VirtualClusterSpec,
+	vcns ...si.VirtualClusterName) map[si.VirtualClusterName]si.VirtualClusterSpec {
+	vcs := map[si.VirtualClusterName]si.VirtualClusterSpec{}
+	for vcn, spec := range applied {
+		vcs[vcn] = spec
+	}
+	for _, vcn := range vcns {
+		if spec, ok := desired[vcn]; ok {
+			vcs[vcn] = spec
+		} else {
+			delete(vcs, vcn)
+		}
+	}
+	return vcs
+}
+
+// The same as tryNewSchedulerAlgorithm except that the scheduling view is also
+// recovered, and the error is returned if any allocated affinity group cannot
+// be restored in it, such as it would be lazy preempted.
+// The caller should hold the schedulerLock.
+func (s *HivedScheduler) tryNewRecoveredSchedulerAlgorithm(
+	virtualClusters map[si.VirtualClusterName]si.VirtualClusterSpec) (
+	sa internal.SchedulerAlgorithm, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	sa = s.newSchedulerAlgorithm(virtualClusters)
+	s.recoverSchedulerAlgorithm(sa, s.listAllocatedPods())
+
+	restoredGroups := map[string]si.AffinityGroup{}
+	for _, g := range sa.GetAllAffinityGroups().Items {
+		restoredGroups[g.Name] = g
+	}
+	for _, g := range s.schedulerAlgorithm.GetAllAffinityGroups().Items {
+		if len(g.Status.AllocatedPods) == 0 {
+			// The preempting group will be scheduled again.
+			continue
+		}
+		rg, ok := restoredGroups[g.Name]
+		if !ok || len(rg.Status.AllocatedPods) != len(g.Status.AllocatedPods) ||
+			(rg.Status.LazyPreemptionStatus != nil && g.Status.LazyPreemptionStatus == nil) {
+			return nil, fmt.Errorf(
+				"Allocated affinity group %v in VC %v cannot be restored", g.Name, g.Status.VC)
+		}
+	}
+	return sa, nil
+}
+
+// Replace the SchedulerAlgorithm with a new one, whose scheduling view has been
+// recovered in the same way as the scheduler restarts.
+// The caller should hold the schedulerLock.
+func (s *HivedScheduler) replaceSchedulerAlgorithm(schedulerAlgorithm internal.SchedulerAlgorithm) {
+	if oldSa, ok := s.schedulerAlgorithm.(internal.WatchingSchedulerAlgorithm); ok {
+		if sa, ok := schedulerAlgorithm.(internal.WatchingSchedulerAlgorithm); ok {
+			// Continue the versions, so that the ones got from the replaced
+			// SchedulerAlgorithm expire instead of being resumed wrongly.
+			_, resourceVersion, _ := oldSa.Watch(0)
+			sa.StartWatchFrom(resourceVersion)
+		}
+	}
+	if sa, ok := schedulerAlgorithm.(internal.InheritingSchedulerAlgorithm); ok {
+		sa.Inherit(s.schedulerAlgorithm)
+	}
+	recoverAdministratedStatus(schedulerAlgorithm, s.getAdministratedStatus())
+	if s.usageLedger != nil {
+		// Append the UsageRecords not taken yet, before they are resynced from
+		// the new SchedulerAlgorithm.
+		s.appendUsageRecords(s.schedulerAlgorithm)
+	}
+	for uid, podStatus := range s.podScheduleStatuses {
+		if !internal.IsAllocated(podStatus.PodState) {
+			// The preemption may be stale under the new VirtualClusters, so the
+			// preempting Pod has to be scheduled again.
+			schedulerAlgorithm.AddUnallocatedPod(podStatus.Pod)
+			s.podScheduleStatuses[uid] = &internal.PodScheduleStatus{
+				Pod:               podStatus.Pod,
+				PodState:          internal.PodWaiting,
+				PodScheduleResult: nil,
+			}
+		}
+	}
+
+	s.metrics.rebaseAlgorithmCounters(s.schedulerAlgorithm, schedulerAlgorithm)
+	if sa, ok := s.schedulerAlgorithm.(internal.StoppableSchedulerAlgorithm); ok {
+		sa.Stop()
+	}
+	s.schedulerAlgorithm = schedulerAlgorithm
+}
+
+// Recover the scheduling view of a new SchedulerAlgorithm from the Nodes and
+// the allocated Pods, in the same way as the scheduler restarts.
+func (s *HivedScheduler) recoverSchedulerAlgorithm(
+	schedulerAlgorithm internal.SchedulerAlgorithm, allocatedPods []*core.Pod) {
+	nodes, err := s.nodeLister.List(labels.Everything())
+	if err != nil {
+		panic(fmt.Errorf("Failed to list Nodes: %v", err))
+	}
+	for _, node := range nodes {
+		schedulerAlgorithm.AddNode(node)
+	}
+	for _, pod := range allocatedPods {
+		schedulerAlgorithm.AddAllocatedPod(pod)
+	}
+}
+
+// The caller should hold the schedulerLock.
+func (s *HivedScheduler) listAllocatedPods() []*core.Pod {
+	pods := []*core.Pod{}
+	for _, podStatus := range s.podScheduleStatuses {
+		if internal.IsAllocated(podStatus.PodState) {
+			pods = append(pods, podStatus.Pod)
+		}
+	}
+	return pods
+}
+
+// The status changed by the Admin API, which cannot be recovered from the Nodes
+// and Pods.
+type administratedStatus struct {
+	drainingCells       []si.CellAddress
+	badCells            []si.CellAddress
+	lazyPreemptedGroups []string
+}
+
+// The caller should hold the schedulerLock.
+func (s *HivedScheduler) getAdministratedStatus() administratedStatus {
+	status := administratedStatus{}
+	for item := range s.drainingCells.Items() {
+		status.drainingCells = append(status.drainingCells, item.(si.CellAddress))
+	}
+	for item := range s.badCells.Items() {
+		status.badCells = append(status.badCells, item.(si.CellAddress))
+	}
+	for _, g := range s.schedulerAlgorithm.GetAllAffinityGroups().Items {
+		if g.Status.LazyPreemptionStatus != nil {
+			status.lazyPreemptedGroups = append(status.lazyPreemptedGroups, g.Name)
+		}
+	}
+	return status
+}
+
+// Recover the administrated status of a new SchedulerAlgorithm, i.e. the
+// draining and bad cells and the lazy preempted affinity groups.
+// The failure to recover a status is only logged, since it may be invalid under
+// the new VirtualClusters, such as the group cannot be lazy preempted anymore.
+func recoverAdministratedStatus(
+	schedulerAlgorithm internal.SchedulerAlgorithm, status administratedStatus) {
+	recoverStatus := func(name string, recoverFunc func()) {
+		var err error
+		defer internal.RecoverAsError(&err)
+		defer func() {
+			if err != nil {
+				klog.Warningf("[%v]: Failed to recover administrated status: %v", name, err)
+			}
+		}()
+		recoverFunc()
+	}
+
+	for _, address := range status.drainingCells {
+		address := address
+		recoverStatus(string(address), func() { schedulerAlgorithm.SetCellDraining(address, true) })
+	}
+	algorithm, ok := schedulerAlgorithm.(internal.AdministratingSchedulerAlgorithm)
+	if !ok {
+		return
+	}
+	for _, address := range status.badCells {
+		address := address
+		recoverStatus(string(address), func() { algorithm.SetCellBad(address, true) })
+	}
+	lazyPreempted := map[string]bool{}
+	for _, g := range schedulerAlgorithm.GetAllAffinityGroups().Items {
+		lazyPreempted[g.Name] = g.Status.LazyPreemptionStatus != nil
+	}
+	for _, name := range status.lazyPreemptedGroups {
+		if !lazyPreempted[name] {
+			name := name
+			recoverStatus(name, func() { algorithm.SetAffinityGroupLazyPreempted(name, true) })
+		}
+	}
+}
+
+// Summarize the VirtualClusterStatus and expose whether the Spec is applied to
+// all the VirtualCluster custom resources.
+// It is only synced by the leader, since the standby replicas see the same.
+func (s *HivedScheduler) syncVirtualClusterStatuses() {
+	if !s.isLeader() {
+		return
+	}
+
+	objs, err := s.virtualClusterLister.List(labels.Everything())
+	if err != nil {
+		klog.Warningf("Failed to list VirtualClusters from local cache: %v", err)
+		return
+	}
+	for _, obj := range objs {
+		s.syncVirtualClusterStatus(obj)
+	}
+}
+
+func (s *HivedScheduler) syncVirtualClusterStatus(obj *unstructured.Unstructured) {
+	logPfx := fmt.Sprintf("[%v]: syncVirtualClusterStatus: ", obj.GetName())
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Warningf(logPfx+"Skipped: %v", r)
+		}
+	}()
+
+	vc := internal.ToVirtualClusterResource(obj)
+	vcn := si.VirtualClusterName(vc.Name)
+	status := s.getVirtualClusterResourceStatus(vcn, vc.Spec)
+	if common.ToJson(status) == common.ToJson(vc.Status) {
+		return
+	}
+
+	statusObj := map[string]interface{}{}
+	common.FromJson(common.ToJson(status), &statusObj)
+	newObj := obj.DeepCopy()
+	newObj.Object["status"] = statusObj
+	if _, err := s.virtualClusterClient.UpdateStatus(newObj, meta.UpdateOptions{}); err != nil {
+		panic(fmt.Errorf("Failed to update VirtualCluster status: %v", err))
+	}
+	klog.Infof(logPfx+"Updated: %v", common.ToJson(status.Conditions))
+}
+
+func (s *HivedScheduler) getVirtualClusterResourceStatus(
+	vcn si.VirtualClusterName, spec si.VirtualClusterSpec) si.VirtualClusterResourceStatus {
+	s.schedulerLock.RLock()
+	defer s.schedulerLock.RUnlock()
+
+	status := si.VirtualClusterResourceStatus{}
+	applied := si.VirtualClusterCondition{
+		Type:   si.VirtualClusterConditionApplied,
+		Status: "True",
+	}
+	if appliedSpec, ok := s.virtualClusters[vcn]; !ok || !reflect.DeepEqual(appliedSpec, spec) {
+		applied.Status = "False"
+		applied.Reason = "Unsafe"
+		applied.Message = s.virtualClusterApplyErrors[vcn]
+	}
+	status.Conditions = []si.VirtualClusterCondition{applied}
+
+	if _, ok := s.virtualClusters[vcn]; ok {
+		status.LeafCells = map[string]si.VirtualClusterLeafCellSummary{}
+		summarizeVirtualCells(s.schedulerAlgorithm.GetVirtualClusterStatus(vcn), status.LeafCells)
+	}
+	return status
+}
+
+func summarizeVirtualCells(
+	cells []*si.VirtualCellStatus, summary map[string]si.VirtualClusterLeafCellSummary) {
+	for _, c := range cells {
+		if len(c.CellChildren) > 0 {
+			summarizeVirtualCells(c.CellChildren, summary)
+			continue
+		}
+		s := summary[string(c.CellType)]
+		s.Total++
+		if c.CellState != "Free" {
+			s.Used++
+		}
+		if c.CellHealthiness == si.CellBad {
+			s.Bad++
+		}
+		summary[string(c.CellType)] = s
+	}
+}
@@ -0,0 +1,366 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package webserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"io/ioutil"
+	authn "k8s.io/api/authentication/v1"
+	kubeClient "k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The period to cache the result of a successful TokenReview, to avoid
+	// reviewing the same token for every request.
+	tokenReviewCacheTTL = 1 * time.Minute
+	// The max number of the cached TokenReview results.
+	maxTokenReviewCacheSize = 1000
+	// The min period to check whether the certificate files are changed, to avoid
+	// checking them for every TLS handshake.
+	certificateRecheckPeriod = 5 * time.Second
+)
+
+// The authenticated user of a request.
+type userInfo struct {
+	name   string
+	groups []string
+	// Whether the user is authenticated by a token in the WebServerAdminTokenFile
+	adminToken bool
+}
+
+type userInfoKey struct{}
+
+// requestUser returns the authenticated user of the request, or nil if it is
+// not authenticated.
+func requestUser(r *http.Request) *userInfo {
+	u, _ := r.Context().Value(userInfoKey{}).(*userInfo)
+	return u
+}
+
+type adminToken struct {
+	token string
+	user  string
+}
+
+type cachedUserInfo struct {
+	user     *userInfo
+	expireAt time.Time
+}
+
+// An in-flight TokenReview of a token, which is shared by the concurrent requests
+// with the same token.
+type tokenReviewCall struct {
+	done chan struct{}
+	user *userInfo
+}
+
+// authenticator authenticates the requests by the client certificates and the
+// bearer tokens, see si.WebServerAuthenticationSpec.
+type authenticator struct {
+	spec *si.WebServerAuthenticationSpec
+	// Tokens in the WebServerAdminTokenFile, nil means not specified
+	adminTokens []adminToken
+	// Client to review the bearer tokens, nil means TokenReview is disabled
+	kClient          kubeClient.Interface
+	tokenReviewLock  sync.Mutex
+	tokenReviewCache map[string]cachedUserInfo
+	tokenReviewCalls map[string]*tokenReviewCall
+}
+
+func newAuthenticator(sConfig *si.Config, kClient kubeClient.Interface) *authenticator {
+	a := &authenticator{spec: sConfig.WebServerAuthentication}
+	if sConfig.WebServerAdminTokenFile != nil {
+		a.adminTokens = loadAdminTokens(*sConfig.WebServerAdminTokenFile)
+	}
+	if a.spec != nil && a.spec.TokenReviewEnable {
+		if kClient == nil {
+			panic(fmt.Errorf("TokenReview is enabled without KubeClient"))
+		}
+		a.kClient = kClient
+		a.tokenReviewCache = map[string]cachedUserInfo{}
+		a.tokenReviewCalls = map[string]*tokenReviewCall{}
+	}
+	if a.spec != nil && a.adminTokens == nil && a.kClient == nil &&
+		(sConfig.WebServerTLS == nil || sConfig.WebServerTLS.ClientCAFile == "") {
+		panic(fmt.Errorf(
+			"WebServerAuthentication is specified without any authentication method, " +
+				"i.e. WebServerTLS.ClientCAFile, WebServerAdminTokenFile or TokenReviewEnable"))
+	}
+	return a
+}
+
+// authenticate returns the request with its authenticated user in the context.
+// If the WebServerAuthentication is specified, it panics if the request is not
+// authenticated or its user is not allowed.
+func (a *authenticator) authenticate(r *http.Request) *http.Request {
+	u := a.authenticateClientCertificate(r)
+	if u == nil {
+		u = a.authenticateBearerToken(r)
+	}
+	if a.spec != nil {
+		if u == nil {
+			panic(si.NewWebServerError(
+				http.StatusUnauthorized,
+				"Unauthorized: a verified client certificate or a valid bearer token is required"))
+		}
+		if !a.isAllowed(u) {
+			panic(si.NewWebServerError(
+				http.StatusForbidden,
+				fmt.Sprintf("Forbidden: user %q is not allowed", u.name)))
+		}
+	}
+	if u == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), userInfoKey{}, u))
+}
+
+func (a *authenticator) authenticateClientCertificate(r *http.Request) *userInfo {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	return &userInfo{name: subject.CommonName, groups: subject.Organization}
+}
+
+func (a *authenticator) authenticateBearerToken(r *http.Request) *userInfo {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	for _, t := range a.adminTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.token)) == 1 {
+			return &userInfo{name: t.user, adminToken: true}
+		}
+	}
+	if a.kClient != nil {
+		return a.reviewToken(token)
+	}
+	return nil
+}
+
+// reviewToken authenticates the token by the K8S TokenReview API, and caches the
+// successful result for tokenReviewCacheTTL.
+// The TokenReview is called out of the tokenReviewLock, so that a slow ApiServer
+// does not block the requests with the other tokens, and it is called only once
+// for the concurrent requests with the same token.
+func (a *authenticator) reviewToken(token string) *userInfo {
+	a.tokenReviewLock.Lock()
+	if c, ok := a.tokenReviewCache[token]; ok && time.Now().Before(c.expireAt) {
+		a.tokenReviewLock.Unlock()
+		return c.user
+	}
+	if call, ok := a.tokenReviewCalls[token]; ok {
+		a.tokenReviewLock.Unlock()
+		<-call.done
+		return call.user
+	}
+	call := &tokenReviewCall{done: make(chan struct{})}
+	a.tokenReviewCalls[token] = call
+	a.tokenReviewLock.Unlock()
+
+	defer func() {
+		a.tokenReviewLock.Lock()
+		defer a.tokenReviewLock.Unlock()
+
+		delete(a.tokenReviewCalls, token)
+		if call.user != nil {
+			if len(a.tokenReviewCache) >= maxTokenReviewCacheSize {
+				a.tokenReviewCache = map[string]cachedUserInfo{}
+			}
+			a.tokenReviewCache[token] = cachedUserInfo{
+				user: call.user, expireAt: time.Now().Add(tokenReviewCacheTTL)}
+		}
+		close(call.done)
+	}()
+	call.user = a.callTokenReview(token)
+	return call.user
+}
+
+func (a *authenticator) callTokenReview(token string) *userInfo {
+	review, err := a.kClient.AuthenticationV1().TokenReviews().Create(
+		&authn.TokenReview{Spec: authn.TokenReviewSpec{Token: token}})
+	if err != nil {
+		klog.Warningf("Failed to review the bearer token: %v", err)
+		return nil
+	}
+	if !review.Status.Authenticated {
+		return nil
+	}
+	return &userInfo{name: review.Status.User.Username, groups: review.Status.User.Groups}
+}
+
+func (a *authenticator) isAllowed(u *userInfo) bool {
+	if len(a.spec.AllowedUsers) == 0 && len(a.spec.AllowedGroups) == 0 {
+		return true
+	}
+	return u.isIn(a.spec.AllowedUsers, a.spec.AllowedGroups)
+}
+
+// isAdminEnabled returns whether any user can be allowed to call the Admin API.
+func (a *authenticator) isAdminEnabled() bool {
+	return a.adminTokens != nil ||
+		(a.spec != nil && (len(a.spec.AdminUsers) > 0 || len(a.spec.AdminGroups) > 0))
+}
+
+// isAdmin returns whether the authenticated user is allowed to call the Admin API.
+func (a *authenticator) isAdmin(u *userInfo) bool {
+	return u.adminToken || (a.spec != nil && u.isIn(a.spec.AdminUsers, a.spec.AdminGroups))
+}
+
+// isIn returns whether the user is one of the users or in one of the groups.
+func (u *userInfo) isIn(users []string, groups []string) bool {
+	for _, user := range users {
+		if u.name == user {
+			return true
+		}
+	}
+	for _, group := range groups {
+		for _, g := range u.groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func loadAdminTokens(filePath string) []adminToken {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		panic(fmt.Errorf(
+			"Failed to read WebServerAdminTokenFile: %v, %v", filePath, err))
+	}
+
+	tokens := []adminToken{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, ",", 2)
+		t := adminToken{token: strings.TrimSpace(fields[0])}
+		if len(fields) > 1 {
+			t.user = strings.TrimSpace(fields[1])
+		}
+		tokens = append(tokens, t)
+	}
+	if len(tokens) == 0 {
+		panic(fmt.Errorf(
+			"No token is found in WebServerAdminTokenFile: %v", filePath))
+	}
+	return tokens
+}
+
+// newTLSConfig creates the tls.Config of the WebServer, see si.WebServerTLSSpec.
+func newTLSConfig(spec *si.WebServerTLSSpec) *tls.Config {
+	config := &tls.Config{
+		GetCertificate: newCertificateReloader(spec.CertFile, spec.KeyFile).GetCertificate,
+	}
+	if spec.ClientCAFile != "" {
+		caBytes, err := ioutil.ReadFile(spec.ClientCAFile)
+		if err != nil {
+			panic(fmt.Errorf(
+				"Failed to read WebServerTLS clientCAFile: %v, %v", spec.ClientCAFile, err))
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(caBytes) {
+			panic(fmt.Errorf(
+				"No certificate is found in WebServerTLS clientCAFile: %v", spec.ClientCAFile))
+		}
+		// The clients without certificates can still be authenticated by the
+		// bearer tokens.
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config
+}
+
+// certificateReloader serves the certificate loaded from the files, and reloads
+// it once the files are changed.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock      sync.Mutex
+	cert      *tls.Certificate
+	modTimes  [2]time.Time
+	checkedAt time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) *certificateReloader {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reloadIfChanged(); err != nil {
+		panic(fmt.Errorf("Failed to load WebServerTLS certificate: %v", err))
+	}
+	return r
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if time.Since(r.checkedAt) < certificateRecheckPeriod {
+		return r.cert, nil
+	}
+	if err := r.reloadIfChanged(); err != nil {
+		klog.Warningf("Failed to reload WebServerTLS certificate, keep using the previous one: %v", err)
+	}
+	return r.cert, nil
+}
+
+func (r *certificateReloader) reloadIfChanged() error {
+	r.checkedAt = time.Now()
+	modTimes := [2]time.Time{}
+	for i, f := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if r.cert != nil && modTimes == r.modTimes {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil {
+		klog.Infof("WebServerTLS certificate reloaded from %v", r.certFile)
+	}
+	r.cert = &cert
+	r.modTimes = modTimes
+	return nil
+}
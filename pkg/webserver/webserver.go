@@ -24,78 +24,179 @@ package webserver
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	si "github.com/microsoft/hivedscheduler/pkg/api"
 	"github.com/microsoft/hivedscheduler/pkg/common"
 	"github.com/microsoft/hivedscheduler/pkg/internal"
+	"io/ioutil"
+	core "k8s.io/api/core/v1"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	kubeClient "k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 	ei "k8s.io/kubernetes/pkg/scheduler/api"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
 	ComponentName = "webserver"
+
+	// The period to send heartbeats on an idle watch, to keep the connection
+	// alive and detect the scheduler view change timely.
+	watchHeartbeatPeriod = 30 * time.Second
 )
 
 // The WebServer of HivedScheduler.
 type WebServer struct {
 	sConfig *si.Config
 
-	// The backend http server
-	server *http.Server
+	// The listener of the Scheduler Extender API and Admission API at
+	// WebServerAddress, and the listener of the other APIs at
+	// InspectWebServerAddress, which is the same one if it is not specified.
+	extenderListener *webListener
+	inspectListener  *webListener
 
-	// All paths to serve
-	paths si.WebServerPaths
+	// The TLS config of all listeners, nil means HTTP
+	tlsConfig *tls.Config
+
+	// Authenticator of all requests
+	authenticator *authenticator
 
 	// Scheduler Extender Callbacks from K8S Default Scheduler
 	eHandlers internal.ExtenderHandlers
 
 	// Scheduler Inspect Callbacks
 	iHandlers internal.InspectHandlers
+
+	// Scheduler Admin Callbacks
+	aHandlers internal.AdminHandlers
+
+	// Scheduler Admission Callbacks from K8S ApiServer
+	admHandlers internal.AdmissionHandlers
+}
+
+// webListener serves a part of the paths of the WebServer at an address.
+type webListener struct {
+	// The config name of the address, such as WebServerAddress
+	name    string
+	address string
+
+	// Each listener has its own mux, so that multiple WebServers can coexist
+	// in one process
+	mux *http.ServeMux
+
+	// The backend http server
+	server *http.Server
+
+	// All paths to serve
+	paths si.WebServerPaths
+
+	// The actual listener, only available after the WebServer runs
+	listener net.Listener
+}
+
+func newWebListener(name string, address string) *webListener {
+	mux := http.NewServeMux()
+	return &webListener{
+		name:    name,
+		address: address,
+		mux:     mux,
+		server:  &http.Server{Addr: address, Handler: mux},
+		paths:   si.WebServerPaths{Paths: []string{}},
+	}
 }
 
+// The kClient is only used by TokenReview, so it can be nil if TokenReview is
+// not enabled.
 func NewWebServer(sConfig *si.Config,
+	kClient kubeClient.Interface,
 	eHandlers internal.ExtenderHandlers,
-	iHandlers internal.InspectHandlers) *WebServer {
+	iHandlers internal.InspectHandlers,
+	aHandlers internal.AdminHandlers,
+	admHandlers internal.AdmissionHandlers) *WebServer {
 	klog.Infof("Initializing " + ComponentName)
 
 	ws := &WebServer{
-		sConfig: sConfig,
-		server: &http.Server{
-			Addr: *sConfig.WebServerAddress,
-		},
-		paths:     si.WebServerPaths{Paths: []string{}},
-		eHandlers: eHandlers,
-		iHandlers: iHandlers,
-	}
-
-	ws.route(si.RootPath, ws.serve(ws.serveRootPath))
-	ws.route(si.FilterPath, ws.serve(ws.serveFilterPath))
-	ws.route(si.BindPath, ws.serve(ws.serveBindPath))
-	ws.route(si.PreemptPath, ws.serve(ws.servePreemptPath))
-	ws.route(si.AffinityGroupsPath, ws.serve(ws.serveAffinityGroups))
-	ws.route(si.ClusterStatusPath, ws.serve(ws.serveClusterStatus))
-	ws.route(si.PhysicalClusterPath, ws.serve(ws.servePhysicalClusterStatus))
-	ws.route(si.VirtualClustersPath, ws.serve(ws.serveVirtualClustersStatus))
+		sConfig:          sConfig,
+		extenderListener: newWebListener("WebServerAddress", *sConfig.WebServerAddress),
+		authenticator:    newAuthenticator(sConfig, kClient),
+		eHandlers:        eHandlers,
+		iHandlers:        iHandlers,
+		aHandlers:        aHandlers,
+		admHandlers:      admHandlers,
+	}
+	ws.inspectListener = ws.extenderListener
+	if *sConfig.InspectWebServerAddress != "" {
+		ws.inspectListener = newWebListener("InspectWebServerAddress", *sConfig.InspectWebServerAddress)
+	}
+	if sConfig.WebServerTLS != nil {
+		ws.tlsConfig = newTLSConfig(sConfig.WebServerTLS)
+	}
+	if !ws.authenticator.isAdminEnabled() {
+		klog.Warningf("Neither WebServerAdminTokenFile nor WebServerAuthentication AdminUsers " +
+			"or AdminGroups is specified, so the Admin API is disabled")
+	}
+
+	for _, l := range ws.listeners() {
+		ws.route(l, si.RootPath, ws.serve(ws.serveRootPath(l)))
+	}
+	el, il := ws.extenderListener, ws.inspectListener
+	ws.route(el, si.FilterPath, ws.serve(ws.serveFilterPath))
+	ws.route(el, si.BindPath, ws.serve(ws.serveBindPath))
+	ws.route(el, si.PreemptPath, ws.serve(ws.servePreemptPath))
+	ws.route(il, si.AffinityGroupsPath, ws.serve(ws.serveAffinityGroups))
+	ws.route(il, si.PodsPath, ws.serve(ws.servePods))
+	ws.route(il, si.ClusterStatusPath, ws.serve(ws.serveClusterStatus))
+	ws.route(il, si.PhysicalClusterPath, ws.serve(ws.servePhysicalClusterStatus))
+	ws.route(il, si.VirtualClustersPath, ws.serve(ws.serveVirtualClustersStatus))
+	ws.route(il, si.ClusterSummaryPath, ws.serve(ws.serveClusterSummary))
+	ws.route(il, si.PhysicalClusterSpecPath, ws.serve(ws.servePhysicalClusterSpec))
+	ws.route(il, si.LeaderElectionStatusPath, ws.serve(ws.serveLeaderElectionStatus))
+	ws.route(il, si.DryRunPath, ws.serve(ws.serveDryRun))
+	ws.route(il, si.WatchPath, ws.serve(ws.serveWatch))
+	ws.route(il, si.UsagePath, ws.serve(ws.serveUsage))
+	ws.route(il, si.MetricsPath, ws.serve(ws.serveMetrics))
+	ws.route(il, si.DrainingCellsPath, ws.serve(ws.admin(ws.serveDrainingCells)))
+	ws.route(il, si.BadCellsPath, ws.serve(ws.admin(ws.serveBadCells)))
+	ws.route(il, si.AdminAffinityGroupsPath, ws.serve(ws.admin(ws.serveAdminAffinityGroups)))
+	ws.route(el, si.ValidatePodPath, ws.serve(ws.serveValidatePodPath))
+	ws.route(el, si.MutatePodPath, ws.serve(ws.serveMutatePodPath))
 	return ws
 }
 
-func (ws *WebServer) route(path string, handler servePathHandler) {
-	http.HandleFunc(path, handler)
-	ws.paths.Paths = append(ws.paths.Paths, path)
+func (ws *WebServer) route(l *webListener, path string, handler servePathHandler) {
+	l.mux.HandleFunc(path, handler)
+	l.paths.Paths = append(l.paths.Paths, path)
+}
+
+// listeners returns the distinct listeners of the WebServer.
+func (ws *WebServer) listeners() []*webListener {
+	if ws.inspectListener == ws.extenderListener {
+		return []*webListener{ws.extenderListener}
+	}
+	return []*webListener{ws.extenderListener, ws.inspectListener}
 }
 
 func (ws *WebServer) AsyncRun(stopCh <-chan struct{}) <-chan struct{} {
-	ln, err := net.Listen("tcp", *ws.sConfig.WebServerAddress)
-	if err != nil {
-		panic(fmt.Errorf(
-			"Failed to listen on WebServerAddress: %v, %v",
-			*ws.sConfig.WebServerAddress, err))
+	listeners := ws.listeners()
+	for _, l := range listeners {
+		ln, err := net.Listen("tcp", l.address)
+		if err != nil {
+			panic(fmt.Errorf(
+				"Failed to listen on %v: %v, %v",
+				l.name, l.address, err))
+		}
+		l.listener = tcpKeepAliveListener{ln.(*net.TCPListener)}
+		if ws.tlsConfig != nil {
+			l.listener = tls.NewListener(l.listener, ws.tlsConfig)
+		}
 	}
 
 	stoppedCh := make(chan struct{})
@@ -105,16 +206,21 @@ func (ws *WebServer) AsyncRun(stopCh <-chan struct{}) <-chan struct{} {
 
 		klog.Errorf("Stopping " + ComponentName)
 		ctx, cancel := context.WithTimeout(context.Background(), 0)
-		ws.server.Shutdown(ctx)
+		for _, l := range listeners {
+			l.server.Shutdown(ctx)
+		}
 		cancel()
 	}()
 
-	go func() {
-		// Blocking until error
-		if err := ws.server.Serve(tcpKeepAliveListener{ln.(*net.TCPListener)}); err != nil {
-			panic(fmt.Errorf("Error occurred while running WebServer: %v", err))
-		}
-	}()
+	for _, l := range listeners {
+		l := l
+		go func() {
+			// Blocking until error
+			if err := l.server.Serve(l.listener); err != nil && err != http.ErrServerClosed {
+				panic(fmt.Errorf("Error occurred while running WebServer at %v: %v", l.name, err))
+			}
+		}()
+	}
 
 	klog.Infof("Running " + ComponentName)
 
@@ -145,23 +251,72 @@ func (ws *WebServer) serve(handler servePathHandler) servePathHandler {
 		defer internal.HandleWebServerPanic(func(err *si.WebServerError) {
 			// http.StatusOK is appended by default, so only need to explicitly specify
 			// error statusCode.
+			if err.Reason != "" {
+				w.Header().Set(si.HeaderKeyErrorReason, string(err.Reason))
+			}
 			w.WriteHeader(err.Code)
-			w.Write(common.ToJsonBytes(err.Message))
+			w.Write(common.ToJsonBytes(err))
 		})
 
 		w.Header().Set("Content-Type", "application/json")
-		handler(w, r)
+		handler(w, ws.authenticator.authenticate(r))
 	}
 }
 
-func (ws *WebServer) serveRootPath(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		panic(si.NewWebServerError(
-			http.StatusNotFound,
-			fmt.Sprintf("Path not found: %v", r.URL.Path)))
+// admin authorizes the request to the Scheduler Admin API, and records the
+// audit log of it together with its result.
+// The request must be authenticated by one of the tokens in the
+// WebServerAdminTokenFile, or its user must be in the WebServerAuthentication
+// AdminUsers or AdminGroups. So the Admin API is disabled if neither of them is
+// specified.
+func (ws *WebServer) admin(handler servePathHandler) servePathHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := ""
+		if u := requestUser(r); u != nil {
+			user = u.name
+		}
+		defer func() {
+			result := "Succeeded"
+			p := recover()
+			if p != nil {
+				result = "Failed: " + internal.AsWebServerError(p).Message
+			}
+			klog.Infof("[Audit]: %v %v by user %q from %v: %v",
+				r.Method, r.URL.Path, user, r.RemoteAddr, result)
+			if p != nil {
+				panic(p)
+			}
+		}()
+
+		if !ws.authenticator.isAdminEnabled() {
+			panic(si.NewWebServerError(
+				http.StatusForbidden,
+				"Forbidden: the Admin API is disabled since neither WebServerAdminTokenFile "+
+					"nor WebServerAuthentication AdminUsers or AdminGroups is specified"))
+		}
+		if u := requestUser(r); u == nil {
+			panic(si.NewWebServerError(
+				http.StatusUnauthorized,
+				"Unauthorized: a valid admin bearer token or an admin user is required by the Admin API"))
+		} else if !ws.authenticator.isAdmin(u) {
+			panic(si.NewWebServerError(
+				http.StatusForbidden,
+				fmt.Sprintf("Forbidden: user %q is not allowed to call the Admin API", u.name)))
+		}
+		handler(w, r)
 	}
+}
 
-	w.Write(common.ToJsonBytes(ws.paths))
+func (ws *WebServer) serveRootPath(l *webListener) servePathHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			panic(si.NewWebServerError(
+				http.StatusNotFound,
+				fmt.Sprintf("Path not found: %v", r.URL.Path)))
+		}
+
+		w.Write(common.ToJsonBytes(l.paths))
+	}
 }
 
 func (ws *WebServer) serveFilterPath(w http.ResponseWriter, r *http.Request) {
@@ -243,7 +398,16 @@ func (ws *WebServer) serveAffinityGroups(w http.ResponseWriter, r *http.Request)
 	name := strings.TrimPrefix(r.URL.Path, si.AffinityGroupsPath)
 	if name == "" {
 		if r.Method == http.MethodGet {
-			w.Write(common.ToJsonBytes(ws.iHandlers.GetAllAffinityGroupsHandler()))
+			query := r.URL.Query()
+			w.Write(common.ToJsonBytes(internal.PaginateAffinityGroups(
+				ws.iHandlers.GetAllAffinityGroupsHandler(),
+				parseIntQuery(query, "limit"), query.Get("continue"))))
+			return
+		}
+	} else if strings.HasSuffix(name, si.ExplainPathSuffix) {
+		if r.Method == http.MethodGet {
+			w.Write(common.ToJsonBytes(ws.iHandlers.ExplainAffinityGroupHandler(
+				strings.TrimSuffix(name, si.ExplainPathSuffix))))
 			return
 		}
 	} else {
@@ -258,9 +422,31 @@ func (ws *WebServer) serveAffinityGroups(w http.ResponseWriter, r *http.Request)
 		r.Method, r.URL.Path)))
 }
 
+func (ws *WebServer) servePods(w http.ResponseWriter, r *http.Request) {
+	uid := strings.TrimPrefix(r.URL.Path, si.PodsPath)
+	if uid == "" {
+		if r.Method == http.MethodGet {
+			query := r.URL.Query()
+			w.Write(common.ToJsonBytes(ws.iHandlers.GetAllPodScheduleStatusesHandler(
+				query.Get("namespace"), internal.PodState(query.Get("state")))))
+			return
+		}
+	} else {
+		if r.Method == http.MethodGet {
+			w.Write(common.ToJsonBytes(ws.iHandlers.GetPodScheduleStatusHandler(types.UID(uid))))
+			return
+		}
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
 func (ws *WebServer) serveClusterStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		w.Write(common.ToJsonBytes(ws.iHandlers.GetClusterStatusHandler()))
+		w.Write(common.ToJsonBytes(parseCellStatusFilter(r).FilterClusterStatus(
+			ws.iHandlers.GetClusterStatusHandler())))
 		return
 	}
 
@@ -271,7 +457,8 @@ func (ws *WebServer) serveClusterStatus(w http.ResponseWriter, r *http.Request)
 
 func (ws *WebServer) servePhysicalClusterStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		w.Write(common.ToJsonBytes(ws.iHandlers.GetPhysicalClusterStatusHandler()))
+		w.Write(common.ToJsonBytes(parseCellStatusFilter(r).FilterPhysicalClusterStatus(
+			ws.iHandlers.GetPhysicalClusterStatusHandler())))
 		return
 	}
 
@@ -284,12 +471,14 @@ func (ws *WebServer) serveVirtualClustersStatus(w http.ResponseWriter, r *http.R
 	name := strings.TrimPrefix(r.URL.Path, si.VirtualClustersPath)
 	if name == "" {
 		if r.Method == http.MethodGet {
-			w.Write(common.ToJsonBytes(ws.iHandlers.GetAllVirtualClustersStatusHandler()))
+			w.Write(common.ToJsonBytes(parseCellStatusFilter(r).FilterVirtualClustersStatus(
+				ws.iHandlers.GetAllVirtualClustersStatusHandler())))
 			return
 		}
 	} else {
 		if r.Method == http.MethodGet {
-			w.Write(common.ToJsonBytes(ws.iHandlers.GetVirtualClusterStatusHandler(si.VirtualClusterName(name))))
+			w.Write(common.ToJsonBytes(parseCellStatusFilter(r).FilterVirtualClusterStatus(
+				ws.iHandlers.GetVirtualClusterStatusHandler(si.VirtualClusterName(name)))))
 			return
 		}
 	}
@@ -298,3 +487,357 @@ func (ws *WebServer) serveVirtualClustersStatus(w http.ResponseWriter, r *http.R
 		"NotImplemented: %v: %v",
 		r.Method, r.URL.Path)))
 }
+
+// parseCellStatusFilter parses the CellStatusFilter from the query parameters:
+// vc, chain, node, state, healthiness, depth and crossLinks (true by default).
+func parseCellStatusFilter(r *http.Request) internal.CellStatusFilter {
+	query := r.URL.Query()
+	f := internal.CellStatusFilter{
+		VC:          si.VirtualClusterName(query.Get("vc")),
+		Chain:       query.Get("chain"),
+		Node:        query.Get("node"),
+		State:       si.CellState(query.Get("state")),
+		Healthiness: si.CellHealthiness(query.Get("healthiness")),
+		Depth:       parseIntQuery(query, "depth"),
+		CrossLinks:  true,
+	}
+	if crossLinks := query.Get("crossLinks"); crossLinks != "" {
+		var err error
+		if f.CrossLinks, err = strconv.ParseBool(crossLinks); err != nil {
+			panic(internal.NewBadRequestError(fmt.Sprintf(
+				"Invalid query parameter crossLinks %v: %v", crossLinks, err)))
+		}
+	}
+	return f
+}
+
+// parseIntQuery parses the non-negative integer query parameter, which is 0 if
+// it is not specified.
+func parseIntQuery(query url.Values, key string) int {
+	value := query.Get(key)
+	if value == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i < 0 {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"Invalid query parameter %v %v: it should be a non-negative integer", key, value)))
+	}
+	return i
+}
+
+func (ws *WebServer) serveClusterSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Write(common.ToJsonBytes(ws.iHandlers.GetClusterSummaryHandler()))
+		return
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) servePhysicalClusterSpec(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// Serve in the same YAML format as the config, so that it can be checked in.
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Write([]byte(common.ToYaml(ws.iHandlers.GetPhysicalClusterSpecHandler())))
+		return
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) serveLeaderElectionStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Write(common.ToJsonBytes(ws.iHandlers.GetLeaderElectionStatusHandler()))
+		return
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) serveDryRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		// The PodSchedulingSpec is in the same format as the Pod annotation.
+		spec, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			panic(internal.NewBadRequestError(fmt.Sprintf(
+				"Failed to read web request body: %v", err)))
+		}
+
+		w.Write(common.ToJsonBytes(ws.iHandlers.DryRunHandler(string(spec))))
+		return
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) serveUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// Default to the whole usage ledger until now.
+		query := r.URL.Query()
+		startTime := parseTime(query.Get("start"), time.Time{})
+		endTime := parseTime(query.Get("end"), time.Now())
+		w.Write(common.ToJsonBytes(ws.iHandlers.GetUsageReportHandler(startTime, endTime)))
+		return
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+// parseTime parses the time in RFC3339 format, or returns the default one if it
+// is empty.
+func parseTime(s string, defaultTime time.Time) time.Time {
+	if s == "" {
+		return defaultTime
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"Invalid time %v, it should be in RFC3339 format: %v", s, err)))
+	}
+	return t
+}
+
+func (ws *WebServer) serveWatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// Resume from the Last-Event-ID if the client is reconnecting by itself.
+		rvStr := r.URL.Query().Get("resourceVersion")
+		if rvStr == "" {
+			rvStr = r.Header.Get("Last-Event-ID")
+		}
+		rv := uint64(0)
+		if rvStr != "" {
+			var err error
+			if rv, err = strconv.ParseUint(rvStr, 10, 64); err != nil {
+				panic(internal.NewBadRequestError(fmt.Sprintf(
+					"Invalid resourceVersion %v: %v", rvStr, err)))
+			}
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			panic(fmt.Errorf("Streaming is not supported by the ResponseWriter"))
+		}
+
+		// Errors before the streaming starts are responded as usual.
+		events, latest, newer := ws.iHandlers.WatchHandler(rv)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if rv == 0 {
+			writeWatchEvent(w, si.WatchEvent{
+				Type:            si.WatchEventBookmark,
+				ResourceVersion: strconv.FormatUint(latest, 10),
+			})
+		}
+		for _, e := range events {
+			writeWatchEvent(w, e)
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(watchHeartbeatPeriod)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-newer:
+			case <-heartbeat.C:
+				// Also poll the events, in case the scheduler view is changed and
+				// the newer channel will never be closed.
+				w.Write([]byte(": heartbeat\n\n"))
+			case <-r.Context().Done():
+				return
+			}
+
+			err := func() (err error) {
+				defer internal.RecoverAsError(&err)
+				events, latest, newer = ws.iHandlers.WatchHandler(latest)
+				return nil
+			}()
+			if err != nil {
+				writeWatchEvent(w, si.WatchEvent{Type: si.WatchEventError, Message: err.Error()})
+				flusher.Flush()
+				return
+			}
+			for _, e := range events {
+				writeWatchEvent(w, e)
+			}
+			flusher.Flush()
+		}
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+// writeWatchEvent writes the WatchEvent in the Server-Sent Events format.
+func writeWatchEvent(w http.ResponseWriter, e si.WatchEvent) {
+	if e.ResourceVersion != "" {
+		fmt.Fprintf(w, "id: %v\n", e.ResourceVersion)
+	}
+	fmt.Fprintf(w, "event: %v\ndata: %v\n\n", e.Type, common.ToJson(e))
+}
+
+func (ws *WebServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", internal.MetricsContentType)
+		w.Write(ws.iHandlers.GetMetricsHandler())
+		return
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) serveDrainingCells(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, si.DrainingCellsPath)
+	if address != "" {
+		if r.Method == http.MethodPut {
+			ws.aHandlers.SetCellDrainingHandler(si.CellAddress(address), true)
+			return
+		} else if r.Method == http.MethodDelete {
+			ws.aHandlers.SetCellDrainingHandler(si.CellAddress(address), false)
+			return
+		}
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) serveBadCells(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, si.BadCellsPath)
+	if address != "" {
+		if r.Method == http.MethodPut {
+			ws.aHandlers.SetCellBadHandler(si.CellAddress(address), true)
+			return
+		} else if r.Method == http.MethodDelete {
+			ws.aHandlers.SetCellBadHandler(si.CellAddress(address), false)
+			return
+		}
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) serveAdminAffinityGroups(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, si.AdminAffinityGroupsPath)
+	if strings.HasSuffix(name, si.PreemptionPathSuffix) {
+		name = strings.TrimSuffix(name, si.PreemptionPathSuffix)
+		if name != "" && r.Method == http.MethodDelete {
+			ws.aHandlers.CancelAffinityGroupPreemptionHandler(name)
+			return
+		}
+	} else if strings.HasSuffix(name, si.LazyPreemptionPathSuffix) {
+		name = strings.TrimSuffix(name, si.LazyPreemptionPathSuffix)
+		if name != "" && r.Method == http.MethodPut {
+			ws.aHandlers.SetAffinityGroupLazyPreemptedHandler(name, true)
+			return
+		} else if name != "" && r.Method == http.MethodDelete {
+			ws.aHandlers.SetAffinityGroupLazyPreemptedHandler(name, false)
+			return
+		}
+	}
+
+	panic(internal.NewBadRequestError(fmt.Sprintf(
+		"NotImplemented: %v: %v",
+		r.Method, r.URL.Path)))
+}
+
+func (ws *WebServer) serveValidatePodPath(w http.ResponseWriter, r *http.Request) {
+	ws.serveAdmission(w, r, func(pod *core.Pod, response *si.AdmissionResponse) {
+		ws.admHandlers.ValidatePodHandler(pod)
+	})
+}
+
+func (ws *WebServer) serveMutatePodPath(w http.ResponseWriter, r *http.Request) {
+	ws.serveAdmission(w, r, func(pod *core.Pod, response *si.AdmissionResponse) {
+		if patches := ws.admHandlers.MutatePodHandler(pod); len(patches) > 0 {
+			response.Patch = common.ToJsonBytes(patches)
+			response.PatchType = common.PtrString("JSONPatch")
+		}
+	})
+}
+
+// Admit the Pod by the handler, which denies the Pod by User Error panic.
+type admitPodHandler func(pod *core.Pod, response *si.AdmissionResponse)
+
+func (ws *WebServer) serveAdmission(
+	w http.ResponseWriter, r *http.Request, handler admitPodHandler) {
+	var review si.AdmissionReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"Failed to unmarshal web request body to AdmissionReview: %v", err)))
+	}
+
+	// Args Validation
+	if review.Request == nil {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"AdmissionReview: Request field should not be nil: %v",
+			common.ToJson(review))))
+	}
+
+	response := &si.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	if err := ws.tryAdmitPod(review.Request, response, handler); err != nil {
+		// Platform Error falls back to the default error handling, so that it is
+		// up to the webhook failurePolicy whether to admit the Pod.
+		wsErr := internal.AsWebServerError(err)
+		if wsErr.Code >= http.StatusInternalServerError {
+			panic(wsErr)
+		}
+		response.Allowed = false
+		response.Result = &meta.Status{
+			Status:  meta.StatusFailure,
+			Code:    int32(wsErr.Code),
+			Reason:  meta.StatusReason(wsErr.Reason),
+			Message: fmt.Sprintf(si.ComponentName+": %v", wsErr.Message),
+		}
+		response.Patch = nil
+		response.PatchType = nil
+	}
+
+	w.Write(common.ToJsonBytes(&si.AdmissionReview{
+		TypeMeta: review.TypeMeta,
+		Response: response,
+	}))
+}
+
+func (ws *WebServer) tryAdmitPod(
+	request *si.AdmissionRequest,
+	response *si.AdmissionResponse,
+	handler admitPodHandler) (err error) {
+	defer internal.RecoverAsError(&err)
+
+	// Only the Pod creation needs to be admitted, since the PodSchedulingSpec
+	// cannot be changed after that.
+	if request.Operation != "CREATE" {
+		return nil
+	}
+
+	var pod core.Pod
+	if err := json.Unmarshal(request.Object.Raw, &pod); err != nil {
+		panic(internal.NewBadRequestError(fmt.Sprintf(
+			"Failed to unmarshal AdmissionRequest object to Pod: %v", err)))
+	}
+	// The Namespace may not be populated in the object yet.
+	if pod.Namespace == "" {
+		pod.Namespace = request.Namespace
+	}
+
+	handler(&pod, response)
+	return nil
+}
@@ -0,0 +1,302 @@
+// MIT License
+//
+// Copyright (c) Microsoft Corporation. All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE
+
+package webserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	si "github.com/microsoft/hivedscheduler/pkg/api"
+	"github.com/microsoft/hivedscheduler/pkg/common"
+	"github.com/microsoft/hivedscheduler/pkg/internal"
+	authn "k8s.io/api/authentication/v1"
+	kubeClient "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+func newTestWebServer(sConfig *si.Config, kClient kubeClient.Interface) *WebServer {
+	sConfig.WebServerAddress = common.PtrString("127.0.0.1:0")
+	if sConfig.InspectWebServerAddress == nil {
+		sConfig.InspectWebServerAddress = common.PtrString("")
+	}
+	return NewWebServer(sConfig, kClient,
+		internal.ExtenderHandlers{},
+		internal.InspectHandlers{
+			GetClusterStatusHandler: func() si.ClusterStatus { return si.ClusterStatus{} },
+		},
+		internal.AdminHandlers{
+			SetCellDrainingHandler: func(address si.CellAddress, draining bool) {},
+		},
+		internal.AdmissionHandlers{})
+}
+
+func TestSeparateListeners(t *testing.T) {
+	// Multiple WebServers can coexist in one process.
+	combined := newTestWebServer(&si.Config{}, nil)
+	separated := newTestWebServer(&si.Config{InspectWebServerAddress: common.PtrString("127.0.0.1:0")}, nil)
+
+	for _, c := range []struct {
+		l    *webListener
+		code int
+	}{
+		{combined.extenderListener, http.StatusOK},
+		{separated.extenderListener, http.StatusNotFound},
+		{separated.inspectListener, http.StatusOK},
+	} {
+		server := httptest.NewServer(c.l.mux)
+		resp, err := http.Get(server.URL + si.ClusterStatusPath)
+		server.Close()
+		if err != nil || resp.StatusCode != c.code {
+			t.Errorf("[%v]: Expected %v to be %v, but got %v, %v",
+				c.l.name, si.ClusterStatusPath, c.code, resp, err)
+		}
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	// The Admin API fails closed if no admin is specified.
+	ws := newTestWebServer(&si.Config{}, nil)
+	server := httptest.NewServer(ws.extenderListener.mux)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, server.URL+si.DrainingCellsPath+"node1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected the Admin API to be forbidden, but got %v, %v", resp, err)
+	}
+	defer resp.Body.Close()
+	// The error body is the structured WebServerError.
+	e := si.WebServerError{}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil ||
+		e.Code != http.StatusForbidden || e.Message == "" {
+		t.Errorf("Expected the error body to be the WebServerError, but got %v, %v", e, err)
+	}
+}
+
+func TestTLSAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCertificate(t, "ca", nil, nil, nil)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca.Leaf)
+	writeTestCertificate(t, dir, "ca", ca)
+	writeTestCertificate(t, dir, "server", newTestCertificate(t, "server", nil, &ca, nil))
+	tokenFile := filepath.Join(dir, "tokens")
+	ioutil.WriteFile(tokenFile, []byte("admin-token,bob\n"), 0600)
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		review := authn.TokenReview{}
+		json.NewDecoder(r.Body).Decode(&review)
+		if review.Spec.Token == "carol-token" {
+			review.Status = authn.TokenReviewStatus{
+				Authenticated: true,
+				User:          authn.UserInfo{Username: "carol", Groups: []string{"viewers"}},
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer apiServer.Close()
+	kClient := internal.CreateClient(&rest.Config{Host: apiServer.URL})
+
+	ws := newTestWebServer(&si.Config{
+		WebServerTLS: &si.WebServerTLSSpec{
+			CertFile:     filepath.Join(dir, "server.crt"),
+			KeyFile:      filepath.Join(dir, "server.key"),
+			ClientCAFile: filepath.Join(dir, "ca.crt"),
+		},
+		WebServerAuthentication: &si.WebServerAuthenticationSpec{
+			TokenReviewEnable: true,
+			AllowedUsers:      []string{"bob", "dave"},
+			AllowedGroups:     []string{"admins"},
+			AdminUsers:        []string{"dave"},
+		},
+		WebServerAdminTokenFile: common.PtrString(tokenFile),
+	}, kClient)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	ws.AsyncRun(stopCh)
+	url := "https://" + ws.extenderListener.listener.Addr().String()
+
+	alice := newTestCertificate(t, "alice", []string{"admins"}, &ca, nil)
+	dave := newTestCertificate(t, "dave", nil, &ca, nil)
+	for _, c := range []struct {
+		name   string
+		cert   *tls.Certificate
+		token  string
+		method string
+		path   string
+		code   int
+	}{
+		{"client certificate", &alice, "", http.MethodGet, si.ClusterStatusPath, http.StatusOK},
+		{"anonymous", nil, "", http.MethodGet, si.ClusterStatusPath, http.StatusUnauthorized},
+		{"invalid token", nil, "bad-token", http.MethodGet, si.ClusterStatusPath, http.StatusUnauthorized},
+		{"reviewed token of other group", nil, "carol-token", http.MethodGet, si.ClusterStatusPath, http.StatusForbidden},
+		{"admin token", nil, "admin-token", http.MethodPut, si.DrainingCellsPath + "node1", http.StatusOK},
+		{"client certificate to admin", &alice, "", http.MethodPut, si.DrainingCellsPath + "node1", http.StatusForbidden},
+		{"admin client certificate", &dave, "", http.MethodPut, si.DrainingCellsPath + "node1", http.StatusOK},
+	} {
+		config := &tls.Config{RootCAs: caPool}
+		if c.cert != nil {
+			config.Certificates = []tls.Certificate{*c.cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		req, _ := http.NewRequest(c.method, url+c.path, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := client.Do(req)
+		if err != nil || resp.StatusCode != c.code {
+			t.Errorf("[%v]: Expected %v, but got %v, %v", c.name, c.code, resp, err)
+		}
+	}
+
+	// The rotated serving certificate is used once the certificate files are
+	// rechecked.
+	r := newCertificateReloader(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+	rotated := newTestCertificate(t, "server", nil, &ca, big.NewInt(2))
+	writeTestCertificate(t, dir, "server", rotated)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "server.crt"), future, future)
+	if cert, _ := r.GetCertificate(nil); string(cert.Certificate[0]) == string(rotated.Certificate[0]) {
+		t.Errorf("Expected the certificate files not to be rechecked within %v", certificateRecheckPeriod)
+	}
+	r.checkedAt = time.Time{}
+	if cert, _ := r.GetCertificate(nil); string(cert.Certificate[0]) != string(rotated.Certificate[0]) {
+		t.Errorf("Expected the rotated certificate after the certificate files are rechecked")
+	}
+}
+
+func TestTokenReview(t *testing.T) {
+	reviewCount := int32(0)
+	release := make(chan struct{})
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reviewCount, 1)
+		<-release
+		review := authn.TokenReview{}
+		json.NewDecoder(r.Body).Decode(&review)
+		review.Status = authn.TokenReviewStatus{
+			Authenticated: true,
+			User:          authn.UserInfo{Username: review.Spec.Token},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(review)
+	}))
+	defer apiServer.Close()
+	a := newAuthenticator(&si.Config{
+		WebServerAuthentication: &si.WebServerAuthenticationSpec{TokenReviewEnable: true},
+	}, internal.CreateClient(&rest.Config{Host: apiServer.URL}))
+
+	// The concurrent requests with the same token share one TokenReview, and the
+	// cached token is served while the other token is being reviewed.
+	users := make(chan *userInfo, 3)
+	for i := 0; i < 3; i++ {
+		go func() { users <- a.reviewToken("carol") }()
+	}
+	for atomic.LoadInt32(&reviewCount) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	a.tokenReviewLock.Lock()
+	a.tokenReviewCache["dave"] = cachedUserInfo{
+		user: &userInfo{name: "dave"}, expireAt: time.Now().Add(time.Minute)}
+	a.tokenReviewLock.Unlock()
+	if u := a.reviewToken("dave"); u == nil || u.name != "dave" {
+		t.Errorf("Expected the cached token to be served during the other TokenReview, but got %v", u)
+	}
+	close(release)
+	for i := 0; i < 3; i++ {
+		if u := <-users; u == nil || u.name != "carol" {
+			t.Errorf("Expected the token to be reviewed as carol, but got %v", u)
+		}
+	}
+	if count := atomic.LoadInt32(&reviewCount); count != 1 {
+		t.Errorf("Expected 1 TokenReview for the concurrent requests, but got %v", count)
+	}
+}
+
+// newTestCertificate creates a certificate signed by the parent, or a self-signed
+// CA certificate if the parent is nil.
+func newTestCertificate(
+	t *testing.T, name string, groups []string, parent *tls.Certificate, serial *big.Int) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial == nil {
+		serial = big.NewInt(1)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: groups},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeTestCertificate(t *testing.T, dir string, name string, cert tls.Certificate) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(cert.PrivateKey.(*rsa.PrivateKey))})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}